@@ -0,0 +1,212 @@
+// Package auth keeps the API tokens, the lists they can access and what they
+// are allowed to do with them.
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/carlosmecha/todo/store"
+)
+
+// Scope is something a token is allowed to do
+type Scope string
+
+const (
+	// Read retrieves the files and their history
+	Read Scope = "read"
+
+	// Write changes the files
+	Write Scope = "write"
+
+	// Force overwrites the files without checking the stored revision
+	Force Scope = "force"
+
+	// Admin creates and deletes the lists, and has the other scopes
+	Admin Scope = "admin"
+)
+
+const (
+	// AllLists in the token lists gives access to every list
+	AllLists = "*"
+
+	// DefaultList is the name of the default list in the token lists
+	DefaultList = "/"
+)
+
+// ErrInvalidToken when the token is not in the registry
+var ErrInvalidToken = errors.New("invalid token")
+
+// Token is an API token. Only the salted hash of the secret is kept, see
+// Hash.
+type Token struct {
+	Name   string   `json:"name"`
+	Hash   string   `json:"hash"`
+	Lists  []string `json:"lists"`
+	Scopes []Scope  `json:"scopes"`
+}
+
+// FullAccess returns a token with every scope in every list.
+func FullAccess(name, token string) (Token, error) {
+	hash, err := Hash(token)
+	if err != nil {
+		return Token{}, err
+	}
+
+	return Token{
+		Name:   name,
+		Hash:   hash,
+		Lists:  []string{AllLists},
+		Scopes: []Scope{Admin},
+	}, nil
+}
+
+// Matches returns true if the hash is the one of the token.
+func (t *Token) Matches(token string) bool {
+	return matches(t.Hash, token)
+}
+
+// Can returns true if the token has the scope.
+func (t *Token) Can(scope Scope) bool {
+	for _, s := range t.Scopes {
+		if s == scope || s == Admin {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAccess returns true if the token can access the list, empty for the
+// default one.
+func (t *Token) CanAccess(list string) bool {
+	if list == "" {
+		list = DefaultList
+	}
+	for _, l := range t.Lists {
+		if l == list || l == AllLists {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows returns true if the token has the scope in the list.
+func (t *Token) Allows(list string, scope Scope) bool {
+	return t.CanAccess(list) && t.Can(scope)
+}
+
+// Registry finds the tokens
+type Registry interface {
+
+	// Lookup returns the token or ErrInvalidToken.
+	Lookup(token string) (*Token, error)
+
+	// Named returns the token with the name or ErrInvalidToken, for clients
+	// authenticated by other means like certificates.
+	Named(name string) (*Token, error)
+}
+
+// registry keeps the tokens in memory
+type registry struct {
+	tokens []*Token
+}
+
+// NewRegistry creates the registry with the tokens. Names must be unique.
+func NewRegistry(tokens ...Token) (*registry, error) {
+	r := &registry{
+		tokens: make([]*Token, 0, len(tokens)),
+	}
+
+	names := make(map[string]bool, len(tokens))
+	for i := range tokens {
+		t := tokens[i]
+		if err := validate(t); err != nil {
+			return nil, err
+		}
+		if names[t.Name] {
+			return nil, fmt.Errorf("duplicated token name %s", t.Name)
+		}
+		names[t.Name] = true
+		r.tokens = append(r.tokens, &t)
+	}
+
+	return r, nil
+}
+
+// Load creates the registry with the tokens in the JSON file:
+//
+//	{
+//	    "tokens": [
+//	        {"name": "me", "hash": "...", "lists": ["*"], "scopes": ["admin"]},
+//	        {"name": "team", "hash": "...", "lists": ["/", "team"], "scopes": ["read", "write"]}
+//	    ]
+//	}
+func Load(path string) (*registry, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := struct {
+		Tokens []Token `json:"tokens"`
+	}{}
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nil, err
+	}
+
+	return NewRegistry(config.Tokens...)
+}
+
+// Lookup returns the token or ErrInvalidToken. Every hash is checked, so
+// the time doesn't depend on the token found.
+func (r *registry) Lookup(token string) (*Token, error) {
+	var found *Token
+	for _, t := range r.tokens {
+		if t.Matches(token) && found == nil {
+			found = t
+		}
+	}
+
+	if found == nil || token == "" {
+		return nil, ErrInvalidToken
+	}
+	return found, nil
+}
+
+// Named returns the token with the name or ErrInvalidToken.
+func (r *registry) Named(name string) (*Token, error) {
+	for _, t := range r.tokens {
+		if t.Name == name {
+			return t, nil
+		}
+	}
+	return nil, ErrInvalidToken
+}
+
+// validate checks the token fields.
+func validate(t Token) error {
+	if t.Name == "" {
+		return errors.New("token without name")
+	}
+	if !validHash(t.Hash) {
+		return fmt.Errorf("invalid hash for %s", t.Name)
+	}
+
+	for _, list := range t.Lists {
+		if list != AllLists && list != DefaultList && !store.ValidName(list) {
+			return fmt.Errorf("invalid list %s for %s", list, t.Name)
+		}
+	}
+
+	for _, scope := range t.Scopes {
+		switch scope {
+		case Read, Write, Force, Admin:
+		default:
+			return fmt.Errorf("unknown scope %s for %s", scope, t.Name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newToken creates a token with the hash of the secret.
+func newToken(name, secret string, lists []string, scopes []Scope, t *testing.T) Token {
+	hash, err := Hash(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Token{Name: name, Hash: hash, Lists: lists, Scopes: scopes}
+}
+
+func TestNewRegistry(t *testing.T) {
+
+	all := []string{AllLists}
+	admin := []Scope{Admin}
+
+	cases := []struct {
+		tokens        []Token
+		expectedError bool
+	}{
+		// Valid
+		{
+			tokens: []Token{
+				newToken("me", "secret", all, admin, t),
+				newToken("team", "team", []string{DefaultList, "team"}, []Scope{Read, Write}, t),
+			},
+		},
+		// Duplicated name
+		{
+			tokens:        []Token{newToken("me", "secret", all, admin, t), newToken("me", "other", all, admin, t)},
+			expectedError: true,
+		},
+		// Without name
+		{
+			tokens:        []Token{newToken("", "secret", all, admin, t)},
+			expectedError: true,
+		},
+		// Plain token instead of the hash
+		{
+			tokens:        []Token{{Name: "me", Hash: "secret", Lists: all, Scopes: admin}},
+			expectedError: true,
+		},
+		// Unknown scope
+		{
+			tokens:        []Token{newToken("me", "secret", all, []Scope{"delete"}, t)},
+			expectedError: true,
+		},
+		// Invalid list
+		{
+			tokens:        []Token{newToken("me", "secret", []string{"../foo"}, admin, t)},
+			expectedError: true,
+		},
+	}
+
+	for i, c := range cases {
+		if _, err := NewRegistry(c.tokens...); err != nil && !c.expectedError {
+			t.Fatalf("Unexpected error %s in case %d", err.Error(), i)
+		} else if err == nil && c.expectedError {
+			t.Fatalf("Expected error in case %d", i)
+		}
+	}
+}
+
+func TestAllows(t *testing.T) {
+
+	full, err := FullAccess("me", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registry, err := NewRegistry(
+		full,
+		newToken("team", "team", []string{DefaultList, "team"}, []Scope{Read, Write}, t),
+		newToken("reader", "reader", []string{"team"}, []Scope{Read}, t),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		token    string
+		list     string
+		scope    Scope
+		expected bool
+	}{
+		// Admin
+		{"secret", "", Force, true},
+		{"secret", "other", Admin, true},
+		// Default list
+		{"team", "", Write, true},
+		{"team", "", Force, false},
+		// Named list
+		{"team", "team", Read, true},
+		{"team", "other", Read, false},
+		// Read only
+		{"reader", "team", Read, true},
+		{"reader", "team", Write, false},
+		{"reader", "", Read, false},
+	}
+
+	for _, c := range cases {
+		token, err := registry.Lookup(c.token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := token.Allows(c.list, c.scope); got != c.expected {
+			t.Fatalf("Expected %t, got %t for %+v", c.expected, got, c)
+		}
+	}
+
+	for _, invalid := range []string{"foo", "", full.Hash} {
+		if _, err := registry.Lookup(invalid); err != ErrInvalidToken {
+			t.Fatalf("Expected error %s, got %v for %s", ErrInvalidToken.Error(), err, invalid)
+		}
+	}
+
+	if token, err := registry.Named("team"); err != nil || token.Name != "team" {
+		t.Fatalf("Expected the team token, got %v and %v", token, err)
+	}
+	if _, err := registry.Named("foo"); err != ErrInvalidToken {
+		t.Fatalf("Expected error %s, got %v", ErrInvalidToken.Error(), err)
+	}
+}
+
+func TestHash(t *testing.T) {
+
+	token, err := Mint()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := Hash(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := Hash(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first == second {
+		t.Fatalf("Expected different salts, got %s twice", first)
+	}
+	if strings.Contains(first, token) {
+		t.Fatalf("Expected the hash without the token, got %s", first)
+	}
+
+	if !matches(first, token) || !matches(second, token) {
+		t.Fatalf("Expected the hashes to match the token")
+	}
+	if matches(first, token+"x") || matches("", token) {
+		t.Fatalf("Unexpected match")
+	}
+}
+
+func TestLoad(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	hash, err := Hash("team")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "tokens.json")
+	content := `{"tokens": [{"name": "team", "hash": "` + hash + `", "lists": ["/", "team"], "scopes": ["read", "write"]}]}`
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	registry, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := registry.Lookup("team")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Name != "team" || !token.Allows("team", Write) || token.Allows("team", Force) {
+		t.Fatalf("Unexpected token %+v", token)
+	}
+
+	if _, err := Load(filepath.Join(dir, "missing.json")); err == nil {
+		t.Fatalf("Expected error loading a missing file")
+	}
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	// hashScheme prefixes the hashes, to change the algorithm later
+	hashScheme = "hmac-sha256"
+
+	saltSize  = 16
+	tokenSize = 32
+)
+
+// Mint returns a new random token.
+func Mint() (string, error) {
+	token := make([]byte, tokenSize)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// Hash returns the salted hash of the token to keep in the registry, as
+// hmac-sha256$salt$mac in hex.
+func Hash(token string) (string, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	return hashScheme + "$" + hex.EncodeToString(salt) + "$" + hex.EncodeToString(mac(salt, token)), nil
+}
+
+// matches compares the token with the hash in constant time.
+func matches(hash, token string) bool {
+	salt, sum, ok := parseHash(hash)
+	if !ok {
+		return false
+	}
+	return hmac.Equal(mac(salt, token), sum)
+}
+
+func validHash(hash string) bool {
+	_, _, ok := parseHash(hash)
+	return ok
+}
+
+func parseHash(hash string) ([]byte, []byte, bool) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 3 || parts[0] != hashScheme {
+		return nil, nil, false
+	}
+
+	salt, err := hex.DecodeString(parts[1])
+	if err != nil || len(salt) == 0 {
+		return nil, nil, false
+	}
+
+	sum, err := hex.DecodeString(parts[2])
+	if err != nil || len(sum) != sha256.Size {
+		return nil, nil, false
+	}
+	return salt, sum, true
+}
+
+func mac(salt []byte, token string) []byte {
+	h := hmac.New(sha256.New, salt)
+	h.Write([]byte(token))
+	return h.Sum(nil)
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidShare when the share is malformed or its signature is wrong
+	ErrInvalidShare = errors.New("invalid share")
+
+	// ErrExpiredShare when the share expired
+	ErrExpiredShare = errors.New("expired share")
+)
+
+// Shares signs and verifies read-only links to a list for people without a
+// token
+type Shares interface {
+
+	// Sign returns the share of the list, empty for the default one, valid
+	// until the expiration.
+	Sign(list string, expires time.Time) string
+
+	// Verify returns the list of the share.
+	Verify(share string, now time.Time) (string, error)
+}
+
+// shares signs the shares with HMAC-SHA256, as expiration.list.signature
+type shares struct {
+	key []byte
+}
+
+// NewShares creates the shares signed with the key. Changing the key
+// invalidates the shares.
+func NewShares(key []byte) *shares {
+	return &shares{key: key}
+}
+
+// NewShareKey returns a random key.
+func NewShareKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Sign returns the share of the list valid until the expiration.
+func (s *shares) Sign(list string, expires time.Time) string {
+	payload := strconv.FormatInt(expires.Unix(), 10) + "." + list
+	return payload + "." + base64.RawURLEncoding.EncodeToString(s.sign(payload))
+}
+
+// Verify returns the list of the share.
+func (s *shares) Verify(share string, now time.Time) (string, error) {
+	i := strings.LastIndex(share, ".")
+	if i < 0 {
+		return "", ErrInvalidShare
+	}
+
+	payload := share[:i]
+	signature, err := base64.RawURLEncoding.DecodeString(share[i+1:])
+	if err != nil || !hmac.Equal(signature, s.sign(payload)) {
+		return "", ErrInvalidShare
+	}
+
+	parts := strings.SplitN(payload, ".", 2)
+	if len(parts) != 2 {
+		return "", ErrInvalidShare
+	}
+
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return "", ErrInvalidShare
+	}
+	if now.Unix() >= expires {
+		return "", ErrExpiredShare
+	}
+
+	return parts[1], nil
+}
+
+func (s *shares) sign(payload string) []byte {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte("share\n" + payload))
+	return h.Sum(nil)
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShares(t *testing.T) {
+
+	now := time.Now()
+	shares := NewShares([]byte("key"))
+	other := NewShares([]byte("other"))
+
+	cases := []struct {
+		share         string
+		expectedList  string
+		expectedError error
+	}{
+		// Default list
+		{
+			share: shares.Sign("", now.Add(time.Hour)),
+		},
+		// Named list
+		{
+			share:        shares.Sign("team", now.Add(time.Hour)),
+			expectedList: "team",
+		},
+		// Expired
+		{
+			share:         shares.Sign("team", now.Add(-time.Second)),
+			expectedError: ErrExpiredShare,
+		},
+		// Another key
+		{
+			share:         other.Sign("team", now.Add(time.Hour)),
+			expectedError: ErrInvalidShare,
+		},
+		// Another list
+		{
+			share:         replaceList(shares.Sign("team", now.Add(time.Hour)), "other"),
+			expectedError: ErrInvalidShare,
+		},
+		// Malformed
+		{
+			share:         "foo",
+			expectedError: ErrInvalidShare,
+		},
+		{
+			share:         "",
+			expectedError: ErrInvalidShare,
+		},
+	}
+
+	for i, c := range cases {
+		list, err := shares.Verify(c.share, now)
+		if err != c.expectedError {
+			t.Fatalf("Expected error %v, got %v in case %d", c.expectedError, err, i)
+		}
+		if list != c.expectedList {
+			t.Fatalf("Expected list %s, got %s in case %d", c.expectedList, list, i)
+		}
+	}
+}
+
+// replaceList changes the list keeping the signature.
+func replaceList(share, list string) string {
+	first, last := 0, len(share)
+	for i, c := range share {
+		if c == '.' {
+			if first == 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	return share[:first+1] + list + share[last:]
+}
@@ -0,0 +1,292 @@
+// Package client talks to the TODO server and keeps a local copy of the file
+// in sync with it.
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/carlosmecha/todo/store"
+)
+
+var (
+	// ErrNotModified when the stored revision is the same as provided
+	ErrNotModified = errors.New("not modified")
+
+	// ErrConflict when the stored revision is not the one provided
+	ErrConflict = errors.New("version conflict")
+
+	// ErrNotFound when the file is not stored
+	ErrNotFound = errors.New("not found")
+
+	// ErrUnauthorized when the server rejects the token
+	ErrUnauthorized = errors.New("unauthorized")
+
+	// ErrMergeConflict when the merged file has conflicts to resolve
+	ErrMergeConflict = errors.New("merge conflict")
+
+	// ErrBusy when other writes kept changing the file, it can be tried
+	// again
+	ErrBusy = errors.New("server busy")
+)
+
+// client uses the server HTTP API
+type client struct {
+	url     string
+	token   string
+	http    *http.Client
+	keyring *keyring
+}
+
+// NewClient creates a client for the server address. The list is the name
+// of a named list, empty for the default one.
+func NewClient(addr, token, list string) *client {
+	u := strings.TrimSuffix(addr, "/")
+	if list != "" {
+		u += "/lists/" + url.PathEscape(list)
+	}
+
+	return &client{
+		url:   u + "/",
+		token: token,
+		http:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Head retrieves the stored revision.
+func (c *client) Head() (store.Revision, error) {
+	resp, err := c.do("HEAD", nil, nil)
+	if err != nil {
+		return store.Revision{}, err
+	}
+	resp.Body.Close()
+
+	return revisionOf(resp)
+}
+
+// UseKeyring encrypts the files stored with the keyring, and decrypts the
+// ones retrieved. The server only gets the encrypted files.
+func (c *client) UseKeyring(k *keyring) {
+	c.keyring = k
+}
+
+// Get retrieves the file if the stored revision is not the one with the
+// ETag provided. An empty ETag always retrieves the file.
+func (c *client) Get(etag string, writer io.Writer) (store.Revision, error) {
+	if c.keyring == nil {
+		return c.get(etag, writer)
+	}
+
+	buff := &bytes.Buffer{}
+	revision, err := c.get(etag, buff)
+	if err != nil {
+		return store.Revision{}, err
+	}
+
+	content, err := c.keyring.Decrypt(buff.Bytes())
+	if err != nil {
+		return store.Revision{}, err
+	}
+
+	if _, err := writer.Write(content); err != nil {
+		return store.Revision{}, err
+	}
+	return revision, nil
+}
+
+// get retrieves the file as stored.
+func (c *client) get(etag string, writer io.Writer) (store.Revision, error) {
+	header := http.Header{}
+	if etag != "" {
+		header.Set("If-None-Match", etag)
+	}
+
+	resp, err := c.do("GET", header, nil)
+	if err != nil {
+		return store.Revision{}, err
+	}
+	defer resp.Body.Close()
+
+	revision, err := revisionOf(resp)
+	if err != nil {
+		return store.Revision{}, err
+	}
+
+	if _, err := io.Copy(writer, resp.Body); err != nil {
+		return store.Revision{}, err
+	}
+	return revision, nil
+}
+
+// Put stores the file if the stored revision is still the one with the ETag
+// provided. An empty ETag stores the file only if there's none. Forced puts
+// overwrite any revision.
+func (c *client) Put(etag string, content []byte, force bool) (store.Revision, error) {
+	if c.keyring != nil {
+		encrypted, err := c.keyring.Encrypt(content)
+		if err != nil {
+			return store.Revision{}, err
+		}
+		content = encrypted
+	}
+
+	header := http.Header{}
+	switch {
+	case force:
+		header.Set("Force", "true")
+		header.Set("Last-Modified", time.Now().UTC().Format(time.RFC1123))
+	case etag == "":
+		header.Set("If-None-Match", "*")
+	default:
+		header.Set("If-Match", etag)
+	}
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	resp, err := c.do("PUT", header, content)
+	if err != nil {
+		return store.Revision{}, err
+	}
+	resp.Body.Close()
+
+	return revisionOf(resp)
+}
+
+// Rekey encrypts the stored file again with the current key of the keyring,
+// also if it's stored in plain text. Returns the revision replaced and the
+// new one.
+func (c *client) Rekey() (store.Revision, store.Revision, error) {
+	if c.keyring == nil {
+		return store.Revision{}, store.Revision{}, ErrInvalidKeyring
+	}
+
+	buff := &bytes.Buffer{}
+	previous, err := c.get("", buff)
+	if err != nil {
+		return store.Revision{}, store.Revision{}, err
+	}
+
+	content := buff.Bytes()
+	if _, ok := keyID(content); ok {
+		if content, err = c.keyring.Decrypt(content); err != nil {
+			return store.Revision{}, store.Revision{}, err
+		}
+	}
+
+	revision, err := c.Put(previous.ETag(), content, false)
+	if err != nil {
+		return store.Revision{}, store.Revision{}, err
+	}
+	return previous, revision, nil
+}
+
+// Merge stores the file merging it with the changes stored since the base
+// revision with the ETag provided. Returns the merged file, or the file with
+// conflict markers and ErrMergeConflict along with the stored revision, or
+// ErrBusy if other writes kept changing the file. The server can't merge
+// encrypted files.
+func (c *client) Merge(etag string, content []byte) (store.Revision, []byte, error) {
+	if c.keyring != nil {
+		return store.Revision{}, nil, ErrEncryptedMerge
+	}
+
+	header := http.Header{}
+	header.Set("If-Match", etag)
+	header.Set("Merge", "true")
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	resp, err := c.send("PUT", header, content)
+	if err != nil {
+		return store.Revision{}, nil, err
+	}
+
+	conflicts := resp.StatusCode == 409
+	if !conflicts {
+		if resp, err = c.check(resp); err != nil {
+			return store.Revision{}, nil, err
+		}
+	}
+	defer resp.Body.Close()
+
+	revision, err := revisionOf(resp)
+	if err != nil {
+		return store.Revision{}, nil, err
+	}
+
+	merged, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return store.Revision{}, nil, err
+	}
+
+	if conflicts {
+		return revision, merged, ErrMergeConflict
+	}
+	return revision, merged, nil
+}
+
+// do sends the request and maps the error status codes.
+func (c *client) do(method string, header http.Header, body []byte) (*http.Response, error) {
+	resp, err := c.send(method, header, body)
+	if err != nil {
+		return nil, err
+	}
+	return c.check(resp)
+}
+
+// send sends the request with the token.
+func (c *client) send(method string, header http.Header, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Token", c.token)
+
+	return c.http.Do(req)
+}
+
+// check maps the error status codes, closing the body on errors.
+func (c *client) check(resp *http.Response) (*http.Response, error) {
+	var err error
+	switch resp.StatusCode {
+	case 200, 201, 204:
+		return resp, nil
+	case 304:
+		err = ErrNotModified
+	case 401:
+		err = ErrUnauthorized
+	case 404:
+		err = ErrNotFound
+	case 409, 412:
+		err = ErrConflict
+	case 503:
+		err = ErrBusy
+	default:
+		err = fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	resp.Body.Close()
+	return nil, err
+}
+
+// revisionOf reads the revision from the response headers.
+func revisionOf(resp *http.Response) (store.Revision, error) {
+	revision, err := store.ParseETag(resp.Header.Get("ETag"))
+	if err != nil {
+		return store.Revision{}, err
+	}
+
+	if modified, err := time.Parse(time.RFC1123, resp.Header.Get("Last-Modified")); err == nil {
+		revision.Modified = modified
+	}
+	return revision, nil
+}
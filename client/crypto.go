@@ -0,0 +1,199 @@
+package client
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// KeyIterations is the number of PBKDF2-SHA256 iterations deriving the keys
+// from the passphrases. Changing it breaks decrypting the stored files.
+var KeyIterations = 600000
+
+// encryptedPrefix starts the first line of the encrypted files, followed by
+// the ID of the key. The rest is the salt, the nonce and the ciphertext in
+// base64.
+const encryptedPrefix = "todo-encrypted v1 "
+
+const (
+	saltSize = 16
+	keySize  = 32
+	lineSize = 76
+)
+
+var (
+	// ErrNotEncrypted when the stored file is in plain text
+	ErrNotEncrypted = errors.New("the stored file is not encrypted")
+
+	// ErrUnknownKey when the file is encrypted with a key not in the keyring
+	ErrUnknownKey = errors.New("unknown encryption key")
+
+	// ErrDecrypt when the passphrase is wrong or the file was modified
+	ErrDecrypt = errors.New("can't decrypt the stored file")
+
+	// ErrEncryptedMerge when merging encrypted files, the server can't read them
+	ErrEncryptedMerge = errors.New("encrypted files can't be merged by the server")
+
+	// ErrInvalidKeyring when the keyring has no keys, or they are not valid
+	ErrInvalidKeyring = errors.New("invalid keyring")
+)
+
+// Key is a passphrase and its ID, stored in the encrypted files to know the
+// one decrypting them.
+type Key struct {
+	ID         string `json:"id"`
+	Passphrase string `json:"passphrase"`
+}
+
+// keyring encrypts the files with its first key and decrypts them with any
+// of them, so the keys can be rotated.
+type keyring struct {
+	Keys []Key `json:"keys"`
+}
+
+// NewKeyring creates the keyring, the first key encrypts. The IDs must be
+// unique and have no spaces.
+func NewKeyring(keys ...Key) (*keyring, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: no keys", ErrInvalidKeyring)
+	}
+
+	ids := make(map[string]bool)
+	for _, key := range keys {
+		switch {
+		case key.ID == "" || strings.ContainsAny(key.ID, " \t\r\n"):
+			return nil, fmt.Errorf("%w: invalid ID %q", ErrInvalidKeyring, key.ID)
+		case ids[key.ID]:
+			return nil, fmt.Errorf("%w: duplicated ID %q", ErrInvalidKeyring, key.ID)
+		case key.Passphrase == "":
+			return nil, fmt.Errorf("%w: empty passphrase of %q", ErrInvalidKeyring, key.ID)
+		}
+		ids[key.ID] = true
+	}
+	return &keyring{Keys: keys}, nil
+}
+
+// LoadKeyring reads the keyring from a JSON file, like
+//
+//	{"keys": [{"id": "2024", "passphrase": "..."}, {"id": "2023", "passphrase": "..."}]}
+func LoadKeyring(path string) (*keyring, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	k := &keyring{}
+	if err := json.Unmarshal(content, k); err != nil {
+		return nil, err
+	}
+	return NewKeyring(k.Keys...)
+}
+
+// Encrypt encrypts the content with AES-GCM and the current key, derived
+// with a new salt every time.
+func (k *keyring) Encrypt(content []byte) ([]byte, error) {
+	key := k.Keys[0]
+	header := encryptedPrefix + key.ID
+
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(key.Passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	// The header is authenticated, the key ID can't be swapped
+	sealed := append(salt, nonce...)
+	sealed = aead.Seal(sealed, nonce, content, []byte(header))
+	encoded := base64.StdEncoding.EncodeToString(sealed)
+
+	buff := &bytes.Buffer{}
+	buff.WriteString(header + "\n")
+	for len(encoded) > lineSize {
+		buff.WriteString(encoded[:lineSize] + "\n")
+		encoded = encoded[lineSize:]
+	}
+	buff.WriteString(encoded + "\n")
+	return buff.Bytes(), nil
+}
+
+// Decrypt decrypts the content with the key it was encrypted with.
+func (k *keyring) Decrypt(content []byte) ([]byte, error) {
+	id, ok := keyID(content)
+	if !ok {
+		return nil, ErrNotEncrypted
+	}
+
+	var key *Key
+	for i := range k.Keys {
+		if k.Keys[i].ID == id {
+			key = &k.Keys[i]
+			break
+		}
+	}
+	if key == nil {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+
+	header, body, _ := strings.Cut(string(content), "\n")
+	sealed, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(body), ""))
+	if err != nil || len(sealed) < saltSize {
+		return nil, ErrDecrypt
+	}
+
+	aead, err := newAEAD(key.Passphrase, sealed[:saltSize])
+	if err != nil {
+		return nil, err
+	}
+	sealed = sealed[saltSize:]
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(header))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plain, nil
+}
+
+// keyID returns the ID of the key the content was encrypted with, false if
+// it's not encrypted.
+func keyID(content []byte) (string, bool) {
+	header, _, _ := strings.Cut(string(content), "\n")
+	if !strings.HasPrefix(header, encryptedPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(header, encryptedPrefix), true
+}
+
+// newAEAD derives the key from the passphrase and the salt.
+func newAEAD(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, KeyIterations, keySize)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,223 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carlosmecha/todo/server"
+	"github.com/carlosmecha/todo/store"
+)
+
+func TestKeyring(t *testing.T) {
+
+	KeyIterations = 1000
+
+	old, err := NewKeyring(Key{ID: "old", Passphrase: "hola"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := NewKeyring(Key{ID: "new", Passphrase: "adios"}, Key{ID: "old", Passphrase: "hola"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrong, err := NewKeyring(Key{ID: "old", Passphrase: "adios"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := old.Encrypt([]byte("- [ ] secret task"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		keyring       *keyring
+		content       func() []byte
+		expectedError error
+	}{
+		// Same key
+		{keyring: old, content: func() []byte { return encrypted }},
+		// Old key in the rotated keyring
+		{keyring: rotated, content: func() []byte { return encrypted }},
+		// Wrong passphrase
+		{keyring: wrong, content: func() []byte { return encrypted }, expectedError: ErrDecrypt},
+		// Unknown key
+		{
+			keyring:       old,
+			content:       func() []byte { c, _ := rotated.Encrypt([]byte("- [ ] secret task")); return c },
+			expectedError: ErrUnknownKey,
+		},
+		// Modified
+		{
+			keyring: old,
+			content: func() []byte {
+				lines := strings.Split(string(encrypted), "\n")
+				lines[1] = strings.ToUpper(lines[1])
+				return []byte(strings.Join(lines, "\n"))
+			},
+			expectedError: ErrDecrypt,
+		},
+		// Key ID swapped
+		{
+			keyring: rotated,
+			content: func() []byte {
+				c, _ := rotated.Encrypt([]byte("- [ ] secret task"))
+				return bytes.Replace(c, []byte(" new\n"), []byte(" old\n"), 1)
+			},
+			expectedError: ErrDecrypt,
+		},
+		// Plain text
+		{keyring: old, content: func() []byte { return []byte("- [ ] secret task") }, expectedError: ErrNotEncrypted},
+	}
+
+	for i, c := range cases {
+		content := c.content()
+		if bytes.Contains(content, []byte("secret")) && c.expectedError != ErrNotEncrypted {
+			t.Fatalf("Expected the content encrypted, got %s in case %d", string(content), i)
+		}
+
+		plain, err := c.keyring.Decrypt(content)
+		if !errors.Is(err, c.expectedError) {
+			t.Fatalf("Expected error %v, got %v in case %d", c.expectedError, err, i)
+		}
+		if err == nil && string(plain) != "- [ ] secret task" {
+			t.Fatalf("Expected the task, got %s in case %d", string(plain), i)
+		}
+	}
+
+	for i, keys := range [][]Key{
+		// Empty
+		nil,
+		// Duplicated ID
+		{{ID: "a", Passphrase: "a"}, {ID: "a", Passphrase: "b"}},
+		// Spaces in the ID
+		{{ID: "a b", Passphrase: "a"}},
+		// Empty passphrase
+		{{ID: "a"}},
+	} {
+		if _, err := NewKeyring(keys...); !errors.Is(err, ErrInvalidKeyring) {
+			t.Fatalf("Expected error %s, got %v in case %d", ErrInvalidKeyring.Error(), err, i)
+		}
+	}
+}
+
+func TestEncryptedLocal(t *testing.T) {
+
+	KeyIterations = 1000
+
+	mock := store.NewMemoryStore([]byte("- [ ] plain task"), time.Now(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	ts := httptest.NewServer(server.NewHandler(testTokens(t), nil, mock, nil, slog.New(slog.NewTextHandler(os.Stdout, nil))))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "todo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "todo.md")
+	cl := NewClient(ts.URL, "test", "")
+	l := NewLocal(cl, path)
+
+	// use changes the keyring of the client
+	use := func(keys ...Key) {
+		k, err := NewKeyring(keys...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cl.UseKeyring(k)
+	}
+
+	first := Key{ID: "first", Passphrase: "hola"}
+	second := Key{ID: "second", Passphrase: "adios"}
+
+	cases := []struct {
+		action        func() error
+		expectedError error
+		expectedKey   string
+		expectedLocal string
+	}{
+		// Stored in plain text
+		{
+			action:        func() error { use(first); _, err := l.Pull(false); return err },
+			expectedError: ErrNotEncrypted,
+		},
+		// Encrypting it
+		{
+			action:      func() error { return l.Rekey() },
+			expectedKey: "first",
+		},
+		// Pulling it
+		{
+			action:        func() error { _, err := l.Pull(false); return err },
+			expectedKey:   "first",
+			expectedLocal: "- [ ] plain task",
+		},
+		// Pushing
+		{
+			action: func() error {
+				if err := ioutil.WriteFile(path, []byte("- [ ] secret task"), 0644); err != nil {
+					return err
+				}
+				_, err := l.Push(false)
+				return err
+			},
+			expectedKey:   "first",
+			expectedLocal: "- [ ] secret task",
+		},
+		// Rotating the key
+		{
+			action:        func() error { use(second, first); return l.Rekey() },
+			expectedKey:   "second",
+			expectedLocal: "- [ ] secret task",
+		},
+		// The old key can't decrypt it anymore
+		{
+			action:        func() error { use(first); _, err := cl.Get("", &bytes.Buffer{}); return err },
+			expectedError: ErrUnknownKey,
+			expectedKey:   "second",
+			expectedLocal: "- [ ] secret task",
+		},
+		// Merging
+		{
+			action:        func() error { _, _, err := cl.Merge("", nil); return err },
+			expectedError: ErrEncryptedMerge,
+			expectedKey:   "second",
+			expectedLocal: "- [ ] secret task",
+		},
+	}
+
+	for i, c := range cases {
+		if err := c.action(); !errors.Is(err, c.expectedError) {
+			t.Fatalf("Expected error %v, got %v in case %d", c.expectedError, err, i)
+		}
+
+		buff := &bytes.Buffer{}
+		if _, err := mock.Get(store.Revision{}, buff); err != nil {
+			t.Fatal(err)
+		}
+		if id, _ := keyID(buff.Bytes()); id != c.expectedKey {
+			t.Fatalf("Expected the key %q, got %q in case %d", c.expectedKey, id, i)
+		}
+
+		local, _ := ioutil.ReadFile(path)
+		if string(local) != c.expectedLocal {
+			t.Fatalf("Expected local copy %q, got %q in case %d", c.expectedLocal, string(local), i)
+		}
+
+		// Rekey keeps the local copy in sync
+		if c.expectedLocal != "" {
+			if status, err := l.Status(); err != nil || status != UpToDate {
+				t.Fatalf("Expected up to date, got %s %v in case %d", status, err, i)
+			}
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// StateSuffix is appended to the file name to store the sync state
+const StateSuffix = ".state"
+
+// ErrLocalChanges when pulling would overwrite changes not pushed yet
+var ErrLocalChanges = errors.New("local changes not pushed")
+
+// Status compares the local copy with the stored file
+type Status int
+
+const (
+	// UpToDate when neither the local copy nor the stored file changed
+	UpToDate Status = iota
+
+	// LocalChanges when only the local copy changed since the last sync
+	LocalChanges
+
+	// RemoteChanges when only the stored file changed since the last sync
+	RemoteChanges
+
+	// Diverged when both changed since the last sync
+	Diverged
+)
+
+func (s Status) String() string {
+	switch s {
+	case UpToDate:
+		return "up to date"
+	case LocalChanges:
+		return "local changes to push"
+	case RemoteChanges:
+		return "remote changes to pull"
+	default:
+		return "diverged, local and remote changes"
+	}
+}
+
+// State is the last synced revision, kept in a file next to the local copy.
+type State struct {
+	ETag string `json:"etag"`
+	Hash string `json:"hash"`
+}
+
+// local is a local copy of the stored file
+type local struct {
+	client *client
+	path   string
+}
+
+// NewLocal creates the local copy in the path.
+func NewLocal(c *client, path string) *local {
+	return &local{
+		client: c,
+		path:   path,
+	}
+}
+
+// Status compares the local copy and the stored file with the last sync.
+func (l *local) Status() (Status, error) {
+	state, err := l.state()
+	if err != nil {
+		return 0, err
+	}
+
+	changed, _, err := l.changed(state)
+	if err != nil {
+		return 0, err
+	}
+
+	revision, err := l.client.Head()
+	etag := revision.ETag()
+	if err == ErrNotFound {
+		etag = ""
+	} else if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case changed && etag != state.ETag:
+		return Diverged, nil
+	case changed:
+		return LocalChanges, nil
+	case etag != state.ETag:
+		return RemoteChanges, nil
+	}
+	return UpToDate, nil
+}
+
+// Pull replaces the local copy with the stored file. It fails with local
+// changes unless it's forced. Returns false if it was already up to date.
+func (l *local) Pull(force bool) (bool, error) {
+	state, err := l.state()
+	if err != nil {
+		return false, err
+	}
+
+	changed, _, err := l.changed(state)
+	if err != nil {
+		return false, err
+	}
+
+	etag := state.ETag
+	if changed {
+		if !force {
+			return false, ErrLocalChanges
+		}
+		etag = ""
+	}
+
+	buff := &bytes.Buffer{}
+	revision, err := l.client.Get(etag, buff)
+	if err != nil {
+		if err == ErrNotModified {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if err := replace(l.path, buff.Bytes()); err != nil {
+		return false, err
+	}
+
+	return true, l.save(State{ETag: revision.ETag(), Hash: hash(buff.Bytes())})
+}
+
+// Push stores the local copy if the stored file didn't change since the last
+// sync, or always when it's forced. Returns false if there was nothing to
+// push.
+func (l *local) Push(force bool) (bool, error) {
+	state, err := l.state()
+	if err != nil {
+		return false, err
+	}
+
+	changed, content, err := l.changed(state)
+	if err != nil {
+		return false, err
+	}
+
+	if !changed && !force {
+		return false, nil
+	}
+
+	if content == nil {
+		return false, os.ErrNotExist
+	}
+
+	revision, err := l.client.Put(state.ETag, content, force)
+	if err != nil {
+		return false, err
+	}
+
+	return true, l.save(State{ETag: revision.ETag(), Hash: hash(content)})
+}
+
+// PushMerge stores the local copy merging it with the changes stored since
+// the last sync. The merged file replaces the local copy. If both changed the
+// same lines, the local copy gets the conflict markers, the sync state moves
+// to the stored revision and it returns ErrMergeConflict: once resolved, the
+// next push stores it. Returns false if there was nothing to push.
+func (l *local) PushMerge() (bool, error) {
+	state, err := l.state()
+	if err != nil {
+		return false, err
+	}
+
+	changed, content, err := l.changed(state)
+	if err != nil {
+		return false, err
+	}
+
+	if !changed {
+		return false, nil
+	}
+
+	// Nothing to merge with if it never synced
+	if content == nil || state.ETag == "" {
+		return l.Push(false)
+	}
+
+	revision, merged, err := l.client.Merge(state.ETag, content)
+	if err != nil && err != ErrMergeConflict {
+		return false, err
+	}
+
+	if !bytes.Equal(merged, content) {
+		if err := replace(l.path, merged); err != nil {
+			return false, err
+		}
+	}
+
+	if err == ErrMergeConflict {
+		// The hash never matches, so the resolved copy is always pushed
+		if err := l.save(State{ETag: revision.ETag()}); err != nil {
+			return false, err
+		}
+		return false, ErrMergeConflict
+	}
+
+	return true, l.save(State{ETag: revision.ETag(), Hash: hash(merged)})
+}
+
+// Rekey encrypts the stored file again with the current key. The local copy
+// stays in sync if it was in sync with the file replaced.
+func (l *local) Rekey() error {
+	state, err := l.state()
+	if err != nil {
+		return err
+	}
+
+	previous, revision, err := l.client.Rekey()
+	if err != nil {
+		return err
+	}
+
+	if state.ETag != previous.ETag() {
+		return nil
+	}
+	return l.save(State{ETag: revision.ETag(), Hash: state.Hash})
+}
+
+// changed returns true if the local copy changed since the last sync, and
+// its content. A missing copy has nil content and only changed if it was
+// synced before.
+func (l *local) changed(state State) (bool, []byte, error) {
+	content, err := ioutil.ReadFile(l.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil, nil
+		}
+		return false, nil, err
+	}
+
+	return hash(content) != state.Hash, content, nil
+}
+
+// state reads the last sync state. It's empty if it never synced.
+func (l *local) state() (State, error) {
+	state := State{}
+
+	content, err := ioutil.ReadFile(l.path + StateSuffix)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return state, err
+	}
+
+	if err := json.Unmarshal(content, &state); err != nil {
+		return state, err
+	}
+	return state, nil
+}
+
+// save stores the sync state.
+func (l *local) save(state State) error {
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return replace(l.path+StateSuffix, content)
+}
+
+// replace writes the file in a temporary one and renames it, so the local copy
+// is never partially written.
+func replace(path string, content []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode()
+	}
+
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+func hash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
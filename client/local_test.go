@@ -0,0 +1,301 @@
+package client
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carlosmecha/todo/auth"
+	"github.com/carlosmecha/todo/server"
+	"github.com/carlosmecha/todo/store"
+)
+
+// testTokens returns the registry with the token used in the tests.
+func testTokens(t *testing.T) auth.Registry {
+	full, err := auth.FullAccess("test", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := auth.NewRegistry(full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokens
+}
+
+func TestLocal(t *testing.T) {
+
+	mock := store.NewMemoryStore([]byte("hola"), time.Now(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	ts := httptest.NewServer(server.NewHandler(testTokens(t), nil, mock, nil, slog.New(slog.NewTextHandler(os.Stdout, nil))))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "todo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "todo.md")
+	l := NewLocal(NewClient(ts.URL, "test", ""), path)
+
+	// remote changes the stored file like another client
+	remote := func(content string) {
+		current, _ := mock.GetCurrentVersion()
+		if _, err := mock.SafePut(current, time.Now(), int64(len(content)), bytes.NewReader([]byte(content))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// write changes the local copy
+	write := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		change         func()
+		action         func() (bool, error)
+		expectedResult bool
+		expectedError  error
+		expectedStatus Status
+		expectedLocal  string
+		expectedRemote string
+	}{
+		// First pull
+		{
+			action:         func() (bool, error) { return l.Pull(false) },
+			expectedResult: true,
+			expectedStatus: UpToDate,
+			expectedLocal:  "hola",
+			expectedRemote: "hola",
+		},
+		// Up to date
+		{
+			action:         func() (bool, error) { return l.Pull(false) },
+			expectedStatus: UpToDate,
+			expectedLocal:  "hola",
+			expectedRemote: "hola",
+		},
+		// Nothing to push
+		{
+			action:         func() (bool, error) { return l.Push(false) },
+			expectedStatus: UpToDate,
+			expectedLocal:  "hola",
+			expectedRemote: "hola",
+		},
+		// Push
+		{
+			change:         func() { write("adios") },
+			action:         func() (bool, error) { return l.Push(false) },
+			expectedResult: true,
+			expectedStatus: UpToDate,
+			expectedLocal:  "adios",
+			expectedRemote: "adios",
+		},
+		// Pull remote changes
+		{
+			change:         func() { remote("hello") },
+			action:         func() (bool, error) { return l.Pull(false) },
+			expectedResult: true,
+			expectedStatus: UpToDate,
+			expectedLocal:  "hello",
+			expectedRemote: "hello",
+		},
+		// Push with remote changes
+		{
+			change:         func() { remote("bye"); write("goodbye") },
+			action:         func() (bool, error) { return l.Push(false) },
+			expectedError:  ErrConflict,
+			expectedStatus: Diverged,
+			expectedLocal:  "goodbye",
+			expectedRemote: "bye",
+		},
+		// Pull with local changes
+		{
+			action:         func() (bool, error) { return l.Pull(false) },
+			expectedError:  ErrLocalChanges,
+			expectedStatus: Diverged,
+			expectedLocal:  "goodbye",
+			expectedRemote: "bye",
+		},
+		// Force push
+		{
+			action:         func() (bool, error) { return l.Push(true) },
+			expectedResult: true,
+			expectedStatus: UpToDate,
+			expectedLocal:  "goodbye",
+			expectedRemote: "goodbye",
+		},
+		// Local changes
+		{
+			change:         func() { write("hola") },
+			action:         func() (bool, error) { return false, nil },
+			expectedStatus: LocalChanges,
+			expectedLocal:  "hola",
+			expectedRemote: "goodbye",
+		},
+		// Force pull
+		{
+			change:         func() { remote("adios") },
+			action:         func() (bool, error) { return l.Pull(true) },
+			expectedResult: true,
+			expectedStatus: UpToDate,
+			expectedLocal:  "adios",
+			expectedRemote: "adios",
+		},
+		// Remote changes
+		{
+			change:         func() { remote("hello") },
+			action:         func() (bool, error) { return false, nil },
+			expectedStatus: RemoteChanges,
+			expectedLocal:  "adios",
+			expectedRemote: "hello",
+		},
+		// Merge push without remote changes
+		{
+			change:         func() { remote("a\nb\nc\nd\n"); l.Pull(true); write("a\nb\nc\nD\n") },
+			action:         l.PushMerge,
+			expectedResult: true,
+			expectedStatus: UpToDate,
+			expectedLocal:  "a\nb\nc\nD\n",
+			expectedRemote: "a\nb\nc\nD\n",
+		},
+		// Merge push with remote changes
+		{
+			change:         func() { remote("A\nb\nc\nD\n"); write("a\nb\nC\nD\n") },
+			action:         l.PushMerge,
+			expectedResult: true,
+			expectedStatus: UpToDate,
+			expectedLocal:  "A\nb\nC\nD\n",
+			expectedRemote: "A\nb\nC\nD\n",
+		},
+		// Merge conflict
+		{
+			change:         func() { remote("X\nb\nC\nD\n"); write("Y\nb\nC\nD\n") },
+			action:         l.PushMerge,
+			expectedError:  ErrMergeConflict,
+			expectedStatus: LocalChanges,
+			expectedLocal:  "<<<<<<< stored {remote}\nX\n||||||| base {base}\nA\n=======\nY\n>>>>>>> yours\nb\nC\nD\n",
+			expectedRemote: "X\nb\nC\nD\n",
+		},
+		// Push resolved conflict
+		{
+			change:         func() { write("Z\nb\nC\nD\n") },
+			action:         l.PushMerge,
+			expectedResult: true,
+			expectedStatus: UpToDate,
+			expectedLocal:  "Z\nb\nC\nD\n",
+			expectedRemote: "Z\nb\nC\nD\n",
+		},
+	}
+
+	// The conflict markers have the ETags of the stored and base revisions
+	var base store.Revision
+
+	for i, c := range cases {
+		if c.change != nil {
+			base, _ = mock.GetCurrentVersion()
+			c.change()
+		}
+
+		if got, err := c.action(); err != nil {
+			if c.expectedError == nil {
+				t.Fatalf("Unexpected error %s in case %d", err.Error(), i)
+			} else if c.expectedError != err {
+				t.Fatalf("Expected error %s, got %s in case %d", c.expectedError.Error(), err.Error(), i)
+			}
+		} else if c.expectedError != nil {
+			t.Fatalf("Expected error %s in case %d", c.expectedError.Error(), i)
+		} else if got != c.expectedResult {
+			t.Fatalf("Expected result %t, got %t in case %d", c.expectedResult, got, i)
+		}
+
+		if status, err := l.Status(); err != nil {
+			t.Fatalf("Unexpected error %s in case %d", err.Error(), i)
+		} else if status != c.expectedStatus {
+			t.Fatalf("Expected status %s, got %s in case %d", c.expectedStatus, status, i)
+		}
+
+		local, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		current, _ := mock.GetCurrentVersion()
+		expected := strings.NewReplacer("{remote}", current.ETag(), "{base}", base.ETag()).Replace(c.expectedLocal)
+		if string(local) != expected {
+			t.Fatalf("Expected local copy %s, got %s in case %d", expected, string(local), i)
+		}
+
+		buff := &bytes.Buffer{}
+		if _, err := mock.Get(store.Revision{}, buff); err != nil {
+			t.Fatal(err)
+		}
+		if buff.String() != c.expectedRemote {
+			t.Fatalf("Expected stored file %s, got %s in case %d", c.expectedRemote, buff.String(), i)
+		}
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+
+	ts := httptest.NewServer(server.NewHandler(testTokens(t), nil, store.NewMemoryStore(nil, time.Time{}, slog.New(slog.NewTextHandler(os.Stdout, nil))), nil, slog.New(slog.NewTextHandler(os.Stdout, nil))))
+	defer ts.Close()
+
+	cases := []struct {
+		client        *client
+		expectedError error
+	}{
+		// Not found
+		{
+			client:        NewClient(ts.URL, "test", ""),
+			expectedError: ErrNotFound,
+		},
+		// Unauthorized
+		{
+			client:        NewClient(ts.URL, "foo", ""),
+			expectedError: ErrUnauthorized,
+		},
+		// List not found
+		{
+			client:        NewClient(ts.URL+"/", "test", "team"),
+			expectedError: ErrNotFound,
+		},
+	}
+
+	for _, c := range cases {
+		if _, err := c.client.Get("", &bytes.Buffer{}); err != c.expectedError {
+			t.Fatalf("Expected error %s, got %v", c.expectedError.Error(), err)
+		}
+	}
+
+	// Other writes keep changing the file while merging
+	busy := httptest.NewServer(server.NewHandler(testTokens(t), nil, &conflictingStore{store.NewMemoryStore([]byte("a\n"), time.Now(), slog.New(slog.NewTextHandler(os.Stdout, nil)))}, nil, slog.New(slog.NewTextHandler(os.Stdout, nil))))
+	defer busy.Close()
+
+	cl := NewClient(busy.URL, "test", "")
+	current, err := cl.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := cl.Merge(current.ETag(), []byte("b\n")); err != ErrBusy {
+		t.Fatalf("Expected error %s, got %v", ErrBusy.Error(), err)
+	}
+}
+
+// conflictingStore fails every write with a version conflict
+type conflictingStore struct {
+	store.Store
+}
+
+func (s *conflictingStore) SafePut(revision store.Revision, modified time.Time, contentLength int64, reader io.ReadSeeker) (store.Revision, error) {
+	return store.Revision{}, store.ErrVersionConflict
+}
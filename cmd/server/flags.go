@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/carlosmecha/todo/config"
+)
+
+// flags are the command line options overriding the configuration keys.
+// Ports listen in all the interfaces.
+var flags = []struct {
+	name  string
+	key   string
+	port  bool
+	usage string
+}{
+	{"token", "auth.token", false, "Authentication token with full access"},
+	{"tokens", "auth.tokens", false, "JSON file with the API tokens and their scopes, instead of -token"},
+	{"share-key", "auth.share_key", false, "Key signing the share links, a random one if empty"},
+	{"backend", "backend.type", false, "Storage backend (s3, file or memory)"},
+	{"bucket", "backend.bucket", false, "S3 bucket"},
+	{"key", "backend.key", false, "S3 key"},
+	{"region", "backend.region", false, "S3 region, defaults to $AWS_REGION"},
+	{"path", "backend.path", false, "File path for the file backend"},
+	{"lists", "backend.lists", false, "Key prefix (s3) or directory (file) of the named lists"},
+	{"sse", "backend.sse", false, "S3 server-side encryption (sse-s3, sse-kms or sse-c), the bucket default if empty"},
+	{"sse-kms-key-id", "backend.sse_kms_key_id", false, "KMS key of sse-kms, the default one if empty"},
+	{"sse-customer-key", "backend.sse_customer_key", false, "File with the sse-c key, 32 bytes or base64"},
+	{"listen", "server.listen", false, "Address serving the lists"},
+	{"port", "server.listen", true, "HTTP port, instead of -listen"},
+	{"redirect-port", "server.redirect_listen", true, "HTTP port redirecting to HTTPS"},
+	{"metrics-port", "server.metrics_listen", true, "HTTP port serving the Prometheus metrics in /metrics"},
+	{"tls-cert", "tls.cert", false, "Certificate file to serve HTTPS, reloaded when it changes or on SIGHUP"},
+	{"tls-key", "tls.key", false, "Key file of the certificate"},
+	{"client-ca", "tls.client_ca", false, "CA file of the client certificates, their common name is the token name"},
+	{"log-format", "log.format", false, "Log format (text, as logfmt, or json)"},
+	{"log-level", "log.level", false, "Minimum log level (debug, info, warn or error)"},
+	{"shutdown-drain", "shutdown.drain", false, "Time failing the readiness probes before shutting down"},
+	{"shutdown-timeout", "shutdown.timeout", false, "Longest time the requests in progress can take to finish on shutdown"},
+}
+
+// setFlag changes the configuration key of the flag, if it's one of them.
+func setFlag(cfg *config.Config, f *flag.Flag) error {
+	for _, option := range flags {
+		if option.name != f.Name {
+			continue
+		}
+
+		value := f.Value.String()
+		if option.port {
+			port, err := strconv.Atoi(value)
+			if err != nil || port <= 0 || port > 65535 {
+				return fmt.Errorf("-%s: invalid port %s", f.Name, value)
+			}
+			value = fmt.Sprintf("0.0.0.0:%d", port)
+		}
+		return cfg.Set(option.key, value)
+	}
+	return nil
+}
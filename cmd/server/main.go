@@ -2,46 +2,225 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
+	"github.com/carlosmecha/todo/auth"
+	"github.com/carlosmecha/todo/config"
+	"github.com/carlosmecha/todo/metrics"
 	"github.com/carlosmecha/todo/server"
 	"github.com/carlosmecha/todo/store"
 )
 
 func main() {
 
-	token := flag.String("token", "", "Authentication token")
-	bucket := flag.String("bucket", "cmecha-cloud", "S3 bucket")
-	key := flag.String("key", "todo.md", "S3 key")
-	region := flag.String("region", "us-west-2", "S3 region")
-	port := flag.Int("port", 80, "HTTP port")
+	if len(os.Args) > 1 && os.Args[1] == "token" {
+		os.Exit(tokenCommand(os.Args[2:]))
+	}
+
+	configPath := flag.String("config", "", "TOML configuration file, overridden by the $TODO_SECTION_KEY variables and the flags")
+	printConfig := flag.Bool("print-config", false, "Print the configuration with the secrets redacted and exit")
+	for _, f := range flags {
+		flag.String(f.name, "", f.usage)
+	}
 
 	flag.Parse()
 
-	if len(*token) == 0 {
-		t := os.Getenv("TOKEN")
-		if len(t) == 0 {
-			fmt.Printf("Authentication token required")
+	cfg := config.Default()
+	if *configPath != "" {
+		var err error
+		if cfg, err = config.Load(*configPath); err != nil {
+			fmt.Printf("Error loading the configuration: %s\n", err.Error())
 			os.Exit(1)
 		}
-		*token = t
 	}
 
-	logger := log.New(os.Stdout, "", log.LstdFlags)
-	logger.Printf("Starting server in port %d", *port)
+	if err := cfg.Environ(os.Environ()); err != nil {
+		fmt.Printf("Invalid environment variable: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	var err error
+	flag.Visit(func(f *flag.Flag) {
+		if err == nil {
+			err = setFlag(cfg, f)
+		}
+	})
+	if err != nil {
+		fmt.Printf("Invalid flag: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	invalid := cfg.Validate()
+	if *printConfig {
+		cfg.Print(os.Stdout)
+	}
+	if invalid != nil {
+		fmt.Printf("Invalid configuration:\n%s\n", invalid.Error())
+		os.Exit(1)
+	}
+	if *printConfig {
+		return
+	}
+
+	var tokens auth.Registry
+	if cfg.Auth.Tokens != "" {
+		tokens, err = auth.Load(cfg.Auth.Tokens)
+	} else {
+		var full auth.Token
+		if full, err = auth.FullAccess("default", cfg.Auth.Token); err == nil {
+			tokens, err = auth.NewRegistry(full)
+		}
+	}
+	if err != nil {
+		fmt.Printf("Invalid tokens: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	logger := newLogger(cfg.Log)
+
+	server.SizeLimit = cfg.Limits.BodySize
+	server.WaitLimit = cfg.Limits.Wait
+	server.ShareLimit = cfg.Limits.Share
+
+	signingKey := []byte(cfg.Auth.ShareKey)
+	if len(signingKey) == 0 {
+		logger.Warn("Share key not defined, the share links won't work after restarting")
+		if signingKey, err = auth.NewShareKey(); err != nil {
+			fmt.Printf("Error creating the share key: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+	logger.Info("Starting server", "address", cfg.Server.Listen)
 
-	s := store.NewStore(*bucket, *key, *region, logger)
-	http := server.RunServer(*token, fmt.Sprintf("0.0.0.0:%d", *port), s, logger)
+	var registry *metrics.Registry
+	if cfg.Server.MetricsListen != "" {
+		registry = metrics.NewRegistry()
+	}
 
-	stop := make(chan os.Signal, 1)
-	defer close(stop)
-	signal.Notify(stop, os.Interrupt)
-	<-stop
+	var s store.Store
+	var lists store.Lists
+	backend := cfg.Backend
+	switch backend.Type {
+	case "s3":
+		s3Store := store.NewStore(backend.Bucket, backend.Key, backend.Region, logger)
+		s3Lists := store.NewLists(backend.Bucket, backend.Lists, backend.Region, logger)
 
-	http.Shutdown(context.Background())
-	logger.Print("Server stopped")
+		encryption := store.Encryption{Mode: backend.SSE, KMSKeyID: backend.SSEKMSKeyID}
+		if backend.SSECustomerKey != "" {
+			if encryption.CustomerKey, err = store.LoadCustomerKey(backend.SSECustomerKey); err != nil {
+				fmt.Printf("Error loading the SSE-C key: %s\n", err.Error())
+				os.Exit(1)
+			}
+		}
+		if err := s3Store.Encrypt(encryption); err != nil {
+			fmt.Printf("Invalid server-side encryption: %s\n", err.Error())
+			os.Exit(1)
+		}
+		if err := s3Lists.Encrypt(encryption); err != nil {
+			fmt.Printf("Invalid server-side encryption: %s\n", err.Error())
+			os.Exit(1)
+		}
+		if registry != nil {
+			observer := server.StoreObserver(registry)
+			s3Store.Observe(observer)
+			s3Lists.Observe(observer)
+		}
+		s, lists = s3Store, s3Lists
+	case "file":
+		s = store.NewFileStore(backend.Path, logger)
+		lists = store.NewFileLists(backend.Lists, logger)
+	case "memory":
+		s = store.NewMemoryStore(nil, time.Time{}, logger)
+		lists = store.NewMemoryLists(logger)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	var tlsConfig *tls.Config
+	if cfg.TLS.Cert != "" {
+		cert, err := server.NewCertificate(cfg.TLS.Cert, cfg.TLS.Key, logger)
+		if err != nil {
+			fmt.Printf("Error loading the certificate: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		if tlsConfig, err = server.TLSConfig(cert, cfg.TLS.ClientCA); err != nil {
+			fmt.Printf("Error loading the client CA: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		go cert.Watch(time.Minute, done)
+
+		hangup := make(chan os.Signal, 1)
+		signal.Notify(hangup, syscall.SIGHUP)
+		go func() {
+			for range hangup {
+				if err := cert.Reload(); err != nil {
+					logger.Error("Error reloading the certificate", "error", err)
+				}
+			}
+		}()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var metricsServer *http.Server
+	if registry != nil {
+		logger.Info("Serving the metrics", "address", cfg.Server.MetricsListen)
+		metricsServer = server.RunMetrics(cfg.Server.MetricsListen, registry, logger)
+	}
+
+	var redirect *http.Server
+	if cfg.Server.RedirectListen != "" {
+		// The address is validated
+		_, port, _ := net.SplitHostPort(cfg.Server.Listen)
+		httpsPort, _ := strconv.Atoi(port)
+
+		logger.Info("Redirecting to HTTPS", "address", cfg.Server.RedirectListen)
+		redirect = server.RunRedirect(cfg.Server.RedirectListen, httpsPort, logger)
+	}
+
+	shutdown := server.Shutdown{Drain: cfg.Shutdown.Drain, Timeout: cfg.Shutdown.Timeout}
+	err = server.RunServer(ctx, tokens, auth.NewShares(signingKey), cfg.Server.Listen, tlsConfig, s, lists, registry, shutdown, logger)
+
+	// The other servers don't hold long requests
+	timeout, cancel := context.WithTimeout(context.Background(), cfg.Shutdown.Timeout)
+	defer cancel()
+	if redirect != nil {
+		redirect.Shutdown(timeout)
+	}
+	if metricsServer != nil {
+		metricsServer.Shutdown(timeout)
+	}
+
+	if err != nil {
+		logger.Error("Error running the server", "error", err)
+		os.Exit(1)
+	}
+	logger.Info("Server stopped")
+}
+
+// newLogger creates the logger writing to the standard output with the
+// validated options.
+func newLogger(options config.Log) *slog.Logger {
+	var level slog.Level
+	level.UnmarshalText([]byte(options.Level))
+
+	handlerOptions := &slog.HandlerOptions{Level: level}
+	if options.Format == "json" {
+		return slog.New(slog.NewJSONHandler(os.Stdout, handlerOptions))
+	}
+	return slog.New(slog.NewTextHandler(os.Stdout, handlerOptions))
 }
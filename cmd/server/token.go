@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/carlosmecha/todo/auth"
+)
+
+const tokenUsage = `Usage: server token <command> [flags]
+
+Commands:
+  mint    creates a new token and prints it with its entry for the tokens file
+  hash    reads a token from the standard input and prints its hash
+
+Flags:
+`
+
+// tokenCommand mints and hashes tokens for the tokens file. Only the hashes
+// are stored, the tokens are printed once.
+func tokenCommand(args []string) int {
+
+	flags := flag.NewFlagSet("token", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, tokenUsage)
+		flags.PrintDefaults()
+	}
+
+	name := flags.String("name", "", "Token name, required to mint")
+	lists := flags.String("lists", auth.AllLists, "Comma separated lists the token can access, / is the default list")
+	scopes := flags.String("scopes", "read,write", "Comma separated scopes: read, write, force and admin")
+
+	if len(args) == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	command := args[0]
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	switch command {
+	case "mint":
+		if *name == "" {
+			fmt.Fprintln(os.Stderr, "Token name not defined")
+			return 2
+		}
+
+		token, err := auth.Mint()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error minting the token: %s\n", err.Error())
+			return 1
+		}
+
+		entry, err := tokenEntry(*name, token, split(*lists), split(*scopes))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid token: %s\n", err.Error())
+			return 1
+		}
+
+		fmt.Printf("Token: %s\n", token)
+		fmt.Printf("Entry: %s\n", entry)
+		return 0
+
+	case "hash":
+		// Read from the input to keep the token out of the shell history
+		// The last line may not end
+		token, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		token = strings.TrimSpace(token)
+		if token == "" {
+			fmt.Fprintln(os.Stderr, "Token not provided in the standard input")
+			return 2
+		}
+
+		hash, err := auth.Hash(token)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error hashing the token: %s\n", err.Error())
+			return 1
+		}
+
+		fmt.Println(hash)
+		return 0
+
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %s\n", command)
+		flags.Usage()
+		return 2
+	}
+}
+
+// tokenEntry returns the token as JSON for the tokens file.
+func tokenEntry(name, token string, lists, scopes []string) (string, error) {
+	hash, err := auth.Hash(token)
+	if err != nil {
+		return "", err
+	}
+
+	entry := auth.Token{
+		Name:  name,
+		Hash:  hash,
+		Lists: lists,
+	}
+	for _, scope := range scopes {
+		entry.Scopes = append(entry.Scopes, auth.Scope(scope))
+	}
+
+	// Validates the entry
+	if _, err := auth.NewRegistry(entry); err != nil {
+		return "", err
+	}
+
+	content, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+func split(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/carlosmecha/todo/client"
+)
+
+// Exit codes
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitUsage    = 2
+	exitConflict = 3
+	exitChanges  = 4
+)
+
+const usage = `Usage: todo [flags] <command>
+
+Keeps a local copy of the TODO list in sync with the server.
+
+Commands:
+  pull     replaces the local copy with the stored one
+  push     stores the local copy
+  edit     pulls, opens the editor and pushes the changes
+  status   compares the local copy with the stored one
+  rekey    encrypts the stored file again with the current key
+
+Exit codes:
+  0  success, or up to date for status
+  1  error
+  2  invalid usage
+  3  conflict, both copies changed since the last sync, or the merge has
+     conflicts to resolve in the local copy
+  4  status found changes to push or pull
+
+Encryption:
+  With -keyring, or $TODO_PASSPHRASE, the file is encrypted before pushing
+  and decrypted after pulling, the server only stores the encrypted file.
+  The keyring is a JSON file with the keys, the first one encrypts:
+
+    {"keys": [{"id": "2024", "passphrase": "..."}, {"id": "2023", "passphrase": "..."}]}
+
+  To rotate the key, add the new one first and run rekey. Rekey also
+  encrypts a file stored in plain text.
+
+Flags:
+`
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+
+	flags := flag.NewFlagSet("todo", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flags.PrintDefaults()
+	}
+
+	addr := flags.String("addr", os.Getenv("TODO_ADDR"), "Server address, defaults to $TODO_ADDR")
+	token := flags.String("token", os.Getenv("TODO_TOKEN"), "Authentication token, defaults to $TODO_TOKEN")
+	file := flags.String("file", os.Getenv("TODO_FILE"), "Local copy, defaults to $TODO_FILE")
+	list := flags.String("list", "", "Named list, the default list if empty")
+	force := flags.Bool("force", false, "Pull over local changes or push over remote changes")
+	merge := flags.Bool("merge", false, "Merge the local changes with the remote ones when pushing")
+	ca := flags.String("ca", os.Getenv("TODO_CA"), "CA file of the server certificate, defaults to $TODO_CA")
+	cert := flags.String("cert", os.Getenv("TODO_CERT"), "Client certificate file instead of the token, defaults to $TODO_CERT")
+	key := flags.String("key", os.Getenv("TODO_KEY"), "Client certificate key file, defaults to $TODO_KEY")
+	keyringFile := flags.String("keyring", os.Getenv("TODO_KEYRING"), "JSON file with the encryption keys, defaults to $TODO_KEYRING")
+
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	for _, required := range []struct {
+		value   bool
+		message string
+	}{
+		{*addr != "", "Server address not defined"},
+		{*token != "" || *cert != "", "Token not defined"},
+		{*file != "", "Todo file not defined"},
+	} {
+		if !required.value {
+			fmt.Fprintln(os.Stderr, required.message)
+			return exitUsage
+		}
+	}
+
+	c := client.NewClient(*addr, *token, *list)
+	if *ca != "" || *cert != "" || *key != "" {
+		config, err := client.TLSConfig(*ca, *cert, *key)
+		if err != nil {
+			return fail("Error loading the certificates", err)
+		}
+		c.UseTLS(config)
+	}
+
+	encrypted := false
+	if *keyringFile != "" {
+		k, err := client.LoadKeyring(*keyringFile)
+		if err != nil {
+			return fail("Error loading the keyring", err)
+		}
+		c.UseKeyring(k)
+		encrypted = true
+	} else if passphrase := os.Getenv("TODO_PASSPHRASE"); passphrase != "" {
+		k, err := client.NewKeyring(client.Key{ID: "default", Passphrase: passphrase})
+		if err != nil {
+			return fail("Error loading the keyring", err)
+		}
+		c.UseKeyring(k)
+		encrypted = true
+	}
+
+	// The server can't read the encrypted files to merge them
+	if *merge && encrypted {
+		fmt.Fprintln(os.Stderr, "Encrypted files can't be merged")
+		return exitUsage
+	}
+
+	l := client.NewLocal(c, *file)
+
+	switch flags.Arg(0) {
+	case "pull":
+		return pull(l, *force)
+	case "push":
+		return push(l, *force, *merge)
+	case "edit":
+		return edit(l, *file, *force, *merge)
+	case "status":
+		return status(l)
+	case "rekey":
+		if !encrypted {
+			fmt.Fprintln(os.Stderr, "Keyring not defined")
+			return exitUsage
+		}
+		return rekey(l)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %s\n", flags.Arg(0))
+		flags.Usage()
+		return exitUsage
+	}
+}
+
+func pull(l syncer, force bool) int {
+	pulled, err := l.Pull(force)
+	if err != nil {
+		return fail("Error pulling the file", err)
+	}
+
+	if pulled {
+		fmt.Println("Pulled the stored file")
+	} else {
+		fmt.Println("Already up to date")
+	}
+	return exitOK
+}
+
+func push(l syncer, force, merge bool) int {
+	var pushed bool
+	var err error
+	if merge && !force {
+		pushed, err = l.PushMerge()
+	} else {
+		pushed, err = l.Push(force)
+	}
+	if err != nil {
+		return fail("Error pushing the file", err)
+	}
+
+	if pushed {
+		fmt.Println("Pushed the local changes")
+	} else {
+		fmt.Println("Nothing to push")
+	}
+	return exitOK
+}
+
+func edit(l syncer, file string, force, merge bool) int {
+	// Local changes not pushed yet are pushed after editing
+	if _, err := l.Pull(force); err != nil && err != client.ErrNotFound && err != client.ErrLocalChanges {
+		return fail("Error pulling the file", err)
+	}
+
+	editor := os.Getenv("TODO_EDITOR")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	cmd := exec.Command(editor, file)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fail("Error running the editor", err)
+	}
+
+	return push(l, false, merge)
+}
+
+func status(l syncer) int {
+	s, err := l.Status()
+	if err != nil {
+		return fail("Error getting the status", err)
+	}
+
+	fmt.Println(s.String())
+	switch s {
+	case client.UpToDate:
+		return exitOK
+	case client.Diverged:
+		return exitConflict
+	default:
+		return exitChanges
+	}
+}
+
+func rekey(l syncer) int {
+	if err := l.Rekey(); err != nil {
+		return fail("Error encrypting the file again", err)
+	}
+
+	fmt.Println("Encrypted the stored file with the current key")
+	return exitOK
+}
+
+// fail prints the error and returns its exit code.
+func fail(message string, err error) int {
+	switch err {
+	case client.ErrConflict:
+		fmt.Fprintf(os.Stderr, "%s: the stored file changed since the last sync, pull it first\n", message)
+		return exitConflict
+	case client.ErrMergeConflict:
+		fmt.Fprintf(os.Stderr, "%s: the merge has conflicts, resolve them in the local copy and push it\n", message)
+		return exitConflict
+	case client.ErrBusy:
+		fmt.Fprintf(os.Stderr, "%s: other writes kept changing the stored file, try again\n", message)
+		return exitError
+	case client.ErrLocalChanges:
+		fmt.Fprintf(os.Stderr, "%s: the local copy changed since the last sync, push it first\n", message)
+		return exitConflict
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: %s\n", message, err.Error())
+	return exitError
+}
+
+// syncer keeps the local copy of the file in sync
+type syncer interface {
+	Status() (client.Status, error)
+	Pull(bool) (bool, error)
+	Push(bool) (bool, error)
+	PushMerge() (bool, error)
+	Rekey() error
+}
@@ -0,0 +1,387 @@
+// Package config loads the configuration of the server from a TOML file, the
+// environment and the command line, in that order.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvPrefix is the prefix of the environment variables overriding the
+// configuration, followed by the section and the key, like TODO_BACKEND_BUCKET
+const EnvPrefix = "TODO_"
+
+// redacted replaces the secrets when printing the configuration
+const redacted = "REDACTED"
+
+// ErrUnknownKey when the key is not in the configuration
+var ErrUnknownKey = errors.New("unknown key")
+
+// aliases are the environment variables of the older versions, overridden by
+// the ones with the prefix
+var aliases = map[string]string{
+	"TOKEN":      "auth.token",
+	"SHARE_KEY":  "auth.share_key",
+	"AWS_REGION": "backend.region",
+}
+
+// Config is the configuration of the server
+type Config struct {
+	Server   Server   `toml:"server"`
+	Backend  Backend  `toml:"backend"`
+	Auth     Auth     `toml:"auth"`
+	TLS      TLS      `toml:"tls"`
+	Log      Log      `toml:"log"`
+	Limits   Limits   `toml:"limits"`
+	Shutdown Shutdown `toml:"shutdown"`
+}
+
+// Server has the addresses the server listens to
+type Server struct {
+	// Listen is the address serving the lists
+	Listen string `toml:"listen"`
+
+	// RedirectListen is the address redirecting to HTTPS, disabled if empty
+	RedirectListen string `toml:"redirect_listen"`
+
+	// MetricsListen is the address serving the metrics, disabled if empty
+	MetricsListen string `toml:"metrics_listen"`
+}
+
+// Backend is where the lists are stored
+type Backend struct {
+	// Type is s3, file or memory
+	Type string `toml:"type"`
+
+	// Bucket, Key and Region of the default list in S3
+	Bucket string `toml:"bucket"`
+	Key    string `toml:"key"`
+	Region string `toml:"region"`
+
+	// Path of the default list in the file backend
+	Path string `toml:"path"`
+
+	// Lists is the key prefix in S3, or the directory, of the named lists
+	Lists string `toml:"lists"`
+
+	// SSE is the server-side encryption in S3: sse-s3, sse-kms, sse-c or
+	// empty for the default of the bucket
+	SSE string `toml:"sse"`
+
+	// SSEKMSKeyID is the KMS key of sse-kms, the default one if empty
+	SSEKMSKeyID string `toml:"sse_kms_key_id"`
+
+	// SSECustomerKey is the file with the key of sse-c, 32 bytes or base64
+	SSECustomerKey string `toml:"sse_customer_key"`
+}
+
+// Auth has the tokens and the key signing the share links
+type Auth struct {
+	// Token has full access, instead of the tokens file
+	Token string `toml:"token" secret:"true"`
+
+	// Tokens is the JSON file with the tokens and their scopes
+	Tokens string `toml:"tokens"`
+
+	// ShareKey signs the share links, a random one is used if it's empty
+	ShareKey string `toml:"share_key" secret:"true"`
+}
+
+// TLS has the files to serve HTTPS
+type TLS struct {
+	Cert     string `toml:"cert"`
+	Key      string `toml:"key"`
+	ClientCA string `toml:"client_ca"`
+}
+
+// Log has the format, text or json, and the minimum level of the logs
+type Log struct {
+	Format string `toml:"format"`
+	Level  string `toml:"level"`
+}
+
+// Limits of the requests
+type Limits struct {
+	// BodySize is the max size of the request body in bytes
+	BodySize int64 `toml:"body_size"`
+
+	// Wait is the longest a request can wait for a new revision
+	Wait time.Duration `toml:"wait"`
+
+	// Share is the longest time the share links can be valid
+	Share time.Duration `toml:"share"`
+}
+
+// Shutdown is how the server stops on SIGINT or SIGTERM
+type Shutdown struct {
+	// Drain is how long the readiness probes fail before stopping accepting
+	// requests
+	Drain time.Duration `toml:"drain"`
+
+	// Timeout is the longest the requests in progress can take to finish
+	Timeout time.Duration `toml:"timeout"`
+}
+
+// Default returns the configuration used without file, environment or flags.
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Listen: "0.0.0.0:80",
+		},
+		Backend: Backend{
+			Type:  "s3",
+			Key:   "todo.md",
+			Path:  "todo.md",
+			Lists: "lists/",
+		},
+		Log: Log{
+			Format: "text",
+			Level:  "info",
+		},
+		Limits: Limits{
+			BodySize: 1024 * 1024,
+			Wait:     5 * time.Minute,
+			Share:    30 * 24 * time.Hour,
+		},
+		Shutdown: Shutdown{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+// Set changes the value of the key, as section.key.
+func (c *Config) Set(key, value string) error {
+	field, err := c.field(key)
+	if err != nil {
+		return err
+	}
+
+	switch field.Interface().(type) {
+	case string:
+		field.SetString(value)
+	case time.Duration:
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%s: invalid duration %q", key, value)
+		}
+		field.SetInt(int64(duration))
+	case int64:
+		number, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s: invalid number %q", key, value)
+		}
+		field.SetInt(number)
+	}
+	return nil
+}
+
+// Environ overrides the keys with the environment variables, as KEY=value.
+func (c *Config) Environ(environ []string) error {
+	values := make(map[string]string)
+	for _, variable := range environ {
+		if i := strings.Index(variable, "="); i > 0 {
+			values[variable[:i]] = variable[i+1:]
+		}
+	}
+
+	for name, key := range aliases {
+		if value := values[name]; value != "" {
+			if err := c.Set(key, value); err != nil {
+				return err
+			}
+		}
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		if strings.HasPrefix(name, EnvPrefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := values[name]
+
+		// The keys have underscores too. The client variables share the
+		// prefix, so only the ones of the sections are used.
+		path := strings.ToLower(strings.TrimPrefix(name, EnvPrefix))
+		i := strings.Index(path, "_")
+		if i < 0 {
+			continue
+		}
+		if _, ok := lookup(reflect.ValueOf(c).Elem(), path[:i]); !ok {
+			continue
+		}
+		if err := c.Set(path[:i]+"."+path[i+1:], value); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Validate returns the errors of the configuration, all of them.
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if _, _, err := net.SplitHostPort(c.Server.Listen); err != nil {
+		invalid("server.listen: invalid address %q", c.Server.Listen)
+	}
+	for key, addr := range []string{c.Server.RedirectListen, c.Server.MetricsListen} {
+		if _, _, err := net.SplitHostPort(addr); err != nil && addr != "" {
+			invalid("server.%s: invalid address %q", []string{"redirect_listen", "metrics_listen"}[key], addr)
+		}
+	}
+
+	switch c.Backend.Type {
+	case "s3":
+		if c.Backend.Bucket == "" {
+			invalid("backend.bucket: required by the s3 backend")
+		}
+		if c.Backend.Region == "" {
+			invalid("backend.region: required by the s3 backend")
+		}
+		if c.Backend.Key == "" {
+			invalid("backend.key: required by the s3 backend")
+		}
+		switch c.Backend.SSE {
+		case "", "sse-s3", "sse-kms", "sse-c":
+		default:
+			invalid("backend.sse: unknown encryption %q", c.Backend.SSE)
+		}
+		if c.Backend.SSEKMSKeyID != "" && c.Backend.SSE != "sse-kms" {
+			invalid("backend.sse_kms_key_id: requires sse-kms")
+		}
+		if (c.Backend.SSECustomerKey != "") != (c.Backend.SSE == "sse-c") {
+			invalid("backend.sse_customer_key: required by sse-c, and only by it")
+		}
+	case "file":
+		if c.Backend.Path == "" {
+			invalid("backend.path: required by the file backend")
+		}
+	case "memory":
+	default:
+		invalid("backend.type: unknown backend %q", c.Backend.Type)
+	}
+
+	switch {
+	case c.Auth.Token == "" && c.Auth.Tokens == "":
+		invalid("auth: token or tokens required")
+	case c.Auth.Token != "" && c.Auth.Tokens != "":
+		invalid("auth: token and tokens can't be used together")
+	}
+
+	if (c.TLS.Cert == "") != (c.TLS.Key == "") {
+		invalid("tls: cert and key required")
+	}
+	if c.TLS.ClientCA != "" && c.TLS.Cert == "" {
+		invalid("tls.client_ca: requires HTTPS")
+	}
+	if c.Server.RedirectListen != "" && c.TLS.Cert == "" {
+		invalid("server.redirect_listen: requires HTTPS")
+	}
+
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		invalid("log.format: unknown format %q", c.Log.Format)
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		invalid("log.level: unknown level %q", c.Log.Level)
+	}
+
+	if c.Limits.BodySize <= 0 {
+		invalid("limits.body_size: must be positive")
+	}
+	if c.Limits.Wait < 0 {
+		invalid("limits.wait: can't be negative")
+	}
+	if c.Limits.Share <= 0 {
+		invalid("limits.share: must be positive")
+	}
+
+	if c.Shutdown.Drain < 0 {
+		invalid("shutdown.drain: can't be negative")
+	}
+	if c.Shutdown.Timeout <= 0 {
+		invalid("shutdown.timeout: must be positive")
+	}
+
+	return errors.Join(errs...)
+}
+
+// Print writes the configuration as a TOML file, with the secrets redacted.
+func (c *Config) Print(w io.Writer) error {
+	sections := reflect.ValueOf(c).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		if i > 0 {
+			if _, err := fmt.Fprintln(w); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "[%s]\n", sections.Type().Field(i).Tag.Get("toml")); err != nil {
+			return err
+		}
+
+		section := sections.Field(i)
+		for j := 0; j < section.NumField(); j++ {
+			field := section.Type().Field(j)
+
+			var value string
+			switch v := section.Field(j).Interface().(type) {
+			case string:
+				if v != "" && field.Tag.Get("secret") == "true" {
+					v = redacted
+				}
+				value = strconv.Quote(v)
+			case time.Duration:
+				value = strconv.Quote(v.String())
+			case int64:
+				value = strconv.FormatInt(v, 10)
+			}
+
+			if _, err := fmt.Fprintf(w, "%s = %s\n", field.Tag.Get("toml"), value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// field returns the field of the key, as section.key.
+func (c *Config) field(key string) (reflect.Value, error) {
+	parts := strings.SplitN(key, ".", 2)
+	if len(parts) != 2 {
+		return reflect.Value{}, fmt.Errorf("%s: %w", key, ErrUnknownKey)
+	}
+
+	section, ok := lookup(reflect.ValueOf(c).Elem(), parts[0])
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("%s: %w", key, ErrUnknownKey)
+	}
+	field, ok := lookup(section, parts[1])
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("%s: %w", key, ErrUnknownKey)
+	}
+	return field, nil
+}
+
+// lookup returns the field of the struct with the name in the toml tag.
+func lookup(value reflect.Value, name string) (reflect.Value, bool) {
+	for i := 0; i < value.NumField(); i++ {
+		if value.Type().Field(i).Tag.Get("toml") == name {
+			return value.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
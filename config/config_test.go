@@ -0,0 +1,281 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRead(t *testing.T) {
+
+	cases := []struct {
+		content       string
+		expectedError bool
+	}{
+		// Valid
+		{
+			content: `
+# Comment
+[server]
+listen = "127.0.0.1:8080" # Comment
+[backend]
+bucket = 'a#b'
+key = "to\"do.md"
+[limits]
+body_size = 2_048
+wait = "30s"`,
+		},
+		// Unknown section
+		{content: "[foo]\nlisten = \"\"", expectedError: true},
+		// Unknown key
+		{content: "[server]\nfoo = \"\"", expectedError: true},
+		// Key without section
+		{content: "listen = \":80\"", expectedError: true},
+		// Unquoted string
+		{content: "[server]\nlisten = :80", expectedError: true},
+		// Quoted number
+		{content: "[limits]\nbody_size = \"10\"", expectedError: true},
+		// Invalid number
+		{content: "[limits]\nbody_size = 10MB", expectedError: true},
+		// Invalid duration
+		{content: "[limits]\nwait = \"5\"", expectedError: true},
+		// Invalid string
+		{content: "[server]\nlisten = \":80", expectedError: true},
+		// Invalid table
+		{content: "[server\nlisten = \":80\"", expectedError: true},
+		// Without value
+		{content: "[server]\nlisten", expectedError: true},
+	}
+
+	for i, c := range cases {
+		cfg := Default()
+		err := cfg.Read(strings.NewReader(c.content))
+		if err != nil && !c.expectedError {
+			t.Fatalf("Unexpected error %s in case %d", err.Error(), i)
+		} else if err == nil && c.expectedError {
+			t.Fatalf("Expected error in case %d", i)
+		}
+	}
+
+	cfg := Default()
+	if err := cfg.Read(strings.NewReader(cases[0].content)); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Listen != "127.0.0.1:8080" || cfg.Backend.Bucket != "a#b" || cfg.Backend.Key != `to"do.md` {
+		t.Fatalf("Unexpected strings %+v %+v", cfg.Server, cfg.Backend)
+	}
+	if cfg.Limits.BodySize != 2048 || cfg.Limits.Wait != 30*time.Second || cfg.Limits.Share != Default().Limits.Share {
+		t.Fatalf("Unexpected limits %+v", cfg.Limits)
+	}
+}
+
+func TestLoad(t *testing.T) {
+
+	cfg, err := Load("example.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if cfg.Backend.Bucket != "my-bucket" || cfg.Log.Format != "json" || cfg.Limits.BodySize != 1048576 || cfg.Shutdown.Drain != 5*time.Second {
+		t.Fatalf("Unexpected configuration %+v", cfg)
+	}
+
+	if _, err := Load("missing.toml"); err == nil {
+		t.Fatalf("Expected error loading a missing file")
+	}
+}
+
+func TestEnviron(t *testing.T) {
+
+	cfg := Default()
+	err := cfg.Environ([]string{
+		"HOME=/root",
+		"TOKEN=old",
+		"AWS_REGION=eu-west-1",
+		"TODO_AUTH_TOKEN=new",
+		"TODO_AUTH_SHARE_KEY=a=b",
+		"TODO_LIMITS_WAIT=1m",
+		"TODO_TOKEN=client",
+		"TODO_FILE_PATH=client",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Auth.Token != "new" || cfg.Auth.ShareKey != "a=b" || cfg.Backend.Region != "eu-west-1" || cfg.Limits.Wait != time.Minute {
+		t.Fatalf("Unexpected configuration %+v", cfg)
+	}
+
+	for _, invalid := range []string{"TODO_SERVER_FOO=bar", "TODO_LIMITS_WAIT=1"} {
+		if err := Default().Environ([]string{invalid}); err == nil {
+			t.Fatalf("Expected error with %s", invalid)
+		}
+	}
+	if err := Default().Environ([]string{"TODO_SERVER_FOO=bar"}); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("Expected error %s, got %v", ErrUnknownKey.Error(), err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+
+	valid := func() *Config {
+		cfg := Default()
+		cfg.Backend.Bucket = "bucket"
+		cfg.Backend.Region = "eu-west-1"
+		cfg.Auth.Token = "secret"
+		return cfg
+	}
+
+	cases := []struct {
+		change         func(*Config)
+		expectedErrors []string
+	}{
+		// Valid
+		{change: func(*Config) {}},
+		// Defaults
+		{
+			change: func(cfg *Config) { *cfg = *Default() },
+			expectedErrors: []string{
+				"backend.bucket: required by the s3 backend",
+				"backend.region: required by the s3 backend",
+				"auth: token or tokens required",
+			},
+		},
+		// Memory backend
+		{change: func(cfg *Config) { cfg.Backend = Backend{Type: "memory"} }},
+		// Unknown backend
+		{
+			change:         func(cfg *Config) { cfg.Backend.Type = "ftp" },
+			expectedErrors: []string{`backend.type: unknown backend "ftp"`},
+		},
+		// Server-side encryption
+		{
+			change: func(cfg *Config) {
+				cfg.Backend.SSE = "sse-c"
+				cfg.Backend.SSECustomerKey = "sse.key"
+			},
+		},
+		{
+			change: func(cfg *Config) {
+				cfg.Backend.SSE = "sse-s3"
+				cfg.Backend.SSEKMSKeyID = "alias/todo"
+			},
+			expectedErrors: []string{
+				"backend.sse_kms_key_id: requires sse-kms",
+			},
+		},
+		{
+			change: func(cfg *Config) { cfg.Backend.SSE = "sse-c" },
+			expectedErrors: []string{
+				"backend.sse_customer_key: required by sse-c, and only by it",
+			},
+		},
+		{
+			change:         func(cfg *Config) { cfg.Backend.SSE = "aes" },
+			expectedErrors: []string{`backend.sse: unknown encryption "aes"`},
+		},
+		// Both tokens
+		{
+			change:         func(cfg *Config) { cfg.Auth.Tokens = "tokens.json" },
+			expectedErrors: []string{"auth: token and tokens can't be used together"},
+		},
+		// HTTPS
+		{
+			change: func(cfg *Config) {
+				cfg.TLS = TLS{Cert: "cert.pem", Key: "key.pem", ClientCA: "ca.pem"}
+				cfg.Server.RedirectListen = ":80"
+			},
+		},
+		{
+			change: func(cfg *Config) {
+				cfg.TLS = TLS{Cert: "cert.pem", ClientCA: "ca.pem"}
+				cfg.Server.RedirectListen = ":80"
+			},
+			expectedErrors: []string{"tls: cert and key required"},
+		},
+		{
+			change: func(cfg *Config) {
+				cfg.TLS.ClientCA = "ca.pem"
+				cfg.Server.RedirectListen = ":80"
+			},
+			expectedErrors: []string{"tls.client_ca: requires HTTPS", "server.redirect_listen: requires HTTPS"},
+		},
+		// Addresses
+		{
+			change: func(cfg *Config) {
+				cfg.Server.Listen = "80"
+				cfg.Server.MetricsListen = "localhost"
+			},
+			expectedErrors: []string{`server.listen: invalid address "80"`, `server.metrics_listen: invalid address "localhost"`},
+		},
+		// Logs and limits
+		{
+			change: func(cfg *Config) {
+				cfg.Log = Log{Format: "xml", Level: "verbose"}
+				cfg.Limits = Limits{Wait: -time.Second}
+				cfg.Shutdown = Shutdown{Drain: -time.Second}
+			},
+			expectedErrors: []string{
+				`log.format: unknown format "xml"`,
+				`log.level: unknown level "verbose"`,
+				"limits.body_size: must be positive",
+				"limits.wait: can't be negative",
+				"limits.share: must be positive",
+				"shutdown.drain: can't be negative",
+				"shutdown.timeout: must be positive",
+			},
+		},
+	}
+
+	for i, c := range cases {
+		cfg := valid()
+		c.change(cfg)
+
+		err := cfg.Validate()
+		if len(c.expectedErrors) == 0 {
+			if err != nil {
+				t.Fatalf("Unexpected error %s in case %d", err.Error(), i)
+			}
+			continue
+		}
+
+		if err == nil {
+			t.Fatalf("Expected errors %v in case %d", c.expectedErrors, i)
+		}
+		if got := strings.Split(err.Error(), "\n"); strings.Join(got, "|") != strings.Join(c.expectedErrors, "|") {
+			t.Fatalf("Expected errors %v, got %v in case %d", c.expectedErrors, got, i)
+		}
+	}
+}
+
+func TestPrint(t *testing.T) {
+
+	cfg := Default()
+	cfg.Backend.Bucket = `my "bucket"`
+	cfg.Auth.Token = "secret"
+	cfg.Limits.Wait = 90 * time.Second
+
+	buff := &bytes.Buffer{}
+	if err := cfg.Print(buff); err != nil {
+		t.Fatal(err)
+	}
+
+	output := buff.String()
+	if strings.Contains(output, "secret") || !strings.Contains(output, `token = "REDACTED"`) || !strings.Contains(output, `share_key = ""`) {
+		t.Fatalf("Expected the secrets redacted, got:\n%s", output)
+	}
+
+	// The output can be read again
+	printed := Default()
+	if err := printed.Read(buff); err != nil {
+		t.Fatal(err)
+	}
+	printed.Auth.Token = cfg.Auth.Token
+	if *printed != *cfg {
+		t.Fatalf("Expected %+v, got %+v", cfg, printed)
+	}
+}
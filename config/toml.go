@@ -0,0 +1,126 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Load returns the default configuration changed by the TOML file.
+func Load(path string) (*Config, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	c := Default()
+	if err := c.Read(file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Read changes the configuration with the TOML file. Only tables, comments
+// and keys with strings or integers are supported.
+func (c *Config) Read(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	section := ""
+
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return fmt.Errorf("line %d: invalid table %s", number, line)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return fmt.Errorf("line %d: expected key = value", number)
+		}
+		key := section + "." + strings.TrimSpace(line[:i])
+
+		field, err := c.field(key)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", number, err)
+		}
+
+		value, quoted, err := parseValue(strings.TrimSpace(line[i+1:]))
+		if err != nil {
+			return fmt.Errorf("line %d: %s: %w", number, key, err)
+		}
+
+		// Strings and durations are quoted, numbers aren't
+		switch field.Interface().(type) {
+		case string, time.Duration:
+			if !quoted {
+				return fmt.Errorf("line %d: %s: expected a string", number, key)
+			}
+		default:
+			if quoted {
+				return fmt.Errorf("line %d: %s: expected a number", number, key)
+			}
+		}
+
+		if err := c.Set(key, value); err != nil {
+			return fmt.Errorf("line %d: %w", number, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// parseValue returns the value without quotes and if it was quoted.
+func parseValue(value string) (string, bool, error) {
+	switch {
+	case strings.HasPrefix(value, "\""):
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return "", false, fmt.Errorf("invalid string %s", value)
+		}
+		return unquoted, true, nil
+	case strings.HasPrefix(value, "'"):
+		// Literal strings don't have escapes
+		if len(value) < 2 || !strings.HasSuffix(value, "'") || strings.Contains(value[1:len(value)-1], "'") {
+			return "", false, fmt.Errorf("invalid string %s", value)
+		}
+		return value[1 : len(value)-1], true, nil
+	}
+
+	// Underscores can separate the digits
+	return strings.Replace(value, "_", "", -1), false, nil
+}
+
+// stripComment removes the comment of the line, if it's not in a string.
+func stripComment(line string) string {
+	var quote rune
+	escaped := false
+	for i, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
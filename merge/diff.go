@@ -0,0 +1,225 @@
+package merge
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Diff returns the changes from a to b in the unified format without context
+// lines, like diff -U0.
+func Diff(a, b []byte) []byte {
+	x, y := split(a), split(b)
+	toY := matches(x, y)
+
+	buff := &bytes.Buffer{}
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		if i < len(x) && toY[i] == j {
+			i++
+			j++
+			continue
+		}
+
+		// The hunk ends in the next line kept
+		next := i
+		for next < len(x) && toY[next] < 0 {
+			next++
+		}
+		end := len(y)
+		if next < len(x) {
+			end = toY[next]
+		}
+
+		fmt.Fprintf(buff, "@@ -%s +%s @@\n", hunkRange(i, next-i), hunkRange(j, end-j))
+		for _, line := range x[i:next] {
+			writeDiffLine(buff, "-", line)
+		}
+		for _, line := range y[j:end] {
+			writeDiffLine(buff, "+", line)
+		}
+		i, j = next, end
+	}
+
+	return buff.Bytes()
+}
+
+// hunkRange returns the lines of the hunk, an empty one starts in the line
+// before it.
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return strconv.Itoa(start + 1)
+	default:
+		return fmt.Sprintf("%d,%d", start+1, count)
+	}
+}
+
+func writeDiffLine(buff *bytes.Buffer, prefix, line string) {
+	buff.WriteString(prefix + line)
+	if !strings.HasSuffix(line, "\n") {
+		buff.WriteString("\n\\ No newline at end of file\n")
+	}
+}
+
+// matches finds the longest common subsequence of lines with the Myers diff
+// algorithm. Returns, for every line of a, the index of the same line in b or
+// -1 if it was removed.
+func matches(a, b []string) []int {
+	result := make([]int, len(a))
+	for i := range result {
+		result[i] = -1
+	}
+
+	// The common prefix and suffix don't need the diff
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		result[prefix] = prefix
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		result[len(a)-1-suffix] = len(b) - 1 - suffix
+		suffix++
+	}
+
+	for i, j := range myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]) {
+		if j >= 0 {
+			result[prefix+i] = prefix + j
+		}
+	}
+	return result
+}
+
+// maxCost is the number of lines the diff compares, past it the lines left
+// are replaced as a whole instead of looking for the common ones.
+var maxCost = 1 << 24
+
+// myers returns the matches of the lines of a in b. It splits the diff in
+// the middle snake of the shortest path, so it only keeps the furthest
+// points of the last step instead of every step.
+func myers(a, b []string) []int {
+	result := make([]int, len(a))
+	for i := range result {
+		result[i] = -1
+	}
+
+	size := len(a) + len(b) + 2
+	s := &snakes{a: a, b: b, result: result, cost: maxCost, offset: size, forward: make([]int, 2*size+1), backward: make([]int, 2*size+1)}
+	s.compare(0, len(a), 0, len(b))
+	return result
+}
+
+// snakes finds the common lines of a and b recursively, sharing the
+// furthest points of the searches.
+type snakes struct {
+	a, b   []string
+	result []int
+
+	// cost is the number of lines left to compare
+	cost int
+
+	// forward and backward hold the furthest x reached from the start and
+	// from the end in every diagonal
+	offset            int
+	forward, backward []int
+}
+
+// compare matches the lines of a[aLo:aHi] in b[bLo:bHi].
+func (s *snakes) compare(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && s.a[aLo] == s.b[bLo] {
+		s.result[aLo] = bLo
+		aLo++
+		bLo++
+	}
+	for aLo < aHi && bLo < bHi && s.a[aHi-1] == s.b[bHi-1] {
+		aHi--
+		bHi--
+		s.result[aHi] = bHi
+	}
+
+	// Without common prefix and suffix, if a side is empty there's nothing
+	// else in common
+	if aLo == aHi || bLo == bHi {
+		return
+	}
+
+	// Too costly, the region is replaced
+	x, y, u, v, ok := s.middle(s.a[aLo:aHi], s.b[bLo:bHi])
+	if !ok {
+		return
+	}
+	s.compare(aLo, aLo+x, bLo, bLo+y)
+	for i := x; i < u; i++ {
+		s.result[aLo+i] = bLo + y + i - x
+	}
+	s.compare(aLo+u, aHi, bLo+v, bHi)
+}
+
+// middle returns the snake in the middle of the shortest path from the start
+// to the end of a and b, from (x, y) to (u, v). The backward search is the
+// forward one with both reversed. Returns false if the search costs more than
+// the lines left to compare.
+func (s *snakes) middle(a, b []string) (int, int, int, int, bool) {
+	n, m := len(a), len(b)
+	delta := n - m
+	forward, backward, offset := s.forward, s.backward, s.offset
+	forward[offset+1], backward[offset+1] = 0, 0
+
+	for d := 0; d <= (n+m+1)/2; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1]
+			} else {
+				x = forward[offset+k-1] + 1
+			}
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			forward[offset+k] = x
+			if s.cost -= 1 + x - startX; s.cost < 0 {
+				return 0, 0, 0, 0, false
+			}
+
+			// The backward search of the previous step reached this diagonal
+			if reverse := delta - k; delta%2 != 0 && reverse >= -(d-1) && reverse <= d-1 && x+backward[offset+reverse] >= n {
+				return startX, startY, x, y, true
+			}
+		}
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && backward[offset+k-1] < backward[offset+k+1]) {
+				x = backward[offset+k+1]
+			} else {
+				x = backward[offset+k-1] + 1
+			}
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && a[n-1-x] == b[m-1-y] {
+				x++
+				y++
+			}
+			backward[offset+k] = x
+			if s.cost -= 1 + x - startX; s.cost < 0 {
+				return 0, 0, 0, 0, false
+			}
+
+			// The forward search of this step reached this diagonal
+			if straight := delta - k; delta%2 == 0 && straight >= -d && straight <= d && x+forward[offset+straight] >= n {
+				return n - x, m - y, n - startX, m - startY, true
+			}
+		}
+	}
+
+	// The searches always meet before, if not the region is replaced
+	return 0, 0, 0, 0, false
+}
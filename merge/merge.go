@@ -0,0 +1,124 @@
+// Package merge combines two versions of the file edited from the same base
+// with a line based three-way merge, like diff3.
+package merge
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Labels name the versions in the conflict markers.
+type Labels struct {
+	Ours   string
+	Base   string
+	Theirs string
+}
+
+// DefaultLabels are used when the labels are empty.
+var DefaultLabels = Labels{Ours: "ours", Base: "base", Theirs: "theirs"}
+
+// Merge applies the changes from base to theirs on top of ours. Lines both
+// sides changed in a different way are conflicts: the merged file has both
+// versions between conflict markers, with the base version in between.
+// Returns the merged file and the number of conflicts.
+func Merge(base, ours, theirs []byte, labels Labels) ([]byte, int) {
+	if labels == (Labels{}) {
+		labels = DefaultLabels
+	}
+
+	b, o, t := split(base), split(ours), split(theirs)
+	toOurs := matches(b, o)
+	toTheirs := matches(b, t)
+
+	buff := &bytes.Buffer{}
+	conflicts := 0
+	i, j, k := 0, 0, 0
+
+	for {
+		// The next base line kept in both versions
+		next := i
+		for next < len(b) && (toOurs[next] < 0 || toTheirs[next] < 0) {
+			next++
+		}
+
+		endOurs, endTheirs := len(o), len(t)
+		if next < len(b) {
+			endOurs, endTheirs = toOurs[next], toTheirs[next]
+		}
+
+		if !resolve(buff, b[i:next], o[j:endOurs], t[k:endTheirs]) {
+			conflicts++
+			conflict(buff, b[i:next], o[j:endOurs], t[k:endTheirs], labels)
+		}
+
+		if next == len(b) {
+			break
+		}
+
+		buff.WriteString(o[endOurs])
+		i, j, k = next+1, endOurs+1, endTheirs+1
+	}
+
+	return buff.Bytes(), conflicts
+}
+
+// resolve writes the chunk if only one side changed it or both changed it in
+// the same way. Returns false if it's a conflict.
+func resolve(buff *bytes.Buffer, base, ours, theirs []string) bool {
+	switch {
+	case equal(ours, base):
+		write(buff, theirs)
+	case equal(theirs, base), equal(ours, theirs):
+		write(buff, ours)
+	default:
+		return false
+	}
+	return true
+}
+
+// conflict writes the chunk with conflict markers.
+func conflict(buff *bytes.Buffer, base, ours, theirs []string, labels Labels) {
+	buff.WriteString("<<<<<<< " + labels.Ours + "\n")
+	writeLines(buff, ours)
+	buff.WriteString("||||||| " + labels.Base + "\n")
+	writeLines(buff, base)
+	buff.WriteString("=======\n")
+	writeLines(buff, theirs)
+	buff.WriteString(">>>>>>> " + labels.Theirs + "\n")
+}
+
+func write(buff *bytes.Buffer, lines []string) {
+	for _, line := range lines {
+		buff.WriteString(line)
+	}
+}
+
+// writeLines writes the lines making sure the last one ends the line, so
+// the markers start in a new one.
+func writeLines(buff *bytes.Buffer, lines []string) {
+	write(buff, lines)
+	if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		buff.WriteByte('\n')
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// split returns the lines of the content, keeping the line endings.
+func split(content []byte) []string {
+	lines := strings.SplitAfter(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
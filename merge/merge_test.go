@@ -0,0 +1,218 @@
+package merge
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatches(t *testing.T) {
+
+	cases := []struct {
+		a        string
+		b        string
+		expected []int
+	}{
+		{"", "", []int{}},
+		{"a", "", []int{-1}},
+		{"", "a", []int{}},
+		{"abc", "abc", []int{0, 1, 2}},
+		{"abc", "axc", []int{0, -1, 2}},
+		{"abcabba", "cbabac", []int{-1, -1, 0, 1, -1, 3, 4}},
+		{"xaby", "abz", []int{-1, 0, 1, -1}},
+	}
+
+	for _, c := range cases {
+		got := matches(strings.Split(c.a, ""), strings.Split(c.b, ""))
+
+		if len(got) != len(c.expected) {
+			t.Fatalf("Expected %v, got %v for %s and %s", c.expected, got, c.a, c.b)
+		}
+
+		// Any common subsequence of the same length is valid
+		count, expected, last := 0, 0, -1
+		for i, j := range got {
+			if j < 0 {
+				continue
+			}
+			if j <= last || c.a[i] != c.b[j] {
+				t.Fatalf("Invalid match %v for %s and %s", got, c.a, c.b)
+			}
+			last = j
+			count++
+		}
+		for _, j := range c.expected {
+			if j >= 0 {
+				expected++
+			}
+		}
+		if count != expected {
+			t.Fatalf("Expected %d matches, got %v for %s and %s", expected, got, c.a, c.b)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+
+	base := "# Work\n- [ ] Write\n- [ ] Send\n\n# Home\n- [ ] Cook\n"
+
+	cases := []struct {
+		ours              string
+		theirs            string
+		expected          string
+		expectedConflicts int
+	}{
+		// No changes
+		{
+			ours:     base,
+			theirs:   base,
+			expected: base,
+		},
+		// Only theirs
+		{
+			ours:     base,
+			theirs:   "# Work\n- [x] Write\n- [ ] Send\n\n# Home\n- [ ] Cook\n",
+			expected: "# Work\n- [x] Write\n- [ ] Send\n\n# Home\n- [ ] Cook\n",
+		},
+		// Only ours
+		{
+			ours:     "# Work\n- [ ] Write\n- [ ] Send\n\n# Home\n- [ ] Cook\n- [ ] Clean\n",
+			theirs:   base,
+			expected: "# Work\n- [ ] Write\n- [ ] Send\n\n# Home\n- [ ] Cook\n- [ ] Clean\n",
+		},
+		// Different lines
+		{
+			ours:     "# Work\n- [ ] Write\n- [ ] Send\n\n# Home\n- [x] Cook\n",
+			theirs:   "# Work\n- [x] Write\n- [ ] Send\n\n# Home\n- [ ] Cook\n",
+			expected: "# Work\n- [x] Write\n- [ ] Send\n\n# Home\n- [x] Cook\n",
+		},
+		// Same change
+		{
+			ours:     "# Work\n- [x] Write\n- [ ] Send\n\n# Home\n- [ ] Cook\n",
+			theirs:   "# Work\n- [x] Write\n- [ ] Send\n\n# Home\n- [ ] Cook\n",
+			expected: "# Work\n- [x] Write\n- [ ] Send\n\n# Home\n- [ ] Cook\n",
+		},
+		// Added and removed
+		{
+			ours:     "# Work\n- [ ] Write\n\n# Home\n- [ ] Cook\n",
+			theirs:   "# Work\n- [ ] Plan\n- [ ] Write\n- [ ] Send\n\n# Home\n- [ ] Cook\n",
+			expected: "# Work\n- [ ] Plan\n- [ ] Write\n\n# Home\n- [ ] Cook\n",
+		},
+		// Conflict
+		{
+			ours:              "# Work\n- [ ] Write the report\n- [ ] Send\n\n# Home\n- [ ] Cook\n",
+			theirs:            "# Work\n- [x] Write\n- [ ] Send\n\n# Home\n- [ ] Cook dinner",
+			expected:          "# Work\n<<<<<<< ours\n- [ ] Write the report\n||||||| base\n- [ ] Write\n=======\n- [x] Write\n>>>>>>> theirs\n- [ ] Send\n\n# Home\n- [ ] Cook dinner",
+			expectedConflicts: 1,
+		},
+		// Conflict at the end without new line
+		{
+			ours:              "# Work\n- [ ] Write\n- [ ] Send\n\n# Home\n- [ ] Cook",
+			theirs:            "# Work\n- [ ] Write\n- [ ] Send\n\n# Home\n- [ ] Clean",
+			expected:          "# Work\n- [ ] Write\n- [ ] Send\n\n# Home\n<<<<<<< ours\n- [ ] Cook\n||||||| base\n- [ ] Cook\n=======\n- [ ] Clean\n>>>>>>> theirs\n",
+			expectedConflicts: 1,
+		},
+	}
+
+	for _, c := range cases {
+		got, conflicts := Merge([]byte(base), []byte(c.ours), []byte(c.theirs), Labels{})
+		if string(got) != c.expected {
+			t.Fatalf("Expected %q, got %q", c.expected, string(got))
+		}
+		if conflicts != c.expectedConflicts {
+			t.Fatalf("Expected %d conflicts, got %d", c.expectedConflicts, conflicts)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+
+	cases := []struct {
+		a        string
+		b        string
+		expected string
+	}{
+		// Same
+		{"a\nb\n", "a\nb\n", ""},
+		// Changed line
+		{"a\nb\nc\n", "a\nB\nc\n", "@@ -2 +2 @@\n-b\n+B\n"},
+		// Added lines
+		{"a\n", "a\nb\nc\n", "@@ -1,0 +2,2 @@\n+b\n+c\n"},
+		// Removed lines at the start
+		{"a\nb\nc\n", "c\n", "@@ -1,2 +0,0 @@\n-a\n-b\n"},
+		// Several hunks
+		{"a\nb\nc\nd\n", "A\nb\nc\n", "@@ -1 +1 @@\n-a\n+A\n@@ -4 +3,0 @@\n-d\n"},
+		// Missing newline
+		{"a\n", "a\nb", "@@ -1,0 +2 @@\n+b\n\\ No newline at end of file\n"},
+	}
+
+	for i, c := range cases {
+		if got := string(Diff([]byte(c.a), []byte(c.b))); got != c.expected {
+			t.Fatalf("Expected %q, got %q in case %d", c.expected, got, i)
+		}
+	}
+}
+
+func TestDiffMemory(t *testing.T) {
+
+	// Unrelated files need an edit for every line
+	a, b := &bytes.Buffer{}, &bytes.Buffer{}
+	for i := 0; i < 4000; i++ {
+		fmt.Fprintf(a, "- [ ] a %d\n", i)
+		fmt.Fprintf(b, "- [ ] b %d\n", i)
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	diff := Diff(a.Bytes(), b.Bytes())
+	runtime.ReadMemStats(&after)
+
+	if !bytes.HasPrefix(diff, []byte("@@ -1,4000 +1,4000 @@\n")) {
+		t.Fatalf("Expected a single hunk, got %q", diff[:40])
+	}
+	// Keeping every step of the search takes around 1GB
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 8<<20 {
+		t.Fatalf("Expected less than 8MB allocated, got %d bytes", allocated)
+	}
+}
+
+func TestDiffCost(t *testing.T) {
+
+	// Theirs changed every other line, ours one of the others
+	base, ours, theirs := &bytes.Buffer{}, &bytes.Buffer{}, &bytes.Buffer{}
+	for i := 0; i < 100000; i++ {
+		fmt.Fprintf(base, "- [ ] task %d\n", i)
+		if i%2 == 0 {
+			fmt.Fprintf(theirs, "- [x] task %d\n", i)
+		} else {
+			fmt.Fprintf(theirs, "- [ ] task %d\n", i)
+		}
+		if i == 50001 {
+			fmt.Fprintf(ours, "- [ ] task %d edited\n", i)
+		} else {
+			fmt.Fprintf(ours, "- [ ] task %d\n", i)
+		}
+	}
+
+	start := time.Now()
+	Diff(base.Bytes(), theirs.Bytes())
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Expected the diff cost limited, took %s", elapsed)
+	}
+
+	// Past the limit the changes are replaced as a whole, conflicting with
+	// the other side
+	defer func(cost int) { maxCost = cost }(maxCost)
+	maxCost = 100
+
+	merged, conflicts := Merge(base.Bytes(), ours.Bytes(), theirs.Bytes(), Labels{})
+	if conflicts != 1 {
+		t.Fatalf("Expected 1 conflict, got %d", conflicts)
+	}
+	if !bytes.Contains(merged, []byte("<<<<<<< ours\n")) {
+		t.Fatalf("Expected the conflict markers, got %q", merged[:100])
+	}
+}
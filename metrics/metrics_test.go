@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+
+	registry := NewRegistry()
+
+	requests := registry.NewCounter("requests_total", "Requests.", "method", "status")
+	requests.Inc("GET", "200")
+	requests.Inc("GET", "200")
+	requests.Add(0.5, "PUT", "409")
+
+	registry.NewCounter("conflicts_total", "Conflicts.")
+
+	latency := registry.NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1}, "path")
+	latency.Observe(0.05, `a"b`)
+	latency.Observe(0.5, `a"b`)
+	latency.Observe(2, `a"b`)
+
+	registry.NewGaugeFunc("size_bytes", "Size.", func() float64 { return 42 })
+	registry.NewGaugeFunc("age_seconds", "Age.", func() float64 { return math.NaN() })
+
+	expected := `# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{method="GET",status="200"} 2
+requests_total{method="PUT",status="409"} 0.5
+# HELP conflicts_total Conflicts.
+# TYPE conflicts_total counter
+conflicts_total 0
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{path="a\"b",le="0.1"} 1
+latency_seconds_bucket{path="a\"b",le="1"} 2
+latency_seconds_bucket{path="a\"b",le="+Inf"} 3
+latency_seconds_sum{path="a\"b"} 2.55
+latency_seconds_count{path="a\"b"} 3
+# HELP size_bytes Size.
+# TYPE size_bytes gauge
+size_bytes 42
+# HELP age_seconds Age.
+# TYPE age_seconds gauge
+age_seconds NaN
+`
+
+	buff := &bytes.Buffer{}
+	if err := registry.Write(buff); err != nil {
+		t.Fatal(err)
+	}
+	if buff.String() != expected {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", expected, buff.String())
+	}
+
+	resp := httptest.NewRecorder()
+	registry.ServeHTTP(resp, httptest.NewRequest("GET", "/metrics", nil))
+	if resp.Code != 200 {
+		t.Fatalf("Expected status code 200, got %d", resp.Code)
+	}
+	if contentType := resp.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Fatalf("Expected the text format, got %s", contentType)
+	}
+	if resp.Body.String() != expected {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", expected, resp.Body.String())
+	}
+}
+
+func TestLabels(t *testing.T) {
+
+	counter := NewRegistry().NewCounter("requests_total", "Requests.", "method")
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("Expected panic with the wrong number of labels")
+		}
+	}()
+	counter.Inc("GET", "200")
+}
@@ -0,0 +1,296 @@
+package render
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var autolink = regexp.MustCompile(`^<((?:https?://|mailto:)[^<>\s]+)>`)
+
+// punctuation can be escaped with a backslash
+const punctuation = "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+
+// maxNesting is the depth of the quotes, lists, links and emphasis rendered
+// inside others, the content of deeper ones is escaped.
+const maxNesting = 32
+
+// closings keeps the closing delimiters found in the text, so the openers
+// without one don't scan the rest of the text again.
+type closings struct {
+	text string
+
+	// brackets has the position of the ] closing every [
+	brackets map[int]int
+
+	// ticks has the positions of the runs of backticks by their length
+	ticks map[int][]int
+
+	// paren is the position of the last ) found, len(text) if there's none
+	paren int
+
+	// unclosed has the emphasis delimiters without closing after the last
+	// opener, nor after the next ones
+	unclosed map[string]bool
+}
+
+// renderInline renders the text of a block: code spans, emphasis, links and
+// images. Everything else is escaped.
+func renderInline(text string) string {
+	return renderNested(text, 0)
+}
+
+// renderNested renders the text inside a link or emphasis of the depth given.
+func renderNested(text string, depth int) string {
+	if depth > maxNesting {
+		return html.EscapeString(text)
+	}
+
+	var out strings.Builder
+	found := &closings{text: text, brackets: matchBrackets(text), ticks: tickRuns(text), paren: -1, unclosed: make(map[string]bool)}
+
+	for i := 0; i < len(text); {
+		c := text[i]
+
+		switch {
+		case c == '\\' && i+1 < len(text) && strings.IndexByte(punctuation, text[i+1]) >= 0:
+			out.WriteString(html.EscapeString(text[i+1 : i+2]))
+			i += 2
+			continue
+
+		case c == '\\' && i+1 < len(text) && text[i+1] == '\n':
+			out.WriteString("<br>\n")
+			i += 2
+			continue
+
+		case c == '`':
+			if code, n := found.codeSpan(i); n > 0 {
+				out.WriteString("<code>" + html.EscapeString(code) + "</code>")
+				i += n
+				continue
+			}
+			// Without closing, the whole run is text
+			run := len(text[i:]) - len(strings.TrimLeft(text[i:], "`"))
+			out.WriteString(text[i : i+run])
+			i += run
+			continue
+
+		case c == '!' && strings.HasPrefix(text[i:], "!["):
+			if label, link, n := found.linkAt(i + 1); n > 0 {
+				if safe := safeURL(link); safe != "" {
+					out.WriteString("<img src=\"" + html.EscapeString(safe) + "\" alt=\"" + html.EscapeString(label) + "\">")
+				} else {
+					out.WriteString(html.EscapeString(label))
+				}
+				i += n + 1
+				continue
+			}
+
+		case c == '[':
+			if label, link, n := found.linkAt(i); n > 0 {
+				if safe := safeURL(link); safe != "" {
+					out.WriteString("<a href=\"" + html.EscapeString(safe) + "\">" + renderNested(label, depth+1) + "</a>")
+				} else {
+					out.WriteString(renderNested(label, depth+1))
+				}
+				i += n
+				continue
+			}
+
+		case c == '<':
+			if match := autolink.FindStringSubmatch(text[i:]); match != nil {
+				if safe := safeURL(match[1]); safe != "" {
+					out.WriteString("<a href=\"" + html.EscapeString(safe) + "\">" + html.EscapeString(match[1]) + "</a>")
+					i += len(match[0])
+					continue
+				}
+			}
+
+		case c == '*' || c == '_' || c == '~':
+			// Underscores inside words aren't emphasis
+			if c == '_' && i > 0 && isWord(text[i-1]) {
+				break
+			}
+			if tag, inner, n := found.emphasis(i); n > 0 {
+				out.WriteString("<" + tag + ">" + renderNested(inner, depth+1) + "</" + tag + ">")
+				i += n
+				continue
+			}
+
+		case c == ' ':
+			// Two spaces at the end of the line are a line break
+			spaces := len(text[i:]) - len(strings.TrimLeft(text[i:], " "))
+			if spaces >= 2 && i+spaces < len(text) && text[i+spaces] == '\n' {
+				out.WriteString("<br>\n")
+				i += spaces + 1
+				continue
+			}
+		}
+
+		out.WriteString(html.EscapeString(text[i : i+1]))
+		i++
+	}
+
+	return out.String()
+}
+
+// codeSpan returns the content of the code span at the start position and
+// its length, 0 if there's no closing backticks.
+func (c *closings) codeSpan(start int) (string, int) {
+	text := c.text[start:]
+	ticks := len(text) - len(strings.TrimLeft(text, "`"))
+
+	// The closing backticks are the next run with the same length, the
+	// openers are always whole runs
+	runs := c.ticks[ticks]
+	next := sort.SearchInts(runs, start+ticks)
+	if next == len(runs) {
+		return "", 0
+	}
+	end := runs[next] - start
+
+	code := strings.Replace(text[ticks:end], "\n", " ", -1)
+	if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.TrimSpace(code) != "" {
+		code = code[1 : len(code)-1]
+	}
+	return code, end + ticks
+}
+
+// tickRuns returns the positions of the runs of backticks of the text by
+// their length.
+func tickRuns(text string) map[int][]int {
+	runs := make(map[int][]int)
+	for i := 0; i < len(text); {
+		if text[i] != '`' {
+			i++
+			continue
+		}
+		run := len(text[i:]) - len(strings.TrimLeft(text[i:], "`"))
+		runs[run] = append(runs[run], i)
+		i += run
+	}
+	return runs
+}
+
+// matchBrackets returns the position of the ] closing every [ of the text.
+func matchBrackets(text string) map[int]int {
+	brackets := make(map[int]int)
+	var open []int
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '[':
+			open = append(open, i)
+		case ']':
+			if len(open) > 0 {
+				brackets[open[len(open)-1]] = i
+				open = open[:len(open)-1]
+			}
+		}
+	}
+	return brackets
+}
+
+// nextParen returns the position of the first ) from the start, -1 if
+// there's none. The starts only move forward, so the text is scanned once.
+func (c *closings) nextParen(start int) int {
+	if c.paren < start {
+		c.paren = len(c.text)
+		if closing := strings.IndexByte(c.text[start:], ')'); closing >= 0 {
+			c.paren = start + closing
+		}
+	}
+	if c.paren == len(c.text) {
+		return -1
+	}
+	return c.paren
+}
+
+// linkAt parses a link at the start position: [label](url "title").
+// Returns the label, the url and the length, 0 if it's not a link.
+func (c *closings) linkAt(start int) (string, string, int) {
+	text := c.text
+	end, ok := c.brackets[start]
+	if !ok || end+1 >= len(text) || text[end+1] != '(' {
+		return "", "", 0
+	}
+
+	closing := c.nextParen(end + 2)
+	if closing < 0 {
+		return "", "", 0
+	}
+
+	destination := strings.TrimSpace(text[end+2 : closing])
+	// The title isn't rendered
+	if space := strings.IndexAny(destination, " \n"); space >= 0 {
+		destination = destination[:space]
+	}
+	destination = strings.TrimSuffix(strings.TrimPrefix(destination, "<"), ">")
+
+	return text[start+1 : end], destination, closing + 1 - start
+}
+
+// emphasis parses emphasis at the start position: *em*, _em_, **strong**,
+// __strong__ and ~~del~~. Returns the tag, the content and the length, 0 if
+// there's no closing delimiter.
+func (c *closings) emphasis(start int) (string, string, int) {
+	text := c.text[start:]
+
+	var delimiter, tag string
+	switch {
+	case strings.HasPrefix(text, "**"), strings.HasPrefix(text, "__"):
+		delimiter, tag = text[:2], "strong"
+	case strings.HasPrefix(text, "~~"):
+		delimiter, tag = text[:2], "del"
+	case text[0] == '~':
+		return "", "", 0
+	default:
+		delimiter, tag = text[:1], "em"
+	}
+
+	rest := text[len(delimiter):]
+	if rest == "" || rest[0] == ' ' || rest[0] == '\n' || c.unclosed[delimiter] {
+		return "", "", 0
+	}
+
+	for i := 1; i < len(rest); i++ {
+		if !strings.HasPrefix(rest[i:], delimiter) || rest[i-1] == ' ' || rest[i-1] == '\\' {
+			continue
+		}
+		// Underscores inside words aren't emphasis
+		if delimiter[0] == '_' && i+len(delimiter) < len(rest) && isWord(rest[i+len(delimiter)]) {
+			continue
+		}
+		// A single delimiter can't close on a double one
+		if len(delimiter) == 1 && i+1 < len(rest) && rest[i+1] == delimiter[0] {
+			i++
+			continue
+		}
+		return tag, rest[:i], len(delimiter)*2 + i
+	}
+
+	// The closings of the next openers would have been found here
+	c.unclosed[delimiter] = true
+	return "", "", 0
+}
+
+// safeURL returns the URL if it's relative or uses a safe scheme.
+func safeURL(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "", "http", "https", "mailto":
+		return link
+	}
+	return ""
+}
+
+func isWord(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
@@ -0,0 +1,325 @@
+// Package render converts the TODO Markdown file to HTML.
+//
+// It supports the CommonMark blocks used in TODO lists (headings, paragraphs,
+// lists, block quotes, code blocks and thematic breaks) and the GFM task
+// list items, tables and strikethrough. The output is safe to embed in a
+// page: raw HTML in the file is escaped and only http, https, mailto and
+// relative URLs are kept in links and images.
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	headingLine   = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	fenceLine     = regexp.MustCompile("^( {0,3})(`{3,}|~{3,})[ \t]*([^`\\s]*)")
+	listLine      = regexp.MustCompile(`^( {0,3})([-*+]|[0-9]{1,9}[.)])( +|$)`)
+	breakLine     = regexp.MustCompile(`^ {0,3}((\*[ \t]*){3,}|(-[ \t]*){3,}|(_[ \t]*){3,})$`)
+	quoteLine     = regexp.MustCompile(`^ {0,3}> ?`)
+	delimiterLine = regexp.MustCompile(`^ *\|? *:?-+:? *(\| *:?-+:? *)*\|? *$`)
+	taskItem      = regexp.MustCompile(`^\[([ xX])\](?:[ \t]+|$)`)
+	languageName  = regexp.MustCompile(`^[a-zA-Z0-9_+-]+$`)
+)
+
+// HTML renders the Markdown content.
+func HTML(content []byte) []byte {
+	text := strings.Replace(string(content), "\r\n", "\n", -1)
+	text = strings.Replace(text, "\t", "    ", -1)
+
+	buff := &bytes.Buffer{}
+	renderBlocks(buff, strings.Split(strings.TrimSuffix(text, "\n"), "\n"), false, 0)
+	return buff.Bytes()
+}
+
+// renderBlocks renders the lines inside the quotes and lists of the depth
+// given. Tight list items don't wrap their paragraphs.
+func renderBlocks(buff *bytes.Buffer, lines []string, tight bool, depth int) {
+	if depth > maxNesting {
+		buff.WriteString("<p>" + html.EscapeString(strings.Join(lines, "\n")) + "</p>\n")
+		return
+	}
+
+	var paragraph []string
+	endParagraph := func() {
+		if len(paragraph) == 0 {
+			return
+		}
+		content := renderInline(strings.TrimSpace(strings.Join(paragraph, "\n")))
+		if tight {
+			buff.WriteString(content + "\n")
+		} else {
+			buff.WriteString("<p>" + content + "</p>\n")
+		}
+		paragraph = nil
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		if strings.TrimSpace(line) == "" {
+			endParagraph()
+			continue
+		}
+
+		if match := fenceLine.FindStringSubmatch(line); match != nil {
+			endParagraph()
+			i = renderFence(buff, lines, i, match)
+			continue
+		}
+
+		if match := headingLine.FindStringSubmatch(line); match != nil {
+			endParagraph()
+			fmt.Fprintf(buff, "<h%d>%s</h%d>\n", len(match[1]), renderInline(match[2]), len(match[1]))
+			continue
+		}
+
+		if breakLine.MatchString(line) {
+			endParagraph()
+			buff.WriteString("<hr>\n")
+			continue
+		}
+
+		if quoteLine.MatchString(line) {
+			endParagraph()
+			var quote []string
+			for ; i < len(lines) && quoteLine.MatchString(lines[i]); i++ {
+				quote = append(quote, quoteLine.ReplaceAllString(lines[i], ""))
+			}
+			i--
+			buff.WriteString("<blockquote>\n")
+			renderBlocks(buff, quote, false, depth+1)
+			buff.WriteString("</blockquote>\n")
+			continue
+		}
+
+		if listLine.MatchString(line) {
+			endParagraph()
+			i = renderList(buff, lines, i, depth)
+			continue
+		}
+
+		if len(paragraph) == 0 && i+1 < len(lines) && strings.Contains(line, "|") && delimiterLine.MatchString(lines[i+1]) && strings.Contains(lines[i+1], "-") {
+			i = renderTable(buff, lines, i)
+			continue
+		}
+
+		paragraph = append(paragraph, line)
+	}
+
+	endParagraph()
+}
+
+// renderFence renders a fenced code block starting in the line and returns
+// the last line of the block.
+func renderFence(buff *bytes.Buffer, lines []string, start int, match []string) int {
+	indent, fence, language := len(match[1]), match[2], match[3]
+
+	if languageName.MatchString(language) {
+		fmt.Fprintf(buff, "<pre><code class=\"language-%s\">", language)
+	} else {
+		buff.WriteString("<pre><code>")
+	}
+
+	i := start + 1
+	for ; i < len(lines); i++ {
+		if closing := strings.TrimSpace(lines[i]); indentation(lines[i]) < 4 && strings.HasPrefix(closing, fence) && strings.Trim(closing, fence[:1]) == "" {
+			break
+		}
+		line := lines[i]
+		for j := 0; j < indent && strings.HasPrefix(line, " "); j++ {
+			line = line[1:]
+		}
+		buff.WriteString(html.EscapeString(line) + "\n")
+	}
+
+	buff.WriteString("</code></pre>\n")
+	return i
+}
+
+// renderList renders the list starting in the line, inside the blocks of
+// the depth given, and returns its last line.
+func renderList(buff *bytes.Buffer, lines []string, start, depth int) int {
+	first := listLine.FindStringSubmatch(lines[start])
+	ordered := !strings.ContainsAny(first[2], "-*+")
+	bullet := first[2][len(first[2])-1:]
+
+	var items [][]string
+	loose := false
+	i := start
+	for i < len(lines) {
+		match := listLine.FindStringSubmatch(lines[i])
+		if match == nil || match[2][len(match[2])-1:] != bullet {
+			break
+		}
+
+		// The content is indented after the marker
+		width := len(match[0])
+		if match[3] == "" || len(match[3]) > 4 {
+			width = len(match[1]) + len(match[2]) + 1
+		}
+
+		item := []string{""}
+		if len(lines[i]) > width {
+			item[0] = lines[i][width:]
+		}
+		i++
+
+		for i < len(lines) {
+			line := lines[i]
+			if strings.TrimSpace(line) == "" {
+				// Blank lines are part of the item if it continues after them
+				j := i
+				for j < len(lines) && strings.TrimSpace(lines[j]) == "" {
+					j++
+				}
+				if j < len(lines) && indentation(lines[j]) >= width {
+					for ; i < j; i++ {
+						item = append(item, "")
+					}
+					loose = true
+					continue
+				}
+				if j < len(lines) && listLine.MatchString(lines[j]) && indentation(lines[j]) == len(first[1]) {
+					loose = true
+				}
+				i = j
+				break
+			}
+
+			if indentation(line) >= width {
+				item = append(item, line[width:])
+				i++
+				continue
+			}
+
+			// Lazy continuation of the paragraph
+			if !listLine.MatchString(line) && !headingLine.MatchString(line) && !fenceLine.MatchString(line) && !quoteLine.MatchString(line) && !breakLine.MatchString(line) {
+				item = append(item, line)
+				i++
+				continue
+			}
+			break
+		}
+
+		items = append(items, item)
+
+		if i > 0 && strings.TrimSpace(lines[i-1]) == "" && (i >= len(lines) || !listLine.MatchString(lines[i]) || indentation(lines[i]) != len(first[1])) {
+			break
+		}
+	}
+
+	tag := "ul"
+	if ordered {
+		tag = "ol"
+		number := strings.TrimRight(first[2], ".)")
+		if number = strings.TrimLeft(number, "0"); number != "1" && number != "" {
+			fmt.Fprintf(buff, "<ol start=\"%s\">\n", number)
+		} else {
+			buff.WriteString("<ol>\n")
+		}
+	} else {
+		buff.WriteString("<ul>\n")
+	}
+
+	for _, item := range items {
+		if match := taskItem.FindStringSubmatch(item[0]); match != nil {
+			checked := ""
+			if match[1] != " " {
+				checked = " checked"
+			}
+			fmt.Fprintf(buff, "<li class=\"task-list-item\"><input type=\"checkbox\" disabled%s> ", checked)
+			item[0] = item[0][len(match[0]):]
+		} else {
+			buff.WriteString("<li>")
+		}
+		renderBlocks(buff, item, !loose, depth+1)
+		buff.WriteString("</li>\n")
+	}
+
+	buff.WriteString("</" + tag + ">\n")
+	return i - 1
+}
+
+// renderTable renders the table starting in the line and returns its last
+// line.
+func renderTable(buff *bytes.Buffer, lines []string, start int) int {
+	header := splitRow(lines[start])
+
+	var align []string
+	for _, cell := range splitRow(lines[start+1]) {
+		switch {
+		case strings.HasPrefix(cell, ":") && strings.HasSuffix(cell, ":"):
+			align = append(align, " style=\"text-align: center\"")
+		case strings.HasSuffix(cell, ":"):
+			align = append(align, " style=\"text-align: right\"")
+		case strings.HasPrefix(cell, ":"):
+			align = append(align, " style=\"text-align: left\"")
+		default:
+			align = append(align, "")
+		}
+	}
+
+	row := func(cells []string, tag string) {
+		buff.WriteString("<tr>")
+		for i := range align {
+			content := ""
+			if i < len(cells) {
+				content = renderInline(cells[i])
+			}
+			fmt.Fprintf(buff, "<%s%s>%s</%s>", tag, align[i], content, tag)
+		}
+		buff.WriteString("</tr>\n")
+	}
+
+	buff.WriteString("<table>\n<thead>\n")
+	row(header, "th")
+	buff.WriteString("</thead>\n")
+
+	i := start + 2
+	if i < len(lines) && strings.TrimSpace(lines[i]) != "" && strings.Contains(lines[i], "|") {
+		buff.WriteString("<tbody>\n")
+		for ; i < len(lines) && strings.TrimSpace(lines[i]) != "" && strings.Contains(lines[i], "|"); i++ {
+			row(splitRow(lines[i]), "td")
+		}
+		buff.WriteString("</tbody>\n")
+	}
+
+	buff.WriteString("</table>\n")
+	return i - 1
+}
+
+// splitRow returns the cells of a table row. Escaped pipes are part of the
+// cell.
+func splitRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, "\\|") {
+		line = line[:len(line)-1]
+	}
+
+	var cells []string
+	var cell strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line) && line[i+1] == '|':
+			cell.WriteByte('|')
+			i++
+		case line[i] == '|':
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(line[i])
+		}
+	}
+	return append(cells, strings.TrimSpace(cell.String()))
+}
+
+// indentation returns the number of leading spaces.
+func indentation(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
@@ -0,0 +1,115 @@
+package render
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTML(t *testing.T) {
+
+	cases := []struct {
+		content  string
+		expected string
+	}{
+		// Heading and paragraph
+		{
+			content:  "# TODO #\nSome *text*\nin two lines",
+			expected: "<h1>TODO</h1>\n<p>Some <em>text</em>\nin two lines</p>\n",
+		},
+		// Task list
+		{
+			content:  "- [ ] Write\n- [x] Send\n  - [X] Nested\n- Plain",
+			expected: "<ul>\n<li class=\"task-list-item\"><input type=\"checkbox\" disabled> Write\n</li>\n<li class=\"task-list-item\"><input type=\"checkbox\" disabled checked> Send\n<ul>\n<li class=\"task-list-item\"><input type=\"checkbox\" disabled checked> Nested\n</li>\n</ul>\n</li>\n<li>Plain\n</li>\n</ul>\n",
+		},
+		// Loose ordered list
+		{
+			content:  "3. one\n\n4. two",
+			expected: "<ol start=\"3\">\n<li><p>one</p>\n</li>\n<li><p>two</p>\n</li>\n</ol>\n",
+		},
+		// Table
+		{
+			content:  "| Task | Owner |\n|:-----|------:|\n| Write | `me` |\n| Pipe \\| | |",
+			expected: "<table>\n<thead>\n<tr><th style=\"text-align: left\">Task</th><th style=\"text-align: right\">Owner</th></tr>\n</thead>\n<tbody>\n<tr><td style=\"text-align: left\">Write</td><td style=\"text-align: right\"><code>me</code></td></tr>\n<tr><td style=\"text-align: left\">Pipe |</td><td style=\"text-align: right\"></td></tr>\n</tbody>\n</table>\n",
+		},
+		// Code fence
+		{
+			content:  "```go\n<b>\n- [ ] not a task\n```\nafter",
+			expected: "<pre><code class=\"language-go\">&lt;b&gt;\n- [ ] not a task\n</code></pre>\n<p>after</p>\n",
+		},
+		// Quote, break and strikethrough
+		{
+			content:  "> quoted ~~old~~\n\n---",
+			expected: "<blockquote>\n<p>quoted <del>old</del></p>\n</blockquote>\n<hr>\n",
+		},
+		// Links
+		{
+			content:  "[site](https://example.com \"title\") <http://example.com> ![img](/a.png) **bold** snake_case_name",
+			expected: "<p><a href=\"https://example.com\">site</a> <a href=\"http://example.com\">http://example.com</a> <img src=\"/a.png\" alt=\"img\"> <strong>bold</strong> snake_case_name</p>\n",
+		},
+		// Raw HTML
+		{
+			content:  "<script>alert(1)</script>\n<img src=x onerror=alert(1)>",
+			expected: "<p>&lt;script&gt;alert(1)&lt;/script&gt;\n&lt;img src=x onerror=alert(1)&gt;</p>\n",
+		},
+		// Unsafe links
+		{
+			content:  "[click](javascript:alert(1)) [x](\"onclick=\"a) ![i](data:image/png)",
+			expected: "<p>click) <a href=\"&#34;onclick=&#34;a\">x</a> i</p>\n",
+		},
+		// Escapes and line breaks
+		{
+			content:  "\\*not em\\*  \nnext",
+			expected: "<p>*not em*<br>\nnext</p>\n",
+		},
+		// Code spans
+		{
+			content:  "`` a`b `` ``` x `y`",
+			expected: "<p><code>a`b</code> ``` x <code>y</code></p>\n",
+		},
+		// Unmatched delimiters
+		{
+			content:  "[a [b](/x) *c d [e](f _g",
+			expected: "<p>[a <a href=\"/x\">b</a> *c d [e](f _g</p>\n",
+		},
+	}
+
+	for _, c := range cases {
+		if got := string(HTML([]byte(c.content))); got != c.expected {
+			t.Fatalf("Expected %q, got %q", c.expected, got)
+		}
+	}
+}
+
+func TestHTMLUnmatched(t *testing.T) {
+
+	// ticks returns runs of backticks of increasing length
+	ticks := func(runs int) string {
+		var text strings.Builder
+		for i := 1; i <= runs; i++ {
+			text.WriteString(strings.Repeat("`", i) + " ")
+		}
+		return text.String()
+	}
+
+	for i, content := range []string{
+		strings.Repeat("[", 100000),
+		strings.Repeat("[a](", 100000),
+		strings.Repeat("*a ", 100000),
+		strings.Repeat("**a ", 100000),
+		strings.Repeat("_a ", 100000),
+		strings.Repeat("[", 100000) + strings.Repeat("](/x)", 100000),
+		ticks(1000),
+		strings.Repeat("- ", 100000) + "x",
+		strings.Repeat("> ", 100000) + "x",
+		strings.Repeat("1. ", 100000) + "x",
+	} {
+		start := time.Now()
+		HTML([]byte(content))
+		// Scanning the rest of the text for every opener or block takes
+		// minutes
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Fatalf("Expected the delimiters and blocks rendered in linear time, took %s in case %d", elapsed, i)
+		}
+	}
+}
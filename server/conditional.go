@@ -0,0 +1,106 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/carlosmecha/todo/store"
+)
+
+// ErrInvalidCondition when a conditional header can't be parsed
+var ErrInvalidCondition = errors.New("invalid conditional header")
+
+// hasPreconditions returns true if the request has any of the standard
+// conditional headers that are evaluated by preconditions.
+func hasPreconditions(req *http.Request) bool {
+	return req.Header.Get("If-Match") != "" ||
+		req.Header.Get("If-None-Match") != "" ||
+		req.Header.Get("If-Unmodified-Since") != ""
+}
+
+// preconditions evaluates the If-Match, If-Unmodified-Since and If-None-Match
+// headers against the stored revision, in the order defined by RFC 7232. A
+// zero revision means the file doesn't exist. Returns the status code the
+// request must be answered with, or 0 if the request can go on.
+func preconditions(req *http.Request, current store.Revision) (int, error) {
+	exists := !current.IsZero()
+
+	if header := req.Header.Get("If-Match"); header != "" {
+		etags, err := parseETags(header)
+		if err != nil {
+			return 0, err
+		}
+		if !matchETags(etags, current, exists, false) {
+			return 412, nil
+		}
+	} else if header := req.Header.Get("If-Unmodified-Since"); header != "" && exists {
+		// Invalid dates are ignored
+		if date, err := time.Parse(time.RFC1123, header); err == nil && current.Modified.After(date) {
+			return 412, nil
+		}
+	}
+
+	if header := req.Header.Get("If-None-Match"); header != "" {
+		etags, err := parseETags(header)
+		if err != nil {
+			return 0, err
+		}
+		if matchETags(etags, current, exists, true) {
+			if req.Method == "GET" || req.Method == "HEAD" {
+				return 304, nil
+			}
+			return 412, nil
+		}
+	}
+
+	return 0, nil
+}
+
+// parseETags splits a list of entity tags, validating each one.
+func parseETags(header string) ([]string, error) {
+	if strings.TrimSpace(header) == "*" {
+		return []string{"*"}, nil
+	}
+
+	var etags []string
+	for _, etag := range strings.Split(header, ",") {
+		etag = strings.TrimSpace(etag)
+		opaque := strings.TrimPrefix(etag, "W/")
+		if len(opaque) < 2 || opaque[0] != '"' || opaque[len(opaque)-1] != '"' {
+			return nil, ErrInvalidCondition
+		}
+		etags = append(etags, etag)
+	}
+
+	return etags, nil
+}
+
+// matchETags compares the entity tags with the revision. Weak comparison
+// ignores the weak indicator, strong comparison never matches weak tags.
+func matchETags(etags []string, current store.Revision, exists, weak bool) bool {
+	if !exists {
+		return false
+	}
+
+	for _, etag := range etags {
+		if etag == "*" {
+			return true
+		}
+		if weak {
+			etag = strings.TrimPrefix(etag, "W/")
+		}
+		if etag == current.ETag() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// notModifiedSince returns true if the revision wasn't modified after the
+// date, comparing with the precision of HTTP dates.
+func notModifiedSince(current store.Revision, date time.Time) bool {
+	return !current.Modified.Truncate(time.Second).After(date)
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/carlosmecha/todo/store"
+)
+
+func TestPreconditions(t *testing.T) {
+
+	version, _ := time.Parse(time.RFC1123, time.Now().Format(time.RFC1123))
+	current := store.Revision{Number: 2, Hash: "abc", Modified: version}
+
+	cases := []struct {
+		method         string
+		headers        map[string]string
+		current        store.Revision
+		expectedStatus int
+		expectedError  error
+	}{
+		// No conditions
+		{
+			method:  "GET",
+			current: current,
+		},
+		// If-Match
+		{
+			method:  "PUT",
+			headers: map[string]string{"If-Match": "\"1-foo\", \"2-abc\""},
+			current: current,
+		},
+		// If-Match any
+		{
+			method:  "PUT",
+			headers: map[string]string{"If-Match": "*"},
+			current: current,
+		},
+		// If-Match failed
+		{
+			method:         "PUT",
+			headers:        map[string]string{"If-Match": "\"1-foo\""},
+			current:        current,
+			expectedStatus: 412,
+		},
+		// If-Match weak
+		{
+			method:         "PUT",
+			headers:        map[string]string{"If-Match": "W/\"2-abc\""},
+			current:        current,
+			expectedStatus: 412,
+		},
+		// If-Match missing file
+		{
+			method:         "PUT",
+			headers:        map[string]string{"If-Match": "*"},
+			expectedStatus: 412,
+		},
+		// If-Match invalid
+		{
+			method:        "PUT",
+			headers:       map[string]string{"If-Match": "2-abc"},
+			current:       current,
+			expectedError: ErrInvalidCondition,
+		},
+		// If-None-Match
+		{
+			method:         "GET",
+			headers:        map[string]string{"If-None-Match": "W/\"2-abc\""},
+			current:        current,
+			expectedStatus: 304,
+		},
+		// If-None-Match changed
+		{
+			method:  "GET",
+			headers: map[string]string{"If-None-Match": "\"1-foo\""},
+			current: current,
+		},
+		// If-None-Match create
+		{
+			method:  "PUT",
+			headers: map[string]string{"If-None-Match": "*"},
+		},
+		// If-None-Match create existing
+		{
+			method:         "PUT",
+			headers:        map[string]string{"If-None-Match": "*"},
+			current:        current,
+			expectedStatus: 412,
+		},
+		// If-Unmodified-Since
+		{
+			method:  "PUT",
+			headers: map[string]string{"If-Unmodified-Since": version.Format(time.RFC1123)},
+			current: current,
+		},
+		// If-Unmodified-Since failed
+		{
+			method:         "PUT",
+			headers:        map[string]string{"If-Unmodified-Since": version.Add(-time.Hour).Format(time.RFC1123)},
+			current:        current,
+			expectedStatus: 412,
+		},
+		// If-Match wins over If-Unmodified-Since
+		{
+			method: "PUT",
+			headers: map[string]string{
+				"If-Match":            "\"2-abc\"",
+				"If-Unmodified-Since": version.Add(-time.Hour).Format(time.RFC1123),
+			},
+			current: current,
+		},
+	}
+
+	for _, c := range cases {
+		req, err := http.NewRequest(c.method, "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for k, v := range c.headers {
+			req.Header.Set(k, v)
+		}
+
+		status, err := preconditions(req, c.current)
+		if err != c.expectedError {
+			t.Fatalf("Expected error %v, got %v for case %+v", c.expectedError, err, c)
+		}
+
+		if status != c.expectedStatus {
+			t.Fatalf("Expected status %d, got %d for case %+v", c.expectedStatus, status, c)
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/carlosmecha/todo/merge"
+	"github.com/carlosmecha/todo/store"
+)
+
+// EventsKeepAlive is the interval between the keep-alive comments sent to
+// the clients listening for changes, so proxies don't close the connection.
+var EventsKeepAlive = 30 * time.Second
+
+// EventsRetry is the reconnection delay suggested to the clients in
+// milliseconds.
+const EventsRetry = 3000
+
+// EventsDiffLimit is the size of the largest revisions diffed in the events,
+// the larger ones are sent without the changes.
+var EventsDiffLimit = 256 << 10
+
+// eventInfo is the data of the events sent when the file changes
+type eventInfo struct {
+	revisionInfo
+	Diff string `json:"diff,omitempty"`
+}
+
+// hub notifies the new revisions of the files to the clients listening. The
+// files are identified by the path they are served in, empty for the default
+// one.
+type hub struct {
+	subscribers map[string]map[chan store.Revision]bool
+	diffs       map[string]*fileDiffs
+	closed      chan struct{}
+	once        sync.Once
+	mutex       sync.Mutex
+}
+
+// fileDiffs has the diffs to the last revision of a file by the revision
+// they start in
+type fileDiffs struct {
+	to   int64
+	from map[int64]*sharedDiff
+}
+
+// sharedDiff is computed once for all the subscribers, done is closed when
+// it's ready
+type sharedDiff struct {
+	done chan struct{}
+	diff string
+}
+
+func newHub() *hub {
+	return &hub{
+		subscribers: make(map[string]map[chan store.Revision]bool),
+		diffs:       make(map[string]*fileDiffs),
+		closed:      make(chan struct{}),
+	}
+}
+
+// close ends the subscriptions when the server shuts down, the clients
+// reconnect or wait again somewhere else.
+func (h *hub) close() {
+	h.once.Do(func() { close(h.closed) })
+}
+
+// subscribe returns a channel receiving the new revisions of the file. Slow
+// subscribers only get the latest one.
+func (h *hub) subscribe(key string) chan store.Revision {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	updates := make(chan store.Revision, 1)
+	if h.subscribers[key] == nil {
+		h.subscribers[key] = make(map[chan store.Revision]bool)
+	}
+	h.subscribers[key][updates] = true
+	return updates
+}
+
+func (h *hub) unsubscribe(key string, updates chan store.Revision) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	delete(h.subscribers[key], updates)
+	if len(h.subscribers[key]) == 0 {
+		delete(h.subscribers, key)
+		delete(h.diffs, key)
+	}
+}
+
+// diff returns the changes of the file between the revisions, computing them
+// once for all the subscribers waiting for them. Only the diffs to the last
+// revision are kept.
+func (h *hub) diff(key string, from, to int64, compute func() string) string {
+	h.mutex.Lock()
+	diffs := h.diffs[key]
+	if diffs == nil || diffs.to < to {
+		diffs = &fileDiffs{to: to, from: make(map[int64]*sharedDiff)}
+		h.diffs[key] = diffs
+	}
+	if diffs.to != to {
+		h.mutex.Unlock()
+		return compute()
+	}
+
+	shared, found := diffs.from[from]
+	if !found {
+		shared = &sharedDiff{done: make(chan struct{})}
+		diffs.from[from] = shared
+	}
+	h.mutex.Unlock()
+
+	if !found {
+		shared.diff = compute()
+		close(shared.done)
+	}
+	<-shared.done
+	return shared.diff
+}
+
+func (h *hub) notify(key string, revision store.Revision) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for updates := range h.subscribers[key] {
+		// Replace the revision not received yet
+		select {
+		case <-updates:
+		default:
+		}
+		updates <- revision
+	}
+}
+
+// watchedStore notifies the hub when the file is written.
+type watchedStore struct {
+	store.Store
+	key string
+	hub *hub
+}
+
+func (s *watchedStore) SafePut(revision store.Revision, modified time.Time, contentLength int64, reader io.ReadSeeker) (store.Revision, error) {
+	revision, err := s.Store.SafePut(revision, modified, contentLength, reader)
+	if err == nil {
+		s.hub.notify(s.key, revision)
+	}
+	return revision, err
+}
+
+func (s *watchedStore) Overwrite(contentLength int64, reader io.ReadSeeker) (store.Revision, error) {
+	revision, err := s.Store.Overwrite(contentLength, reader)
+	if err == nil {
+		s.hub.notify(s.key, revision)
+	}
+	return revision, err
+}
+
+// events sends the new revisions of the file as Server-Sent Events:
+//
+//	GET /events              new revisions
+//	GET /events?diff=true    new revisions with the changes as a unified diff
+//
+// The current revision is sent first, unless it's the one in the
+// Last-Event-ID header of reconnecting clients. The event ID is the revision
+// number.
+func (h *handler) events(resp http.ResponseWriter, req *http.Request, s store.Store, path string) {
+	if req.Method != "GET" || (path != "/events" && path != "/events/") {
+		h.logger.Info("Invalid path")
+		resp.WriteHeader(404)
+		return
+	}
+
+	flusher, ok := resp.(http.Flusher)
+	if !ok {
+		h.logger.Info("Streaming not supported")
+		resp.WriteHeader(500)
+		return
+	}
+
+	// Subscribe before getting the current revision to not miss any write
+	updates, unsubscribe := h.subscribe(req, path)
+	defer unsubscribe()
+
+	current, err := s.GetCurrentVersion()
+	if err != nil {
+		if err == store.ErrNotFound {
+			h.logger.Info("File not found")
+			resp.WriteHeader(404)
+			return
+		}
+		h.logger.Error("Error getting current version", "error", err)
+		resp.WriteHeader(500)
+		return
+	}
+
+	diff := req.URL.Query().Get("diff") == "true"
+	sent := int64(-1)
+	if id, err := strconv.ParseInt(req.Header.Get("Last-Event-ID"), 10, 64); err == nil {
+		sent = id
+	}
+
+	key := fileKey(req, path)
+	resp.Header().Set("Content-Type", "text/event-stream")
+	resp.Header().Set("Cache-Control", "no-cache")
+	resp.WriteHeader(200)
+	if _, err := fmt.Fprintf(resp, "retry: %d\n\n", EventsRetry); err != nil {
+		return
+	}
+
+	send := func(revision store.Revision) error {
+		event := eventInfo{
+			revisionInfo: revisionInfo{
+				Revision: revision.Number,
+				ETag:     revision.ETag(),
+				Modified: revision.Modified.Format(time.RFC1123),
+			},
+		}
+
+		// The changes since the last revision sent
+		if diff && sent >= 0 {
+			event.Diff = h.hub.diff(key, sent, revision.Number, func() string {
+				return h.diffRevisions(s, sent, revision.Number)
+			})
+		}
+
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		sent = revision.Number
+		if _, err := fmt.Fprintf(resp, "id: %d\nevent: revision\ndata: %s\n\n", revision.Number, data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	if current.Number != sent {
+		if err := send(current); err != nil {
+			h.logger.Error("Error sending event", "error", err)
+			return
+		}
+	} else {
+		flusher.Flush()
+	}
+
+	keepAlive := time.NewTicker(EventsKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-req.Context().Done():
+			h.logger.Info("Events client disconnected")
+			return
+		case <-h.hub.closed:
+			h.logger.Info("Shutting down, closing the events")
+			return
+		case <-keepAlive.C:
+			if _, err := resp.Write([]byte(": keep-alive\n\n")); err != nil {
+				h.logger.Error("Error sending keep-alive", "error", err)
+				return
+			}
+			flusher.Flush()
+		case revision := <-updates:
+			if revision.Number == sent {
+				continue
+			}
+			if err := send(revision); err != nil {
+				h.logger.Error("Error sending event", "error", err)
+				return
+			}
+		}
+	}
+}
+
+// diffRevisions returns the changes between the revisions, empty if they
+// can't be read or they are too large to diff.
+func (h *handler) diffRevisions(s store.Store, from, to int64) string {
+	var contents [2][]byte
+	for i, number := range []int64{from, to} {
+		buff := &bytes.Buffer{}
+		if _, err := s.GetRevision(number, buff); err != nil {
+			h.logger.Error("Error getting the revision to diff", "revision", number, "error", err)
+			return ""
+		}
+		if buff.Len() > EventsDiffLimit {
+			h.logger.Info("Revision too large to diff", "revision", number)
+			return ""
+		}
+		contents[i] = buff.Bytes()
+	}
+	return string(merge.Diff(contents[0], contents[1]))
+}
+
+// watch wraps the store of the file served in the request to notify its
+// changes.
+func (h *handler) watch(req *http.Request, s store.Store, path string) store.Store {
+	return &watchedStore{Store: s, key: fileKey(req, path), hub: h.hub}
+}
+
+// subscribe returns the channel receiving the new revisions of the file
+// served in the request, and the function to stop receiving them.
+func (h *handler) subscribe(req *http.Request, path string) (chan store.Revision, func()) {
+	key := fileKey(req, path)
+	updates := h.hub.subscribe(key)
+	return updates, func() { h.hub.unsubscribe(key, updates) }
+}
+
+// fileKey identifies the file in the hub by the path it's served in.
+func fileKey(req *http.Request, path string) string {
+	return strings.TrimSuffix(req.URL.Path, path)
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/carlosmecha/todo/store"
+)
+
+// event is a Server-Sent Event read by the tests
+type event struct {
+	id   string
+	name string
+	data eventInfo
+}
+
+// readEvent returns the next event, skipping the keep-alive comments.
+func readEvent(reader *bufio.Reader, t *testing.T) event {
+	e := event{}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+
+		switch {
+		case line == "":
+			if e.id != "" {
+				return e
+			}
+		case strings.HasPrefix(line, "id: "):
+			e.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			e.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e.data); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+
+	EventsKeepAlive = 10 * time.Millisecond
+
+	mock := store.NewMemoryStore([]byte("hola\n"), time.Now(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	server, addr := testServer("test", mock, t)
+	defer shutdown(server, t)
+
+	client := &http.Client{}
+
+	// listen connects to the events, after the event ID if not empty
+	listen := func(path, id string) (*http.Response, *bufio.Reader) {
+		req, err := http.NewRequest("GET", addr+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Add("Token", "test")
+		if id != "" {
+			req.Header.Add("Last-Event-ID", id)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, bufio.NewReader(resp.Body)
+	}
+
+	// write stores a new revision
+	write := func(content string) {
+		current, _ := mock.GetCurrentVersion()
+		req, err := http.NewRequest("PUT", addr+"/", bytes.NewReader([]byte(content)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Add("Token", "test")
+		req.Header.Add("If-Match", current.ETag())
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			t.Fatalf("Expected status code 200, got %d", resp.StatusCode)
+		}
+	}
+
+	// The current revision first
+	resp, reader := listen("/events?diff=true", "")
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected status code 200, got %d", resp.StatusCode)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Fatalf("Expected content type text/event-stream, got %s", contentType)
+	}
+
+	e := readEvent(reader, t)
+	if e.id != "1" || e.name != "revision" || e.data.Revision != 1 || e.data.Diff != "" {
+		t.Fatalf("Expected first revision without diff, got %+v", e)
+	}
+
+	// New revision with the diff
+	write("adios\n")
+	e = readEvent(reader, t)
+	current, _ := mock.GetCurrentVersion()
+	if e.id != "2" || e.data.ETag != current.ETag() {
+		t.Fatalf("Expected revision %s, got %+v", current.ETag(), e)
+	}
+	if e.data.Diff != "@@ -1 +1 @@\n-hola\n+adios\n" {
+		t.Fatalf("Expected diff, got %q", e.data.Diff)
+	}
+	resp.Body.Close()
+
+	// Reconnecting with the last revision only gets the new ones
+	resp, reader = listen("/events", "2")
+	write("hello\n")
+	e = readEvent(reader, t)
+	if e.id != "3" || e.data.Diff != "" {
+		t.Fatalf("Expected revision 3 without diff, got %+v", e)
+	}
+	resp.Body.Close()
+
+	// Reconnecting after missing revisions gets the changes since then
+	resp, reader = listen("/events?diff=true", "1")
+	e = readEvent(reader, t)
+	if e.id != "3" || e.data.Diff != "@@ -1 +1 @@\n-hola\n+hello\n" {
+		t.Fatalf("Expected revision 3 with diff, got %+v", e)
+	}
+	resp.Body.Close()
+
+	// Not found
+	resp, _ = listen("/events/foo", "")
+	resp.Body.Close()
+	if resp.StatusCode != 404 {
+		t.Fatalf("Expected status code 404, got %d", resp.StatusCode)
+	}
+}
+
+// countingStore counts the revisions read
+type countingStore struct {
+	store.Store
+	revisions atomic.Int64
+}
+
+func (s *countingStore) GetRevision(number int64, writer io.Writer) (store.Revision, error) {
+	s.revisions.Add(1)
+	return s.Store.GetRevision(number, writer)
+}
+
+func TestEventsDiff(t *testing.T) {
+
+	mock := &countingStore{Store: store.NewMemoryStore([]byte("hola\n"), time.Now(), slog.New(slog.NewTextHandler(os.Stdout, nil)))}
+	server, addr := testServer("test", mock, t)
+	defer shutdown(server, t)
+
+	client := &http.Client{}
+
+	cases := []struct {
+		content      string
+		limit        int
+		expectedDiff string
+	}{
+		// Diffed once for every subscriber
+		{
+			content:      "adios\n",
+			limit:        EventsDiffLimit,
+			expectedDiff: "@@ -1 +1 @@\n-hola\n+adios\n",
+		},
+		// Too large
+		{
+			content: "hello\n",
+			limit:   4,
+		},
+	}
+
+	for i, c := range cases {
+		EventsDiffLimit = c.limit
+		current, _ := mock.GetCurrentVersion()
+
+		var readers []*bufio.Reader
+		for j := 0; j < 3; j++ {
+			req, err := http.NewRequest("GET", addr+"/events?diff=true", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Add("Token", "test")
+			req.Header.Add("Last-Event-ID", strconv.FormatInt(current.Number, 10))
+
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			readers = append(readers, bufio.NewReader(resp.Body))
+		}
+
+		// Every subscriber is listening once the stream started
+		for _, reader := range readers {
+			if line, err := reader.ReadString('\n'); err != nil || !strings.HasPrefix(line, "retry: ") {
+				t.Fatalf("Expected the retry delay, got %q %v in case %d", line, err, i)
+			}
+		}
+
+		mock.revisions.Store(0)
+		req, err := http.NewRequest("PUT", addr+"/", bytes.NewReader([]byte(c.content)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Add("Token", "test")
+		req.Header.Add("If-Match", current.ETag())
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		for _, reader := range readers {
+			if e := readEvent(reader, t); e.data.Diff != c.expectedDiff {
+				t.Fatalf("Expected diff %q, got %q in case %d", c.expectedDiff, e.data.Diff, i)
+			}
+		}
+		// The revision diffed and the previous one
+		if read := mock.revisions.Load(); read > 2 {
+			t.Fatalf("Expected 2 revisions read, got %d in case %d", read, i)
+		}
+	}
+	EventsDiffLimit = 256 << 10
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/carlosmecha/todo/store"
+)
+
+// ReadyCache is how long the result of checking the store is reused, so the
+// probes don't make a request to the store every time
+var ReadyCache = 5 * time.Second
+
+// healthInfo is the JSON representation of the health of the server
+type healthInfo struct {
+	Status  string     `json:"status"`
+	Started string     `json:"started"`
+	Uptime  string     `json:"uptime"`
+	Store   *storeInfo `json:"store,omitempty"`
+}
+
+// storeInfo is the JSON representation of the last check of the store
+type storeInfo struct {
+	Status  string `json:"status"`
+	Checked string `json:"checked"`
+	Latency string `json:"latency"`
+}
+
+// health checks the store for the readiness probes, and fails them once the
+// server is draining.
+type health struct {
+	store    store.Store
+	started  time.Time
+	draining atomic.Bool
+
+	checked time.Time
+	latency time.Duration
+	err     error
+	mutex   sync.Mutex
+}
+
+func newHealth(s store.Store) *health {
+	return &health{store: s, started: time.Now()}
+}
+
+// drain fails the readiness probes from now on.
+func (h *health) drain() {
+	h.draining.Store(true)
+}
+
+// check returns the result of the last check of the store, checking it
+// again if it's older than ReadyCache. An empty store is reachable.
+func (h *health) check() (time.Time, time.Duration, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if time.Since(h.checked) >= ReadyCache {
+		start := time.Now()
+		_, err := h.store.GetCurrentVersion()
+		if err == store.ErrNotFound {
+			err = nil
+		}
+		h.checked, h.latency, h.err = start, time.Since(start), err
+	}
+	return h.checked, h.latency, h.err
+}
+
+// healthz responds 200 while the process is alive.
+func (h *handler) healthz(resp http.ResponseWriter, req *http.Request) {
+	h.writeJSON(resp, 200, h.healthInfo("ok"))
+}
+
+// readyz responds 200 if the store is reachable and the server is not
+// draining, 503 otherwise. The store errors are logged, not returned.
+func (h *handler) readyz(resp http.ResponseWriter, req *http.Request) {
+	if h.health.draining.Load() {
+		h.logger.Debug("Not ready, draining")
+		h.writeJSON(resp, 503, h.healthInfo("draining"))
+		return
+	}
+
+	checked, latency, err := h.health.check()
+	info := h.healthInfo("ready")
+	info.Store = &storeInfo{
+		Status:  "ok",
+		Checked: checked.Format(time.RFC3339),
+		Latency: latency.String(),
+	}
+
+	if err != nil {
+		h.logger.Error("Not ready, error checking the store", "error", err)
+		info.Status, info.Store.Status = "not ready", "unreachable"
+		h.writeJSON(resp, 503, info)
+		return
+	}
+	h.writeJSON(resp, 200, info)
+}
+
+func (h *handler) healthInfo(status string) healthInfo {
+	return healthInfo{
+		Status:  status,
+		Started: h.health.started.Format(time.RFC3339),
+		Uptime:  time.Since(h.health.started).Round(time.Second).String(),
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carlosmecha/todo/store"
+)
+
+// failingStore fails getting the current version while failing is true
+type failingStore struct {
+	store.Store
+	failing bool
+	calls   int
+}
+
+func (s *failingStore) GetCurrentVersion() (store.Revision, error) {
+	s.calls++
+	if s.failing {
+		return store.Revision{}, errors.New("connection refused to s3.secret-bucket")
+	}
+	return s.Store.GetCurrentVersion()
+}
+
+func TestHealth(t *testing.T) {
+
+	ReadyCache = time.Hour
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	s := &failingStore{Store: store.NewMemoryStore(nil, time.Time{}, logger)}
+	h := NewHandler(fullAccess("test", t), nil, s, nil, logger).(*handler)
+
+	cases := []struct {
+		method         string
+		path           string
+		change         func()
+		expectedCode   int
+		expectedStatus string
+		expectedStore  string
+		expectedCalls  int
+	}{
+		// Alive
+		{method: "GET", path: "/healthz", expectedCode: 200, expectedStatus: "ok"},
+		// Ready with an empty store
+		{method: "GET", path: "/readyz", expectedCode: 200, expectedStatus: "ready", expectedStore: "ok", expectedCalls: 1},
+		// Cached
+		{method: "HEAD", path: "/readyz", change: func() { s.failing = true }, expectedCode: 200, expectedCalls: 1},
+		// Unreachable
+		{
+			method:         "GET",
+			path:           "/readyz",
+			change:         func() { h.health.checked = time.Time{} },
+			expectedCode:   503,
+			expectedStatus: "not ready",
+			expectedStore:  "unreachable",
+			expectedCalls:  2,
+		},
+		// Still alive
+		{method: "GET", path: "/healthz", expectedCode: 200, expectedStatus: "ok", expectedCalls: 2},
+		// Draining
+		{
+			method: "GET",
+			path:   "/readyz",
+			change: func() {
+				s.failing = false
+				h.health.checked = time.Time{}
+				h.health.drain()
+			},
+			expectedCode:   503,
+			expectedStatus: "draining",
+			expectedCalls:  2,
+		},
+		// Only the probes are unauthenticated
+		{method: "PUT", path: "/readyz", expectedCode: 401, expectedCalls: 2},
+		{method: "GET", path: "/readyz/foo", expectedCode: 401, expectedCalls: 2},
+	}
+
+	for i, c := range cases {
+		if c.change != nil {
+			c.change()
+		}
+
+		resp := httptest.NewRecorder()
+		h.ServeHTTP(resp, httptest.NewRequest(c.method, c.path, nil))
+
+		if resp.Code != c.expectedCode {
+			t.Fatalf("Expected status code %d, got %d in case %d", c.expectedCode, resp.Code, i)
+		}
+		if s.calls != c.expectedCalls {
+			t.Fatalf("Expected %d checks, got %d in case %d", c.expectedCalls, s.calls, i)
+		}
+		if strings.Contains(resp.Body.String(), "secret-bucket") {
+			t.Fatalf("Expected the error not returned, got %s in case %d", resp.Body.String(), i)
+		}
+		if c.expectedStatus == "" {
+			continue
+		}
+
+		var info healthInfo
+		if err := json.Unmarshal(resp.Body.Bytes(), &info); err != nil {
+			t.Fatalf("Unexpected error %s in case %d", err.Error(), i)
+		}
+		if info.Status != c.expectedStatus {
+			t.Fatalf("Expected status %s, got %s in case %d", c.expectedStatus, info.Status, i)
+		}
+		if info.Uptime == "" || info.Started == "" {
+			t.Fatalf("Expected the uptime, got %+v in case %d", info, i)
+		}
+		if c.expectedStore == "" && info.Store != nil {
+			t.Fatalf("Unexpected store status %+v in case %d", info.Store, i)
+		} else if c.expectedStore != "" && (info.Store == nil || info.Store.Status != c.expectedStore) {
+			t.Fatalf("Expected store status %s, got %+v in case %d", c.expectedStore, info.Store, i)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/carlosmecha/todo/store"
+)
+
+// revisionInfo is the JSON representation of a revision
+type revisionInfo struct {
+	Revision int64  `json:"revision"`
+	ETag     string `json:"etag"`
+	Modified string `json:"modified"`
+}
+
+// history serves the revisions of the file:
+//
+//	GET /history                   lists the revisions, newest first
+//	GET /history/{number}          retrieves a revision
+//	POST /history/{number}/restore stores a revision as the current one
+func (h *handler) history(resp http.ResponseWriter, req *http.Request, s store.Store, path string) {
+	path = strings.Trim(strings.TrimPrefix(path, "/history"), "/")
+	parts := strings.Split(path, "/")
+
+	switch {
+	case req.Method == "GET" && path == "":
+		h.listHistory(resp, req, s)
+	case req.Method == "GET" && len(parts) == 1:
+		if number, err := strconv.ParseInt(parts[0], 10, 64); err == nil {
+			h.getRevision(resp, req, s, number)
+			return
+		}
+		h.logger.Info("Invalid revision")
+		resp.WriteHeader(404)
+	case req.Method == "POST" && len(parts) == 2 && parts[1] == "restore":
+		if number, err := strconv.ParseInt(parts[0], 10, 64); err == nil {
+			h.restore(resp, req, s, number)
+			return
+		}
+		h.logger.Info("Invalid revision")
+		resp.WriteHeader(404)
+	default:
+		h.logger.Info("Invalid path")
+		resp.WriteHeader(404)
+	}
+}
+
+// listHistory returns the revisions as JSON.
+func (h *handler) listHistory(resp http.ResponseWriter, req *http.Request, s store.Store) {
+	revisions, err := s.History()
+	if err != nil {
+		h.logger.Error("Error getting the history", "error", err)
+		resp.WriteHeader(500)
+		return
+	}
+
+	infos := make([]revisionInfo, 0, len(revisions))
+	for _, revision := range revisions {
+		infos = append(infos, revisionInfo{
+			Revision: revision.Number,
+			ETag:     revision.ETag(),
+			Modified: revision.Modified.Format(time.RFC1123),
+		})
+	}
+
+	h.writeJSON(resp, 200, infos)
+}
+
+// getRevision returns the content of a revision.
+func (h *handler) getRevision(resp http.ResponseWriter, req *http.Request, s store.Store, number int64) {
+	buff := &bytes.Buffer{}
+	revision, err := s.GetRevision(number, buff)
+	if err != nil {
+		if err == store.ErrNotFound {
+			h.logger.Info("Revision not found")
+			resp.WriteHeader(404)
+			return
+		}
+		h.logger.Error("Error getting revision", "error", err)
+		resp.WriteHeader(500)
+		return
+	}
+
+	setRevision(resp, revision)
+	resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	resp.WriteHeader(200)
+	if _, err := resp.Write(buff.Bytes()); err != nil {
+		h.logger.Error("Error writing the response", "error", err)
+	}
+}
+
+// restore stores the content of a revision as a new revision. The standard
+// conditional headers are evaluated against the current revision.
+func (h *handler) restore(resp http.ResponseWriter, req *http.Request, s store.Store, number int64) {
+	buff := &bytes.Buffer{}
+	if _, err := s.GetRevision(number, buff); err != nil {
+		if err == store.ErrNotFound {
+			h.logger.Info("Revision not found")
+			resp.WriteHeader(404)
+			return
+		}
+		h.logger.Error("Error getting revision", "error", err)
+		resp.WriteHeader(500)
+		return
+	}
+
+	current, err := s.GetCurrentVersion()
+	if err != nil && err != store.ErrNotFound {
+		h.logger.Error("Error getting current version", "error", err)
+		resp.WriteHeader(500)
+		return
+	}
+
+	conditional := hasPreconditions(req)
+	if conditional {
+		status, err := preconditions(req, current)
+		if err != nil {
+			h.logger.Info("Unrecognized conditional header")
+			resp.WriteHeader(400)
+			return
+		}
+
+		if status != 0 {
+			h.logger.Info("Precondition failed", "stored", current.ETag())
+			resp.WriteHeader(status)
+			return
+		}
+	}
+
+	revision, err := s.SafePut(current, time.Now(), int64(buff.Len()), bytes.NewReader(buff.Bytes()))
+	if err != nil {
+		if err != store.ErrVersionConflict {
+			h.logger.Error("Error writing file", "error", err)
+			resp.WriteHeader(500)
+			return
+		}
+		h.logger.Info("Version conflict restoring revision", "revision", number)
+		if conditional {
+			resp.WriteHeader(412)
+		} else {
+			resp.WriteHeader(409)
+		}
+		return
+	}
+
+	h.logger.Info("Restored revision", "revision", number, "new", revision.Number)
+	setRevision(resp, revision)
+	resp.WriteHeader(200)
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/carlosmecha/todo/store"
+)
+
+func TestHistory(t *testing.T) {
+
+	mock := store.NewMemoryStore([]byte("hola"), time.Now(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	first, _ := mock.GetCurrentVersion()
+	second, err := mock.SafePut(first, time.Now(), 5, bytes.NewReader([]byte("adios")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		method       string
+		path         string
+		etag         string
+		expectedCode int
+		expectedBody string
+		expectedETag string
+	}{
+		// List
+		{
+			method:       "GET",
+			path:         "/history",
+			expectedCode: 200,
+		},
+		// Get revision
+		{
+			method:       "GET",
+			path:         "/history/1",
+			expectedCode: 200,
+			expectedBody: "hola",
+			expectedETag: first.ETag(),
+		},
+		// Revision not found
+		{
+			method:       "GET",
+			path:         "/history/5",
+			expectedCode: 404,
+		},
+		// Invalid revision
+		{
+			method:       "GET",
+			path:         "/history/foo",
+			expectedCode: 404,
+		},
+		// Restore with old ETag
+		{
+			method:       "POST",
+			path:         "/history/1/restore",
+			etag:         first.ETag(),
+			expectedCode: 412,
+		},
+		// Restore
+		{
+			method:       "POST",
+			path:         "/history/1/restore",
+			etag:         second.ETag(),
+			expectedCode: 200,
+		},
+		// Restored
+		{
+			method:       "GET",
+			path:         "/",
+			expectedCode: 200,
+			expectedBody: "hola",
+		},
+		// Invalid method
+		{
+			method:       "DELETE",
+			path:         "/history/1",
+			expectedCode: 404,
+		},
+	}
+
+	server, addr := testServer("test", mock, t)
+	defer shutdown(server, t)
+
+	client := &http.Client{}
+
+	for _, c := range cases {
+		req, err := http.NewRequest(c.method, addr+c.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Add("Token", "test")
+		if c.etag != "" {
+			req.Header.Add("If-Match", c.etag)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != c.expectedCode {
+			t.Fatalf("Expected %d status, got %d for case %+v", c.expectedCode, resp.StatusCode, c)
+		}
+
+		if c.expectedBody != "" && string(body) != c.expectedBody {
+			t.Fatalf("Expected body %s, got %s for case %+v", c.expectedBody, string(body), c)
+		}
+
+		if c.expectedETag != "" && resp.Header.Get("ETag") != c.expectedETag {
+			t.Fatalf("Expected ETag %s, got %s for case %+v", c.expectedETag, resp.Header.Get("ETag"), c)
+		}
+	}
+
+	revisions, err := mock.History()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(revisions) != 3 || revisions[0].Hash != first.Hash {
+		t.Fatalf("Expected the restored revision on top, got %+v", revisions)
+	}
+
+	req, _ := http.NewRequest("GET", addr+"/history", nil)
+	req.Header.Add("Token", "test")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var infos []revisionInfo
+	if err := json.NewDecoder(resp.Body).Decode(&infos); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(infos) != 3 || infos[0].Revision != 3 || infos[2].ETag != first.ETag() {
+		t.Fatalf("Unexpected history %+v", infos)
+	}
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/carlosmecha/todo/auth"
+	"github.com/carlosmecha/todo/store"
+)
+
+// serveLists serves the named lists:
+//
+//	GET /lists               lists the names
+//	POST /lists/{name}       creates an empty list
+//	DELETE /lists/{name}     deletes the list and its history
+//	/lists/{name}/...        same requests as the default list
+//
+// Tokens only see the lists they can access, creating and deleting them needs
+// the admin scope.
+func (h *handler) serveLists(resp http.ResponseWriter, req *http.Request) {
+	if h.lists == nil {
+		h.logger.Info("Named lists not configured")
+		resp.WriteHeader(404)
+		return
+	}
+
+	path := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, "/lists"), "/")
+	if path == "" {
+		if req.Method != "GET" {
+			h.logger.Info("Invalid request, method not recognized")
+			resp.WriteHeader(404)
+			return
+		}
+		h.listNames(resp, req)
+		return
+	}
+
+	name := path
+	path = ""
+	if i := strings.Index(name, "/"); i >= 0 {
+		name, path = name[:i], name[i:]
+	}
+
+	if !store.ValidName(name) {
+		h.logger.Info("Invalid list name")
+		if req.Method == "POST" && path == "" {
+			resp.WriteHeader(400)
+		} else {
+			resp.WriteHeader(404)
+		}
+		return
+	}
+
+	if path == "" && (req.Method == "POST" || req.Method == "DELETE") {
+		if token := tokenOf(req); !token.Allows(name, auth.Admin) {
+			h.logger.Info("Token without scope", "token", token.Name, "scope", auth.Admin)
+			resp.WriteHeader(403)
+			return
+		}
+
+		if req.Method == "POST" {
+			h.createList(resp, req, name)
+		} else {
+			h.deleteList(resp, req, name)
+		}
+		return
+	}
+
+	if !h.authorize(resp, req, name, path) {
+		return
+	}
+
+	s, err := h.lists.Open(name)
+	if err != nil {
+		if err == store.ErrNotFound {
+			h.logger.Info("List not found", "list", name)
+			resp.WriteHeader(404)
+			return
+		}
+		h.logger.Error("Error opening list", "list", name, "error", err)
+		resp.WriteHeader(500)
+		return
+	}
+
+	h.serveFile(resp, req, s, path)
+}
+
+// listNames returns the names of the lists the token can access as JSON.
+func (h *handler) listNames(resp http.ResponseWriter, req *http.Request) {
+	token := tokenOf(req)
+	if !token.Can(auth.Read) {
+		h.logger.Info("Token without scope", "token", token.Name, "scope", auth.Read)
+		resp.WriteHeader(403)
+		return
+	}
+
+	names, err := h.lists.Names()
+	if err != nil {
+		h.logger.Error("Error getting the lists", "error", err)
+		resp.WriteHeader(500)
+		return
+	}
+
+	allowed := []string{}
+	for _, name := range names {
+		if token.CanAccess(name) {
+			allowed = append(allowed, name)
+		}
+	}
+
+	h.writeJSON(resp, 200, allowed)
+}
+
+// createList creates an empty list.
+func (h *handler) createList(resp http.ResponseWriter, req *http.Request, name string) {
+	s, err := h.lists.Create(name)
+	if err != nil {
+		if err == store.ErrExists {
+			h.logger.Info("List already exists", "list", name)
+			resp.WriteHeader(409)
+			return
+		}
+		h.logger.Error("Error creating list", "list", name, "error", err)
+		resp.WriteHeader(500)
+		return
+	}
+
+	revision, err := s.GetCurrentVersion()
+	if err != nil {
+		h.logger.Error("Error getting current version", "error", err)
+		resp.WriteHeader(500)
+		return
+	}
+
+	setRevision(resp, revision)
+	resp.WriteHeader(201)
+}
+
+// deleteList deletes the list and its history.
+func (h *handler) deleteList(resp http.ResponseWriter, req *http.Request, name string) {
+	if err := h.lists.Delete(name); err != nil {
+		if err == store.ErrNotFound {
+			h.logger.Info("List not found", "list", name)
+			resp.WriteHeader(404)
+			return
+		}
+		h.logger.Error("Error deleting list", "list", name, "error", err)
+		resp.WriteHeader(500)
+		return
+	}
+
+	resp.WriteHeader(204)
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/carlosmecha/todo/store"
+)
+
+func TestLists(t *testing.T) {
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	lists := store.NewMemoryLists(logger)
+	team, err := lists.Create("team")
+	if err != nil {
+		t.Fatal(err)
+	}
+	revision, _ := team.GetCurrentVersion()
+
+	cases := []struct {
+		method       string
+		path         string
+		body         string
+		etag         string
+		expectedCode int
+		expectedBody string
+	}{
+		// Names
+		{
+			method:       "GET",
+			path:         "/lists",
+			expectedCode: 200,
+			expectedBody: `["team"]`,
+		},
+		// Create
+		{
+			method:       "POST",
+			path:         "/lists/personal",
+			expectedCode: 201,
+		},
+		// Already exists
+		{
+			method:       "POST",
+			path:         "/lists/personal",
+			expectedCode: 409,
+		},
+		// Invalid name
+		{
+			method:       "POST",
+			path:         "/lists/per.sonal",
+			expectedCode: 400,
+		},
+		// Names after creating
+		{
+			method:       "GET",
+			path:         "/lists/",
+			expectedCode: 200,
+			expectedBody: `["personal","team"]`,
+		},
+		// Put
+		{
+			method:       "PUT",
+			path:         "/lists/team",
+			body:         "hola",
+			etag:         revision.ETag(),
+			expectedCode: 200,
+		},
+		// Get
+		{
+			method:       "GET",
+			path:         "/lists/team/",
+			expectedCode: 200,
+			expectedBody: "hola",
+		},
+		// Other lists don't change
+		{
+			method:       "GET",
+			path:         "/lists/personal",
+			expectedCode: 200,
+		},
+		// Default list
+		{
+			method:       "GET",
+			path:         "/",
+			expectedCode: 200,
+			expectedBody: "default",
+		},
+		// History
+		{
+			method:       "GET",
+			path:         "/lists/team/history/1",
+			expectedCode: 200,
+		},
+		// Not found
+		{
+			method:       "GET",
+			path:         "/lists/sprint",
+			expectedCode: 404,
+		},
+		// Invalid path
+		{
+			method:       "GET",
+			path:         "/lists/team/foo",
+			expectedCode: 404,
+		},
+		// Delete
+		{
+			method:       "DELETE",
+			path:         "/lists/team",
+			expectedCode: 204,
+		},
+		// Deleted
+		{
+			method:       "GET",
+			path:         "/lists/team",
+			expectedCode: 404,
+		},
+		// Delete not found
+		{
+			method:       "DELETE",
+			path:         "/lists/team",
+			expectedCode: 404,
+		},
+	}
+
+	server, addr := serve(NewHandler(fullAccess("test", t), nil, store.NewMemoryStore([]byte("default"), time.Now(), logger), lists, logger).(*handler), t)
+	defer shutdown(server, t)
+
+	client := &http.Client{}
+
+	for _, c := range cases {
+		req, err := http.NewRequest(c.method, addr+c.path, bytes.NewReader([]byte(c.body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Add("Token", "test")
+		if c.etag != "" {
+			req.Header.Add("If-Match", c.etag)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != c.expectedCode {
+			t.Fatalf("Expected %d status, got %d for case %+v", c.expectedCode, resp.StatusCode, c)
+		}
+
+		if c.expectedBody != "" && string(body) != c.expectedBody {
+			t.Fatalf("Expected body %s, got %s for case %+v", c.expectedBody, string(body), c)
+		}
+	}
+}
+
+func TestListsNotConfigured(t *testing.T) {
+
+	server, addr := testServer("test", store.NewMemoryStore(nil, time.Time{}, slog.New(slog.NewTextHandler(os.Stdout, nil))), t)
+	defer shutdown(server, t)
+
+	req, err := http.NewRequest("GET", addr+"/lists", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Token", "test")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != 404 {
+		t.Fatalf("Expected 404 status, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/carlosmecha/todo/merge"
+	"github.com/carlosmecha/todo/store"
+)
+
+// mergePut stores the file merging it with the stored one. The If-Match
+// header has the ETag of the base revision the client edited, which must be
+// in the history. If the file changed since then, the changes of both are
+// merged line by line:
+//
+//	200 the merged file is stored and returned
+//	409 both changed the same lines, the file with conflict markers is
+//	    returned with the ETag of the stored revision to resolve them and
+//	    the number of conflicts in the Merge-Conflicts header
+//	412 the base revision is not in the history
+//	503 other writes kept changing the file while merging, it can be
+//	    tried again after the seconds of the Retry-After header
+func (h *handler) mergePut(resp http.ResponseWriter, req *http.Request, s store.Store, reader *bytes.Reader) {
+	base, err := store.ParseETag(req.Header.Get("If-Match"))
+	if err != nil {
+		h.logger.Info("Merge requires the ETag of the base revision")
+		resp.WriteHeader(400)
+		return
+	}
+
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		h.logger.Error("Error reading body", "error", err)
+		resp.WriteHeader(500)
+		return
+	}
+
+	baseContent := &bytes.Buffer{}
+	if revision, err := s.GetRevision(base.Number, baseContent); err != nil || !revision.Equal(base) {
+		if err != nil && err != store.ErrNotFound {
+			h.logger.Error("Error getting revision", "error", err)
+			resp.WriteHeader(500)
+			return
+		}
+		h.logger.Info("Base revision not found", "base", base.ETag())
+		resp.WriteHeader(412)
+		return
+	}
+
+	for i := 0; i < TaskRetries; i++ {
+		stored := &bytes.Buffer{}
+		current, err := s.Get(store.Revision{}, stored)
+		if err != nil {
+			if err == store.ErrNotFound {
+				h.logger.Info("File not found")
+				resp.WriteHeader(412)
+				return
+			}
+			h.logger.Error("Error getting file", "error", err)
+			resp.WriteHeader(500)
+			return
+		}
+
+		merged, conflicts := content, 0
+		if !current.Equal(base) {
+			merged, conflicts = merge.Merge(baseContent.Bytes(), stored.Bytes(), content, merge.Labels{
+				Ours:   "stored " + current.ETag(),
+				Base:   "base " + base.ETag(),
+				Theirs: "yours",
+			})
+		}
+
+		if conflicts > 0 {
+			h.logger.Info("Merge with conflicts", "conflicts", conflicts)
+			setRevision(resp, current)
+			resp.Header().Set("Merge-Conflicts", strconv.Itoa(conflicts))
+			h.writeContent(resp, 409, merged)
+			return
+		}
+
+		if int64(len(merged)) >= SizeLimit {
+			h.logger.Info("Merged file too large")
+			resp.WriteHeader(413)
+			return
+		}
+
+		revision, err := s.SafePut(current, time.Now(), int64(len(merged)), bytes.NewReader(merged))
+		if err != nil {
+			if err != store.ErrVersionConflict {
+				h.logger.Error("Error writing file", "error", err)
+				resp.WriteHeader(500)
+				return
+			}
+			h.logger.Info("Version conflict merging file, trying again")
+			continue
+		}
+
+		h.logger.Info("Merged revision", "base", base.ETag(), "revision", revision.ETag())
+		setRevision(resp, revision)
+		h.writeContent(resp, 200, merged)
+		return
+	}
+
+	h.logger.Warn("Too many version conflicts merging file")
+	resp.Header().Set("Retry-After", "1")
+	resp.WriteHeader(503)
+}
+
+// writeContent writes the file as the response.
+func (h *handler) writeContent(resp http.ResponseWriter, status int, content []byte) {
+	resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	resp.WriteHeader(status)
+	if _, err := resp.Write(content); err != nil {
+		h.logger.Error("Error writing the response", "error", err)
+	}
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carlosmecha/todo/store"
+)
+
+func TestMerge(t *testing.T) {
+
+	mock := store.NewMemoryStore([]byte("a\nb\nc\nd\n"), time.Now(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	first, _ := mock.GetCurrentVersion()
+	if _, err := mock.SafePut(first, time.Now(), 8, bytes.NewReader([]byte("a\nB\nc\nd\n"))); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		etag              string
+		body              string
+		expectedCode      int
+		expectedBody      string
+		expectedConflicts string
+	}{
+		// Merged with the stored changes
+		{
+			etag:         first.ETag(),
+			body:         "a\nb\nc\nD\n",
+			expectedCode: 200,
+			expectedBody: "a\nB\nc\nD\n",
+		},
+		// Conflict
+		{
+			etag:              first.ETag(),
+			body:              "a\nX\nc\nd\n",
+			expectedCode:      409,
+			expectedBody:      "a\n<<<<<<< stored {current}\nB\n||||||| base " + first.ETag() + "\nb\n=======\nX\n>>>>>>> yours\nc\nD\n",
+			expectedConflicts: "1",
+		},
+		// Base revision not found
+		{
+			etag:         "\"9-foo\"",
+			body:         "a\n",
+			expectedCode: 412,
+		},
+		// Without base revision
+		{
+			body:         "a\n",
+			expectedCode: 400,
+		},
+	}
+
+	server, addr := testServer("test", mock, t)
+	defer shutdown(server, t)
+
+	client := &http.Client{}
+
+	for i, c := range cases {
+		req, err := http.NewRequest("PUT", addr+"/", bytes.NewReader([]byte(c.body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Add("Token", "test")
+		req.Header.Add("Merge", "true")
+		if c.etag != "" {
+			req.Header.Add("If-Match", c.etag)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != c.expectedCode {
+			t.Fatalf("Expected status code %d, got %d in case %d", c.expectedCode, resp.StatusCode, i)
+		}
+
+		// The body has the ETag of the stored revision in the markers
+		current, _ := mock.GetCurrentVersion()
+		expected := strings.Replace(c.expectedBody, "{current}", current.ETag(), 1)
+		if string(body) != expected {
+			t.Fatalf("Expected body %q, got %q in case %d", expected, string(body), i)
+		}
+
+		if conflicts := resp.Header.Get("Merge-Conflicts"); conflicts != c.expectedConflicts {
+			t.Fatalf("Expected %q conflicts, got %q in case %d", c.expectedConflicts, conflicts, i)
+		}
+
+		if c.expectedCode == 200 || c.expectedCode == 409 {
+			if etag := resp.Header.Get("ETag"); etag != current.ETag() {
+				t.Fatalf("Expected ETag %s, got %s in case %d", current.ETag(), etag, i)
+			}
+		}
+	}
+}
+
+// conflictingStore fails every write with a version conflict, like other
+// clients writing all the time.
+type conflictingStore struct {
+	store.Store
+}
+
+func (s *conflictingStore) SafePut(revision store.Revision, modified time.Time, contentLength int64, reader io.ReadSeeker) (store.Revision, error) {
+	return store.Revision{}, store.ErrVersionConflict
+}
+
+func TestMergeRetries(t *testing.T) {
+
+	mock := &conflictingStore{store.NewMemoryStore([]byte("a\n"), time.Now(), slog.New(slog.NewTextHandler(os.Stdout, nil)))}
+	current, _ := mock.GetCurrentVersion()
+
+	server, addr := testServer("test", mock, t)
+	defer shutdown(server, t)
+
+	req, err := http.NewRequest("PUT", addr+"/", bytes.NewReader([]byte("b\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Token", "test")
+	req.Header.Add("Merge", "true")
+	req.Header.Add("If-Match", current.ETag())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// Not a conflict to resolve, the merge can be tried again
+	if resp.StatusCode != 503 {
+		t.Fatalf("Expected status code 503, got %d", resp.StatusCode)
+	}
+	if retry := resp.Header.Get("Retry-After"); retry != "1" {
+		t.Fatalf("Expected Retry-After 1, got %q", retry)
+	}
+}
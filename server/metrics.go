@@ -0,0 +1,182 @@
+package server
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/carlosmecha/todo/metrics"
+	"github.com/carlosmecha/todo/store"
+)
+
+// RunMetrics starts a server in the address serving the metrics of the
+// registry in /metrics, without authentication.
+func RunMetrics(addr string, registry *metrics.Registry, logger *slog.Logger) *http.Server {
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry)
+
+	server := &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error("Metrics server shutdown", "error", err)
+		}
+	}()
+
+	return server
+}
+
+// Instrument returns the handler recording the requests in the registry:
+// their count and latency by method and status, and how many conflicts and
+// not modified responses there were.
+func Instrument(next http.Handler, registry *metrics.Registry) http.Handler {
+	requests := registry.NewCounter("todo_http_requests_total", "Requests by method and status.", "method", "status")
+	latency := registry.NewHistogram("todo_http_request_duration_seconds", "Request latency by method and status.", metrics.DefaultBuckets, "method", "status")
+	conflicts := registry.NewCounter("todo_conflicts_total", "Responses with status 409 Conflict.")
+	notModified := registry.NewCounter("todo_not_modified_total", "Responses with status 304 Not Modified.")
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: resp}
+		next.ServeHTTP(recorder, req)
+
+		status := recorder.status
+		if status == 0 {
+			status = 200
+		}
+
+		code, method := strconv.Itoa(status), methodLabel(req.Method)
+		requests.Inc(method, code)
+		latency.Observe(time.Since(start).Seconds(), method, code)
+
+		switch status {
+		case 409:
+			conflicts.Inc()
+		case 304:
+			notModified.Inc()
+		}
+	})
+}
+
+// methodLabel returns the method of the request, or other if it's not a
+// standard one, so the clients can't add labels.
+func methodLabel(method string) string {
+	switch method {
+	case "GET", "HEAD", "PUT", "POST", "PATCH", "DELETE", "OPTIONS":
+		return method
+	}
+	return "other"
+}
+
+// statusRecorder keeps the status of the response. It flushes the events.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(content []byte) (int, error) {
+	if r.status == 0 {
+		r.status = 200
+	}
+	return r.ResponseWriter.Write(content)
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// StoreObserver returns the observer recording the latency and errors of the
+// S3 requests in the registry.
+func StoreObserver(registry *metrics.Registry) store.Observer {
+	latency := registry.NewHistogram("todo_store_request_duration_seconds", "S3 request latency by operation.", metrics.DefaultBuckets, "operation")
+	failures := registry.NewCounter("todo_store_errors_total", "Failed S3 requests by operation.", "operation")
+
+	return func(operation string, elapsed time.Duration, err error) {
+		latency.Observe(elapsed.Seconds(), operation)
+		if err != nil {
+			failures.Inc(operation)
+		}
+	}
+}
+
+// RegisterDocument adds the size and the age of the current revision of the
+// file to the registry. They are NaN when it can't be read. The age is from
+// the date the store wrote the revision, the clients set the modification
+// date.
+func RegisterDocument(registry *metrics.Registry, s store.Store, logger *slog.Logger) {
+	d := &document{store: s, logger: logger}
+
+	registry.NewGaugeFunc("todo_document_size_bytes", "Size of the current revision.", func() float64 {
+		if _, size, ok := d.current(); ok {
+			return float64(size)
+		}
+		return math.NaN()
+	})
+
+	registry.NewGaugeFunc("todo_document_age_seconds", "Time since the current revision was stored.", func() float64 {
+		if revision, _, ok := d.current(); ok && !revision.Stored.IsZero() {
+			return time.Since(revision.Stored).Seconds()
+		}
+		return math.NaN()
+	})
+}
+
+// document keeps the size of the current revision, so the file is only read
+// when it changes.
+type document struct {
+	store  store.Store
+	logger *slog.Logger
+
+	revision store.Revision
+	size     int64
+	mutex    sync.Mutex
+}
+
+// current returns the current revision and its size.
+func (d *document) current() (store.Revision, int64, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	revision, err := d.store.GetCurrentVersion()
+	if err != nil {
+		d.logger.Error("Error getting the current version for the metrics", "error", err)
+		return store.Revision{}, 0, false
+	}
+
+	if !revision.Equal(d.revision) || d.revision.IsZero() {
+		counter := &countingWriter{}
+		if revision, err = d.store.Get(store.Revision{}, counter); err != nil {
+			d.logger.Error("Error getting the file for the metrics", "error", err)
+			return store.Revision{}, 0, false
+		}
+		d.revision, d.size = revision, counter.count
+	}
+
+	return d.revision, d.size, true
+}
+
+// countingWriter counts the bytes written, discarding them
+type countingWriter struct {
+	count int64
+}
+
+func (w *countingWriter) Write(content []byte) (int, error) {
+	w.count += int64(len(content))
+	return len(content), nil
+}
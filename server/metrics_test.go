@@ -0,0 +1,131 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carlosmecha/todo/metrics"
+	"github.com/carlosmecha/todo/store"
+)
+
+func TestMetrics(t *testing.T) {
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	modified := time.Now().Add(-time.Hour).Truncate(time.Second)
+	mock := store.NewMemoryStore([]byte("hola"), modified, logger)
+	current, _ := mock.GetCurrentVersion()
+
+	registry := metrics.NewRegistry()
+	RegisterDocument(registry, mock, logger)
+	observer := StoreObserver(registry)
+	observer("GetObject", 20*time.Millisecond, nil)
+	observer("PutObject", 2*time.Second, errors.New("internal error"))
+
+	ts := httptest.NewServer(Instrument(NewHandler(fullAccess("test", t), nil, mock, nil, logger), registry))
+	defer ts.Close()
+
+	cases := []struct {
+		method  string
+		headers map[string]string
+		body    string
+	}{
+		// OK
+		{method: "GET"},
+		{method: "GET"},
+		// Not modified
+		{method: "GET", headers: map[string]string{"If-None-Match": current.ETag()}},
+		// Conflict
+		{method: "PUT", headers: map[string]string{"Last-Modified": modified.Add(-time.Hour).Format(time.RFC1123)}, body: "adios"},
+		// Unauthorized
+		{method: "HEAD", headers: map[string]string{"Token": "foo"}},
+		// Unknown methods
+		{method: "BREW"},
+		{method: "get"},
+	}
+
+	for i, c := range cases {
+		req, err := http.NewRequest(c.method, ts.URL+"/", bytes.NewReader([]byte(c.body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Add("Token", "test")
+		for key, value := range c.headers {
+			req.Header.Set(key, value)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Unexpected error %s in case %d", err.Error(), i)
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+
+	buff := &bytes.Buffer{}
+	if err := registry.Write(buff); err != nil {
+		t.Fatal(err)
+	}
+	output := buff.String()
+
+	for _, expected := range []string{
+		`todo_http_requests_total{method="GET",status="200"} 2`,
+		`todo_http_requests_total{method="GET",status="304"} 1`,
+		`todo_http_requests_total{method="PUT",status="409"} 1`,
+		`todo_http_requests_total{method="HEAD",status="401"} 1`,
+		`todo_http_requests_total{method="other",status="404"} 2`,
+		`todo_http_request_duration_seconds_count{method="GET",status="200"} 2`,
+		"todo_conflicts_total 1",
+		"todo_not_modified_total 1",
+		"todo_document_size_bytes 4",
+		`todo_store_request_duration_seconds_count{operation="GetObject"} 1`,
+		`todo_store_request_duration_seconds_bucket{operation="PutObject",le="1"} 0`,
+		`todo_store_errors_total{operation="PutObject"} 1`,
+	} {
+		if !strings.Contains(output, expected) {
+			t.Fatalf("Expected %s in the metrics:\n%s", expected, output)
+		}
+	}
+
+	if strings.Contains(output, "BREW") || strings.Contains(output, `method="get"`) {
+		t.Fatalf("Unexpected request methods in the metrics:\n%s", output)
+	}
+	if strings.Contains(output, `todo_store_errors_total{operation="GetObject"}`) {
+		t.Fatalf("Unexpected GetObject errors in the metrics:\n%s", output)
+	}
+	if !strings.Contains(output, "todo_document_age_seconds 36") {
+		t.Fatalf("Expected an hour old document in the metrics:\n%s", output)
+	}
+
+	// The age is from the write, not from the date the client sets
+	req, err := http.NewRequest("PUT", ts.URL+"/", bytes.NewReader([]byte("adios")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Token", "test")
+	req.Header.Set("Last-Modified", time.Now().Add(24*time.Hour).Format(time.RFC1123))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected status code 200, got %d", resp.StatusCode)
+	}
+
+	buff.Reset()
+	if err := registry.Write(buff); err != nil {
+		t.Fatal(err)
+	}
+	if output := buff.String(); !regexp.MustCompile(`todo_document_age_seconds [01](\.\d+)?\n`).MatchString(output) {
+		t.Fatalf("Expected a new document in the metrics:\n%s", output)
+	}
+}
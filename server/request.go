@@ -0,0 +1,29 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+)
+
+// RequestIDHeader is the header with the ID of the request, in the request
+// and the response. Every log line of the request has it.
+const RequestIDHeader = "X-Request-Id"
+
+// validRequestID are the IDs accepted from the clients or proxies
+var validRequestID = regexp.MustCompile("^[a-zA-Z0-9._-]{1,64}$")
+
+// requestID returns the ID provided in the request, or a random one if it's
+// missing or invalid.
+func requestID(req *http.Request) string {
+	if id := req.Header.Get(RequestIDHeader); validRequestID.MatchString(id) {
+		return id
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/carlosmecha/todo/store"
+)
+
+// syncBuffer is a buffer safe for concurrent writes
+type syncBuffer struct {
+	buff  bytes.Buffer
+	mutex sync.Mutex
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buff.Write(p)
+}
+
+func (b *syncBuffer) lines() []string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return strings.Split(strings.TrimSpace(b.buff.String()), "\n")
+}
+
+func TestRequestID(t *testing.T) {
+
+	output := &syncBuffer{}
+	logger := slog.New(slog.NewJSONHandler(output, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	h := NewHandler(fullAccess("test", t), nil, store.NewMemoryStore(nil, time.Time{}, logger), nil, logger)
+
+	cases := []struct {
+		id         string
+		expectedID string
+	}{
+		// Provided
+		{id: "abc-123.x_y", expectedID: "abc-123.x_y"},
+		// Missing
+		{},
+		// Invalid
+		{id: "abc 123\n"},
+		{id: strings.Repeat("a", 65)},
+	}
+
+	for i, c := range cases {
+		output.buff.Reset()
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Token", "test")
+		if c.id != "" {
+			req.Header.Set(RequestIDHeader, c.id)
+		}
+		resp := httptest.NewRecorder()
+		h.ServeHTTP(resp, req)
+
+		if resp.Code != 404 {
+			t.Fatalf("Expected status code 404, got %d in case %d", resp.Code, i)
+		}
+
+		id := resp.Header().Get(RequestIDHeader)
+		if c.expectedID != "" && id != c.expectedID {
+			t.Fatalf("Expected request ID %s, got %s in case %d", c.expectedID, id, i)
+		} else if c.expectedID == "" && (id == c.id || !validRequestID.MatchString(id)) {
+			t.Fatalf("Expected a new request ID, got %q in case %d", id, i)
+		}
+
+		// The handler and the store log with the ID
+		messages := map[string]bool{}
+		for _, line := range output.lines() {
+			var entry struct {
+				Msg       string `json:"msg"`
+				RequestID string `json:"request_id"`
+			}
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatalf("Invalid log line %s in case %d", line, i)
+			}
+			if entry.RequestID != id {
+				t.Fatalf("Expected request ID %s, got %s logging %s in case %d", id, entry.RequestID, entry.Msg, i)
+			}
+			messages[entry.Msg] = true
+		}
+		if !messages["Request"] || !messages["File not found"] || !messages["Request served"] {
+			t.Fatalf("Missing log lines, got %v in case %d", messages, i)
+		}
+	}
+}
@@ -2,18 +2,24 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/carlosmecha/todo/auth"
+	"github.com/carlosmecha/todo/metrics"
 	"github.com/carlosmecha/todo/store"
 )
 
-// SizeLimit is the max size of the request body (1MB)
-const SizeLimit = int64(1 * 1024 * 1024)
+// SizeLimit is the max size of the request body, 1MB by default
+var SizeLimit = int64(1 * 1024 * 1024)
 
 var (
 	// ErrNoAuthProvided when the request doesn't have the auth token
@@ -23,214 +29,512 @@ var (
 	ErrInvalidAuth = errors.New("invalid token")
 )
 
+// tokenKey is the request context key of the authenticated token
+type tokenKey struct{}
+
 // handler takes care of the requests. Is a net/http.Handler
 type handler struct {
-	authToken string
-	logger    *log.Logger
-	store     store.Store
+	tokens auth.Registry
+	shares auth.Shares
+	logger *slog.Logger
+	store  store.Store
+	lists  store.Lists
+	hub    *hub
+	health *health
+}
+
+// NewHandler creates the handler serving the default list in / and the named
+// ones in /lists/{name}. Lists and shares can be nil, sharing is disabled
+// without shares.
+func NewHandler(tokens auth.Registry, shares auth.Shares, store store.Store, lists store.Lists, logger *slog.Logger) http.Handler {
+	return &handler{
+		tokens: tokens,
+		shares: shares,
+		store:  store,
+		lists:  lists,
+		logger: logger,
+		hub:    newHub(),
+		health: newHealth(store),
+	}
 }
 
-// RunServer starts the server listening in the specified address.
-func RunServer(token, addr string, store store.Store, logger *log.Logger) *http.Server {
+// Shutdown is how the server stops
+type Shutdown struct {
+	// Drain is how long the readiness probes fail before stopping accepting
+	// requests, for the load balancers to notice
+	Drain time.Duration
 
-	h := &handler{
-		authToken: token,
-		store:     store,
-		logger:    logger,
+	// Timeout is the longest the requests in progress can take to finish
+	Timeout time.Duration
+}
+
+// RunServer serves the lists in the address until the context is done, then
+// shuts down gracefully: the readiness probes fail during the drain, the
+// events and the waiting requests end, and the requests in progress have
+// until the timeout to finish. It serves HTTPS with the TLS configuration if
+// it's not nil, see TLSConfig. The requests and the default list are recorded
+// in the registry if it's not nil. Returns the error serving or shutting
+// down, nil if every request finished.
+func RunServer(ctx context.Context, tokens auth.Registry, shares auth.Shares, addr string, config *tls.Config, store store.Store, lists store.Lists, registry *metrics.Registry, shutdown Shutdown, logger *slog.Logger) error {
+
+	h := NewHandler(tokens, shares, store, lists, logger).(*handler)
+
+	var handler http.Handler = h
+	if registry != nil {
+		handler = Instrument(h, registry)
+		RegisterDocument(registry, store, logger)
 	}
 
 	server := &http.Server{
-		Addr:    addr,
-		Handler: h,
+		Addr:      addr,
+		Handler:   handler,
+		TLSConfig: config,
 	}
+	return h.serve(ctx, server, shutdown, logger)
+}
 
+// serve runs the server until the context is done, then shuts it down.
+func (h *handler) serve(ctx context.Context, server *http.Server, shutdown Shutdown, logger *slog.Logger) error {
+	served := make(chan error, 1)
 	go func() {
-		if err := server.ListenAndServe(); err != nil {
-			h.logger.Fatalf("Server shutdown: %s", err.Error())
+		if server.TLSConfig != nil {
+			// The certificate is in the configuration
+			served <- server.ListenAndServeTLS("", "")
+		} else {
+			served <- server.ListenAndServe()
 		}
 	}()
 
-	return server
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	logger.Info("Shutting down", "drain", shutdown.Drain, "timeout", shutdown.Timeout)
+	h.health.drain()
+	time.Sleep(shutdown.Drain)
+	h.hub.close()
+
+	stop, cancel := context.WithTimeout(context.Background(), shutdown.Timeout)
+	defer cancel()
+
+	if err := server.Shutdown(stop); err != nil {
+		server.Close()
+		return fmt.Errorf("requests still in progress: %w", err)
+	}
+	if err := <-served; err != http.ErrServerClosed {
+		return err
+	}
+	return nil
 }
 
 // ServeHTTP is the main handler method.
 func (h *handler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
-	h.logger.Printf("Request %s: %s, Content Length %d, Token %s", req.Method, req.URL.Path, req.ContentLength, req.Header.Get("Token"))
+	id := requestID(req)
+	resp.Header().Set(RequestIDHeader, id)
+
+	// Everything logged serving the request, the stores too, has its ID
+	logger := h.logger.With("request_id", id)
+	h = &handler{
+		tokens: h.tokens,
+		shares: h.shares,
+		logger: logger,
+		store:  store.WithLogger(h.store, logger),
+		lists:  store.ListsWithLogger(h.lists, logger),
+		hub:    h.hub,
+		health: h.health,
+	}
+
+	// The probes are frequent and don't need a token
+	if (req.Method == "GET" || req.Method == "HEAD") && (req.URL.Path == "/healthz" || req.URL.Path == "/readyz") {
+		if req.URL.Path == "/healthz" {
+			h.healthz(resp, req)
+		} else {
+			h.readyz(resp, req)
+		}
+		return
+	}
+
+	// The token is never logged
+	h.logger.Info("Request", "method", req.Method, "path", req.URL.Path, "length", req.ContentLength)
 	defer req.Body.Close()
 
 	if req.Method == "GET" && req.URL.Path == "/index.html" {
 		h.getView(resp, req)
-		h.logger.Printf("View served")
+		h.logger.Info("View served")
 		return
 	}
 
-	if err := h.auth(req); err != nil {
-		h.logger.Printf("Unauthorized request")
+	if req.Header.Get("Token") == "" && req.URL.Query().Get("share") != "" {
+		h.serveShared(resp, req)
+		h.logger.Info("Request served")
+		return
+	}
+
+	token, err := h.auth(req)
+	if err != nil {
+		h.logger.Info("Unauthorized request")
 		resp.WriteHeader(401)
 		if _, err := resp.Write([]byte("Unauthorized request\n")); err != nil {
-			h.logger.Fatalf("Unable to write the response: %s", err.Error())
-			resp.WriteHeader(500)
+			h.logger.Error("Unable to write the response", "error", err)
 			return
 		}
 		return
 	}
 
+	h.logger.Info("Authenticated", "token", token.Name)
+	req = req.WithContext(context.WithValue(req.Context(), tokenKey{}, token))
+
+	if req.URL.Path == "/lists" || strings.HasPrefix(req.URL.Path, "/lists/") {
+		h.serveLists(resp, req)
+	} else if h.authorize(resp, req, "", req.URL.Path) {
+		h.serveFile(resp, req, h.store, req.URL.Path)
+	}
+
+	h.logger.Info("Request served")
+}
+
+// serveFile serves the requests to a file. The path is relative to the file.
+func (h *handler) serveFile(resp http.ResponseWriter, req *http.Request, s store.Store, path string) {
+	s = h.watch(req, s, path)
+
+	if strings.HasPrefix(path, "/events") {
+		h.events(resp, req, s, path)
+		return
+	}
+
+	if strings.HasPrefix(path, "/share") {
+		h.share(resp, req, s, path)
+		return
+	}
+
+	if strings.HasPrefix(path, "/history") {
+		h.history(resp, req, s, path)
+		return
+	}
+
+	if strings.HasPrefix(path, "/render") {
+		h.getRendered(resp, req, s, path)
+		return
+	}
+
+	if strings.HasPrefix(path, "/tasks") {
+		h.tasks(resp, req, s, path)
+		return
+	}
+
 	switch req.Method {
 	case "GET":
-		h.get(resp, req)
+		h.get(resp, req, s, path)
 	case "HEAD":
-		h.head(resp, req)
+		h.head(resp, req, s, path)
 	case "PUT":
-		h.put(resp, req)
+		h.put(resp, req, s, path)
 	default:
-		h.logger.Printf("Invalid request, method not recognized")
+		h.logger.Info("Invalid request, method not recognized")
 		resp.WriteHeader(404)
 	}
+}
+
+// auth authenticates the request using the provided token, or the client
+// certificate with the name of the token
+func (h *handler) auth(req *http.Request) (*auth.Token, error) {
+	value := req.Header.Get("Token")
+	if value == "" {
+		// Only certificates signed by the client CA are verified
+		if req.TLS != nil && len(req.TLS.VerifiedChains) > 0 {
+			token, err := h.tokens.Named(req.TLS.VerifiedChains[0][0].Subject.CommonName)
+			if err != nil {
+				return nil, ErrInvalidAuth
+			}
+			return token, nil
+		}
+		return nil, ErrNoAuthProvided
+	}
 
-	h.logger.Printf("Request served")
+	token, err := h.tokens.Lookup(value)
+	if err != nil {
+		return nil, ErrInvalidAuth
+	}
+
+	return token, nil
 }
 
-// auth authenticates the request using the provided token
-func (h *handler) auth(req *http.Request) error {
-	token := req.Header.Get("Token")
-	if token == "" {
-		return ErrNoAuthProvided
+// authorize checks the authenticated token can make the request to the path
+// of the list, empty for the default one. Reading and sharing need the read
+// scope, the rest of the methods the write scope and forced puts the force
+// scope too. Responds 403 and returns false if it can't.
+func (h *handler) authorize(resp http.ResponseWriter, req *http.Request, list, path string) bool {
+	scopes := []auth.Scope{auth.Write}
+	switch {
+	case req.Method == "GET" || req.Method == "HEAD" || isShare(req, path):
+		scopes = []auth.Scope{auth.Read}
+	case req.Header.Get("Force") != "" && req.Header.Get("Force") != "false":
+		scopes = append(scopes, auth.Force)
 	}
-	if token != h.authToken {
-		return ErrInvalidAuth
+
+	token := tokenOf(req)
+	for _, scope := range scopes {
+		if !token.Allows(list, scope) {
+			h.logger.Info("Token without scope", "token", token.Name, "scope", scope)
+			resp.WriteHeader(403)
+			return false
+		}
 	}
+	return true
+}
 
-	return nil
+// tokenOf returns the authenticated token of the request.
+func tokenOf(req *http.Request) *auth.Token {
+	return req.Context().Value(tokenKey{}).(*auth.Token)
 }
 
 // head retrieves the information about the file.
-func (h *handler) head(resp http.ResponseWriter, req *http.Request) {
-	if req.URL.Path != "" && req.URL.Path != "/" {
-		h.logger.Printf("Invalid path")
+func (h *handler) head(resp http.ResponseWriter, req *http.Request, s store.Store, path string) {
+	if path != "" && path != "/" {
+		h.logger.Info("Invalid path")
 		resp.WriteHeader(404)
 		return
 	}
 
-	version, err := h.store.GetCurrentVersion()
+	revision, err := s.GetCurrentVersion()
 	if err != nil {
-		h.logger.Printf("Error getting current version")
+		if err == store.ErrNotFound {
+			h.logger.Info("File not found")
+			resp.WriteHeader(404)
+			return
+		}
+		h.logger.Error("Error getting current version", "error", err)
 		resp.WriteHeader(500)
 		return
 	}
 
-	resp.Header().Add("Last-Modified", version.Format(time.RFC1123))
-	resp.WriteHeader(200)
+	status, err := preconditions(req, revision)
+	if err != nil {
+		h.logger.Info("Unrecognized conditional header")
+		resp.WriteHeader(400)
+		return
+	}
+
+	if status == 0 && req.Header.Get("If-None-Match") == "" {
+		if date, err := time.Parse(time.RFC1123, req.Header.Get("If-Modified-Since")); err == nil && notModifiedSince(revision, date) {
+			status = 304
+		}
+	}
+
+	if status == 0 {
+		status = 200
+	}
+
+	setRevision(resp, revision)
+	resp.WriteHeader(status)
 }
 
-// get returns the file.
-func (h *handler) get(resp http.ResponseWriter, req *http.Request) {
-	switch req.URL.Path {
+// get returns the file. Clients with the stored revision can wait for a new
+// one with the wait parameter, in seconds or as a duration like 30s, instead
+// of getting 304 right away.
+func (h *handler) get(resp http.ResponseWriter, req *http.Request, s store.Store, path string) {
+	switch path {
 	case "":
 		fallthrough
 	case "/":
-		var version time.Time
-		date := req.Header.Get("If-Modified-Since")
-		if date != "" {
-			var err error
-			version, err = time.Parse(time.RFC1123, date)
+		wait, err := waitDuration(req)
+		if err != nil {
+			h.logger.Info("Invalid wait duration")
+			resp.WriteHeader(400)
+			return
+		}
+
+		if hasPreconditions(req) || req.Header.Get("If-Modified-Since") != "" {
+			current, err := s.GetCurrentVersion()
 			if err != nil {
-				h.logger.Printf("Unrecognized version date")
+				if err == store.ErrNotFound {
+					h.logger.Info("File not found")
+					resp.WriteHeader(404)
+					return
+				}
+				h.logger.Error("Error getting current version", "error", err)
+				resp.WriteHeader(500)
+				return
+			}
+
+			status, err := preconditions(req, current)
+			if err != nil {
+				h.logger.Info("Unrecognized conditional header")
 				resp.WriteHeader(400)
 				return
 			}
+
+			// Clients using dates as versions
+			date := req.Header.Get("If-Modified-Since")
+			if status == 0 && date != "" && req.Header.Get("If-None-Match") == "" {
+				version, err := time.Parse(time.RFC1123, date)
+				if err != nil {
+					h.logger.Info("Unrecognized version date")
+					resp.WriteHeader(400)
+					return
+				}
+
+				if current.Modified.Equal(version) {
+					h.logger.Info("The requested version is the same")
+					status = 304
+				} else if current.Modified.Before(version) {
+					h.logger.Info("The requested version is newer than the stored one")
+					resp.WriteHeader(409)
+					return
+				}
+			}
+
+			if status == 304 && wait > 0 && h.waitChange(req, s, path, current, wait) {
+				h.logger.Info("New revision stored while waiting")
+				status = 0
+			}
+
+			if status != 0 {
+				h.logger.Info("Precondition evaluated", "status", status)
+				setRevision(resp, current)
+				resp.WriteHeader(status)
+				return
+			}
 		}
 
-		req.Header.Add("Content-Type", "text/plain; charset=utf-8")
-		version, err := h.store.Get(version, resp)
+		buff := &bytes.Buffer{}
+		revision, err := s.Get(store.Revision{}, buff)
 		if err != nil {
-			if err == store.ErrNotModified {
-				h.logger.Printf("The requested version is the same")
-				resp.WriteHeader(304)
-				return
-			} else if err == store.ErrVersionConflict {
-				h.logger.Printf("The requested version is newer than the stored one")
-				resp.WriteHeader(409)
+			if err == store.ErrNotFound {
+				h.logger.Info("File not found")
+				resp.WriteHeader(404)
 				return
 			}
-			h.logger.Printf("Error getting file")
+			h.logger.Error("Error getting file", "error", err)
 			resp.WriteHeader(500)
 			return
 		}
-		resp.Header().Add("Last-Modified", version.Format(time.RFC1123))
+
+		setRevision(resp, revision)
+		resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		resp.WriteHeader(200)
+		if _, err := resp.Write(buff.Bytes()); err != nil {
+			h.logger.Error("Error writing the response", "error", err)
+		}
 	default:
-		h.logger.Printf("Invalid path")
+		h.logger.Info("Invalid path")
 		resp.WriteHeader(404)
 		return
 	}
 
 }
 
-// getView returns the HTML content.
-func (h *handler) getView(resp http.ResponseWriter, req *http.Request) {
-	req.Header.Add("Content-Type", "text/html; charset=utf-8")
-	if _, err := resp.Write([]byte(htmlView)); err != nil {
-		h.logger.Printf("Error getting view")
-		resp.WriteHeader(500)
-	}
-}
-
-func (h *handler) put(resp http.ResponseWriter, req *http.Request) {
-	if req.URL.Path != "" && req.URL.Path != "/" {
-		h.logger.Printf("Invalid path")
+// put stores the file. Clients use the standard conditional headers with
+// the ETag of the revision they edited, older clients send the date of their
+// copy in the Last-Modified header and the newest date wins. With the Merge
+// header the changes are merged with the stored ones, see mergePut.
+func (h *handler) put(resp http.ResponseWriter, req *http.Request, s store.Store, path string) {
+	if path != "" && path != "/" {
+		h.logger.Info("Invalid path")
 		resp.WriteHeader(404)
 		return
 	}
 
-	date := req.Header.Get("Last-Modified")
-	version, err := time.Parse(time.RFC1123, date)
-	if err != nil {
-		h.logger.Printf("Unrecognized version date")
-		resp.WriteHeader(400)
-		return
+	conditional := hasPreconditions(req)
+
+	version := time.Now()
+	if !conditional {
+		var err error
+		version, err = time.Parse(time.RFC1123, req.Header.Get("Last-Modified"))
+		if err != nil {
+			h.logger.Info("Unrecognized version date")
+			resp.WriteHeader(400)
+			return
+		}
 	}
 
 	if req.ContentLength <= 0 {
-		h.logger.Printf("Missing body or content length")
+		h.logger.Info("Missing body or content length")
 		resp.WriteHeader(400)
 		return
 	}
 
 	if req.ContentLength >= SizeLimit {
-		h.logger.Printf("Body too large")
+		h.logger.Info("Body too large")
 		resp.WriteHeader(413)
 		return
 	}
 
 	reader, err := copyBody(req.Body)
 	if err != nil {
-		h.logger.Printf("Error reading body")
+		h.logger.Error("Error reading body", "error", err)
 		resp.WriteHeader(500)
 		return
 	}
 
 	force := req.Header.Get("Force")
+	if req.Header.Get("Merge") == "true" && (force == "" || force == "false") {
+		h.mergePut(resp, req, s, reader)
+		return
+	}
+
+	var revision store.Revision
 	if force == "" || force == "false" {
-		err = h.store.SafePut(version, req.ContentLength, reader)
+		revision, err = s.GetCurrentVersion()
+		if err != nil && err != store.ErrNotFound {
+			h.logger.Error("Error getting current version", "error", err)
+			resp.WriteHeader(500)
+			return
+		}
+
+		if conditional {
+			status, err := preconditions(req, revision)
+			if err != nil {
+				h.logger.Info("Unrecognized conditional header")
+				resp.WriteHeader(400)
+				return
+			}
+
+			if status != 0 {
+				h.logger.Info("Precondition failed", "stored", revision.ETag())
+				resp.WriteHeader(status)
+				return
+			}
+		} else if !revision.IsZero() && !revision.Modified.Before(version) {
+			h.logger.Info("Version conflict, the stored version is newer")
+			resp.WriteHeader(409)
+			return
+		}
+
+		// The store checks the revision didn't change since evaluating
+		revision, err = s.SafePut(revision, version, req.ContentLength, reader)
 	} else {
-		h.logger.Printf("Requested FORCE put")
-		err = h.store.Overwrite(req.ContentLength, reader)
+		h.logger.Info("Requested FORCE put")
+		revision, err = s.Overwrite(req.ContentLength, reader)
 	}
 
 	if err != nil {
 		if err != store.ErrVersionConflict {
-			h.logger.Printf("Error writing file")
+			h.logger.Error("Error writing file", "error", err)
 			resp.WriteHeader(500)
 			return
 		}
-		h.logger.Printf("Version conflict writing file")
-		resp.WriteHeader(409)
+		h.logger.Info("Version conflict writing file")
+		if conditional {
+			resp.WriteHeader(412)
+		} else {
+			resp.WriteHeader(409)
+		}
 		return
 	}
 
-	resp.Header().Add("Last-Modified", version.Format(time.RFC1123))
+	setRevision(resp, revision)
 	resp.WriteHeader(200)
 }
 
+// setRevision adds the revision headers to the response.
+func setRevision(resp http.ResponseWriter, revision store.Revision) {
+	resp.Header().Set("ETag", revision.ETag())
+	resp.Header().Set("Last-Modified", revision.Modified.Format(time.RFC1123))
+}
+
 func copyBody(body io.Reader) (*bytes.Reader, error) {
 	content, err := ioutil.ReadAll(body)
 	if err != nil {
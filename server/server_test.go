@@ -1,70 +1,29 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	"fmt"
-	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/carlosmecha/todo/auth"
 	"github.com/carlosmecha/todo/store"
 	"github.com/carlosmecha/todo/util/testutil"
 )
 
-type mockStore struct {
-	version time.Time
-	file    []byte
-	t       *testing.T
-}
-
-// GetCurrentVersion retrieves the version stored.
-func (m *mockStore) GetCurrentVersion() (time.Time, error) {
-	return m.version, nil
-}
-
-func (m *mockStore) Get(version time.Time, writer io.Writer) (time.Time, error) {
-	m.t.Logf("Requested get %v", version.Format(time.RFC1123))
-	if version.Before(m.version) {
-		_, err := writer.Write(m.file)
-		return m.version, err
-	} else if version.Equal(m.version) {
-		return m.version, store.ErrNotModified
-	}
-	return m.version, store.ErrVersionConflict
-}
-
-func (m *mockStore) SafePut(version time.Time, _ int64, reader io.ReadSeeker) error {
-	if version.After(m.version) {
-		var err error
-		m.file, err = ioutil.ReadAll(reader)
-		m.version = version
-		return err
-	}
-	return store.ErrVersionConflict
-}
-
-func (m *mockStore) Overwrite(_ int64, reader io.ReadSeeker) error {
-	var err error
-	m.file, err = ioutil.ReadAll(reader)
-	m.version = time.Now()
-	return err
-}
-
 func TestGet(t *testing.T) {
 
 	currentVersion := time.Now().Format(time.RFC1123)
 	version, _ := time.Parse(time.RFC1123, currentVersion)
 
-	mock := &mockStore{
-		version: version,
-		file:    []byte("Hola"),
-		t:       t,
-	}
+	mock := store.NewMemoryStore([]byte("Hola"), version, slog.New(slog.NewTextHandler(os.Stdout, nil)))
 
 	cases := []struct {
 		token        string
@@ -107,14 +66,14 @@ func TestGet(t *testing.T) {
 			token:        "test",
 			path:         "/",
 			expectedCode: 304,
-			version:      mock.version.Format(time.RFC1123),
+			version:      version.Format(time.RFC1123),
 		},
 		// Newer date
 		{
 			token:        "test",
 			path:         "/",
 			expectedCode: 409,
-			version:      mock.version.AddDate(1, 0, 0).Format(time.RFC1123),
+			version:      version.AddDate(1, 0, 0).Format(time.RFC1123),
 		},
 		// Invalid date
 		{
@@ -161,12 +120,41 @@ func TestGetView(t *testing.T) {
 
 	cases := []struct {
 		path         string
+		token        string
 		expectedCode int
+		expectedBody string
 	}{
 		// OK
 		{
 			path:         "/index.html",
 			expectedCode: 200,
+			expectedBody: "<form",
+		},
+		// Rendered
+		{
+			path:         "/index.html",
+			token:        "test",
+			expectedCode: 200,
+			expectedBody: "<li class=\"task-list-item\"><input type=\"checkbox\" disabled> Write &lt;b&gt;",
+		},
+		// Invalid Auth
+		{
+			path:         "/index.html",
+			token:        "token",
+			expectedCode: 200,
+			expectedBody: "<form",
+		},
+		// Render
+		{
+			path:         "/render",
+			token:        "test",
+			expectedCode: 200,
+			expectedBody: "<h1>TODO</h1>\n<ul>",
+		},
+		// Render missing Auth
+		{
+			path:         "/render",
+			expectedCode: 401,
 		},
 		// Missing Auth
 		{
@@ -175,7 +163,7 @@ func TestGetView(t *testing.T) {
 		},
 	}
 
-	server, addr := testServer("test", &mockStore{}, t)
+	server, addr := testServer("test", store.NewMemoryStore([]byte("# TODO\n- [ ] Write <b>\n"), time.Now(), slog.New(slog.NewTextHandler(os.Stdout, nil))), t)
 	defer shutdown(server, t)
 
 	client := &http.Client{}
@@ -186,15 +174,32 @@ func TestGetView(t *testing.T) {
 			t.Fatal(err)
 		}
 
+		if c.token != "" {
+			req.Header.Add("Token", c.token)
+		}
+
 		resp, err := client.Do(req)
 		if err != nil {
 			t.Fatal(err)
 		}
 
+		body, err := ioutil.ReadAll(resp.Body)
 		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
 		if resp.StatusCode != c.expectedCode {
 			t.Fatalf("Expected %d status, got %d for case %+v", c.expectedCode, resp.StatusCode, c)
 		}
+
+		if !strings.Contains(string(body), c.expectedBody) {
+			t.Fatalf("Expected body with %s, got %s for case %+v", c.expectedBody, string(body), c)
+		}
+
+		if strings.Contains(string(body), "cdnjs") {
+			t.Fatalf("Expected no external scripts for case %+v", c)
+		}
 	}
 
 }
@@ -204,16 +209,14 @@ func TestHead(t *testing.T) {
 	currentVersion := time.Now().Format(time.RFC1123)
 	version, _ := time.Parse(time.RFC1123, currentVersion)
 
-	mock := &mockStore{
-		version: version,
-		file:    []byte("Hola"),
-		t:       t,
-	}
+	mock := store.NewMemoryStore([]byte("Hola"), version, slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	revision, _ := mock.GetCurrentVersion()
 
 	cases := []struct {
 		token           string
 		path            string
 		expectedVersion string
+		expectedETag    string
 		expectedCode    int
 	}{
 		// OK
@@ -221,7 +224,8 @@ func TestHead(t *testing.T) {
 			token:           "test",
 			path:            "/",
 			expectedCode:    200,
-			expectedVersion: mock.version.Format(time.RFC1123),
+			expectedVersion: version.Format(time.RFC1123),
+			expectedETag:    revision.ETag(),
 		},
 		// Missing Auth
 		{
@@ -271,6 +275,10 @@ func TestHead(t *testing.T) {
 		if c.expectedVersion != "" && resp.Header.Get("Last-Modified") != c.expectedVersion {
 			t.Fatalf("Expected version %s, got %s for case %+v", c.expectedVersion, resp.Header.Get("Last-Modified"), c)
 		}
+
+		if c.expectedETag != "" && resp.Header.Get("ETag") != c.expectedETag {
+			t.Fatalf("Expected ETag %s, got %s for case %+v", c.expectedETag, resp.Header.Get("ETag"), c)
+		}
 	}
 
 }
@@ -281,8 +289,6 @@ func TestPut(t *testing.T) {
 	currentVersion := now.Format(time.RFC1123)
 	version, _ := time.Parse(time.RFC1123, currentVersion)
 
-	mock := &mockStore{t: t}
-
 	cases := []struct {
 		storedBody      []byte
 		storedVersion   time.Time
@@ -290,9 +296,12 @@ func TestPut(t *testing.T) {
 		path            string
 		body            []byte
 		version         string
+		etag            string
+		currentETag     bool
 		force           bool
 		expectedCode    int
 		expectedVersion string
+		expectedNumber  int64
 		expectedBody    []byte
 	}{
 		// OK
@@ -331,6 +340,50 @@ func TestPut(t *testing.T) {
 			expectedCode:  200,
 			expectedBody:  []byte("adios"),
 		},
+		// ETag
+		{
+			storedBody:     []byte("hola"),
+			storedVersion:  version,
+			token:          "test",
+			path:           "/",
+			body:           []byte("adios"),
+			currentETag:    true,
+			expectedCode:   200,
+			expectedNumber: 2,
+			expectedBody:   []byte("adios"),
+		},
+		// ETag creating the file
+		{
+			token:          "test",
+			path:           "/",
+			body:           []byte("adios"),
+			etag:           "\"0-foo\"",
+			expectedCode:   412,
+			expectedNumber: 0,
+		},
+		// ETag conflict
+		{
+			storedBody:     []byte("hola"),
+			storedVersion:  version,
+			token:          "test",
+			path:           "/",
+			body:           []byte("adios"),
+			etag:           "\"1-foo\"",
+			expectedCode:   412,
+			expectedNumber: 1,
+			expectedBody:   []byte("hola"),
+		},
+		// Invalid ETag
+		{
+			storedBody:    []byte("hola"),
+			storedVersion: version,
+			token:         "test",
+			path:          "/",
+			body:          []byte("adios"),
+			etag:          "foo",
+			expectedCode:  400,
+			expectedBody:  []byte("hola"),
+		},
 		// Missing Auth
 		{
 			token:        "",
@@ -377,14 +430,11 @@ func TestPut(t *testing.T) {
 		},
 	}
 
-	server, addr := testServer("test", mock, t)
-	defer shutdown(server, t)
-
 	client := &http.Client{}
 
 	for _, c := range cases {
-		mock.file = c.storedBody
-		mock.version = c.storedVersion
+		mock := store.NewMemoryStore(c.storedBody, c.storedVersion, slog.New(slog.NewTextHandler(os.Stdout, nil)))
+		server, addr := testServer("test", mock, t)
 
 		req, err := http.NewRequest("PUT", addr+c.path, nil)
 		if err != nil {
@@ -399,6 +449,15 @@ func TestPut(t *testing.T) {
 			req.Header.Add("Last-Modified", c.version)
 		}
 
+		if c.etag != "" {
+			req.Header.Add("If-Match", c.etag)
+		}
+
+		if c.currentETag {
+			revision, _ := mock.GetCurrentVersion()
+			req.Header.Add("If-Match", revision.ETag())
+		}
+
 		if c.force {
 			req.Header.Add("Force", "true")
 		}
@@ -414,12 +473,24 @@ func TestPut(t *testing.T) {
 		}
 
 		resp.Body.Close()
+		shutdown(server, t)
 		if resp.StatusCode != c.expectedCode {
 			t.Fatalf("Expected %d status, got %d for case %+v", c.expectedCode, resp.StatusCode, c)
 		}
 
-		if c.expectedVersion != "" && mock.version.Format(time.RFC1123) != c.expectedVersion {
-			t.Fatalf("Expected version %s, got %s for case %+v", c.expectedVersion, mock.version.Format(time.RFC1123), c)
+		buff := &bytes.Buffer{}
+		gotRevision, err := mock.Get(store.Revision{}, buff)
+
+		if c.expectedVersion != "" && gotRevision.Modified.Format(time.RFC1123) != c.expectedVersion {
+			t.Fatalf("Expected version %s, got %s for case %+v", c.expectedVersion, gotRevision.Modified.Format(time.RFC1123), c)
+		}
+
+		if c.expectedNumber != 0 && gotRevision.Number != c.expectedNumber {
+			t.Fatalf("Expected revision %d, got %d for case %+v", c.expectedNumber, gotRevision.Number, c)
+		}
+
+		if resp.StatusCode == 200 && resp.Header.Get("ETag") != gotRevision.ETag() {
+			t.Fatalf("Expected ETag %s, got %s for case %+v", gotRevision.ETag(), resp.Header.Get("ETag"), c)
 		}
 
 		if len(c.expectedBody) > 0 {
@@ -427,8 +498,8 @@ func TestPut(t *testing.T) {
 				t.Fatal(err)
 			}
 
-			if string(mock.file) != string(c.expectedBody) {
-				t.Fatalf("Expected body %s, got %s for case %+v", string(c.expectedBody), string(mock.file), c)
+			if buff.String() != string(c.expectedBody) {
+				t.Fatalf("Expected body %s, got %s for case %+v", string(c.expectedBody), buff.String(), c)
 			}
 		}
 
@@ -436,7 +507,142 @@ func TestPut(t *testing.T) {
 
 }
 
+func TestConditional(t *testing.T) {
+
+	currentVersion := time.Now().Format(time.RFC1123)
+	version, _ := time.Parse(time.RFC1123, currentVersion)
+
+	cases := []struct {
+		method       string
+		headers      map[string]string
+		currentETag  string
+		body         []byte
+		expectedCode int
+	}{
+		// GET not modified
+		{
+			method:       "GET",
+			currentETag:  "If-None-Match",
+			expectedCode: 304,
+		},
+		// GET modified
+		{
+			method:       "GET",
+			headers:      map[string]string{"If-None-Match": "\"1-foo\""},
+			expectedCode: 200,
+		},
+		// GET precondition failed
+		{
+			method:       "GET",
+			headers:      map[string]string{"If-Match": "\"1-foo\""},
+			expectedCode: 412,
+		},
+		// HEAD not modified
+		{
+			method:       "HEAD",
+			currentETag:  "If-None-Match",
+			expectedCode: 304,
+		},
+		// HEAD not modified since
+		{
+			method:       "HEAD",
+			headers:      map[string]string{"If-Modified-Since": currentVersion},
+			expectedCode: 304,
+		},
+		// HEAD precondition failed
+		{
+			method:       "HEAD",
+			headers:      map[string]string{"If-Unmodified-Since": version.AddDate(0, 0, -1).Format(time.RFC1123)},
+			expectedCode: 412,
+		},
+		// PUT create only
+		{
+			method:       "PUT",
+			headers:      map[string]string{"If-None-Match": "*"},
+			body:         []byte("adios"),
+			expectedCode: 412,
+		},
+		// PUT unmodified since
+		{
+			method:       "PUT",
+			headers:      map[string]string{"If-Unmodified-Since": currentVersion},
+			body:         []byte("adios"),
+			expectedCode: 200,
+		},
+		// PUT invalid condition
+		{
+			method:       "PUT",
+			headers:      map[string]string{"If-None-Match": "foo"},
+			body:         []byte("adios"),
+			expectedCode: 400,
+		},
+	}
+
+	client := &http.Client{}
+
+	for _, c := range cases {
+		mock := store.NewMemoryStore([]byte("hola"), version, slog.New(slog.NewTextHandler(os.Stdout, nil)))
+		revision, _ := mock.GetCurrentVersion()
+		server, addr := testServer("test", mock, t)
+
+		req, err := http.NewRequest(c.method, addr+"/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Add("Token", "test")
+
+		for k, v := range c.headers {
+			req.Header.Add(k, v)
+		}
+
+		if c.currentETag != "" {
+			req.Header.Add(c.currentETag, revision.ETag())
+		}
+
+		if len(c.body) > 0 {
+			req.Body = testutil.NewBufferCloser(c.body)
+			req.ContentLength = int64(len(c.body))
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp.Body.Close()
+		shutdown(server, t)
+		if resp.StatusCode != c.expectedCode {
+			t.Fatalf("Expected %d status, got %d for case %+v", c.expectedCode, resp.StatusCode, c)
+		}
+
+		if resp.StatusCode == 304 && resp.Header.Get("ETag") != revision.ETag() {
+			t.Fatalf("Expected ETag %s, got %s for case %+v", revision.ETag(), resp.Header.Get("ETag"), c)
+		}
+	}
+
+}
+
 func testServer(token string, store store.Store, t *testing.T) (*http.Server, string) {
+	return serve(NewHandler(fullAccess(token, t), nil, store, nil, slog.New(slog.NewTextHandler(os.Stdout, nil))).(*handler), t)
+}
+
+// fullAccess returns a registry with the token allowed to do everything.
+func fullAccess(token string, t *testing.T) auth.Registry {
+	full, err := auth.FullAccess("test", token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := auth.NewRegistry(full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokens
+}
+
+// serve starts the handler in a random port.
+func serve(h *handler, t *testing.T) (*http.Server, string) {
 	listener, err := net.Listen("tcp", ":0")
 	if err != nil {
 		panic(err)
@@ -444,12 +650,6 @@ func testServer(token string, store store.Store, t *testing.T) (*http.Server, st
 
 	port := listener.Addr().(*net.TCPAddr).Port
 
-	h := &handler{
-		authToken: token,
-		store:     store,
-		logger:    log.New(os.Stdout, "", log.LstdFlags),
-	}
-
 	server := &http.Server{
 		Handler: h,
 	}
@@ -471,3 +671,174 @@ func shutdown(server *http.Server, t *testing.T) {
 		t.Logf("error shuting down the server: %s", err.Error())
 	}
 }
+
+func TestScopes(t *testing.T) {
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	lists := store.NewMemoryLists(logger)
+	for _, name := range []string{"team", "other"} {
+		if _, err := lists.Create(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// token creates the token with the name as the secret
+	token := func(name string, lists []string, scopes ...auth.Scope) auth.Token {
+		hash, err := auth.Hash(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return auth.Token{Name: name, Hash: hash, Lists: lists, Scopes: scopes}
+	}
+
+	tokens, err := auth.NewRegistry(
+		token("admin", []string{auth.AllLists}, auth.Admin),
+		token("reader", []string{auth.DefaultList}, auth.Read),
+		token("writer", []string{auth.DefaultList, "team"}, auth.Read, auth.Write),
+		token("forcer", []string{auth.DefaultList}, auth.Write, auth.Force),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		token        string
+		method       string
+		path         string
+		etag         string
+		force        bool
+		expectedCode int
+		expectedBody string
+	}{
+		// Read
+		{
+			token:        "reader",
+			method:       "GET",
+			path:         "/",
+			expectedCode: 200,
+		},
+		// Write without scope
+		{
+			token:        "reader",
+			method:       "PUT",
+			path:         "/",
+			etag:         "\"9-foo\"",
+			expectedCode: 403,
+		},
+		// Tasks without scope
+		{
+			token:        "reader",
+			method:       "POST",
+			path:         "/tasks",
+			expectedCode: 403,
+		},
+		// Write, the precondition is evaluated
+		{
+			token:        "writer",
+			method:       "PUT",
+			path:         "/",
+			etag:         "\"9-foo\"",
+			expectedCode: 412,
+		},
+		// Force without scope
+		{
+			token:        "writer",
+			method:       "PUT",
+			path:         "/",
+			force:        true,
+			expectedCode: 403,
+		},
+		// Force
+		{
+			token:        "forcer",
+			method:       "PUT",
+			path:         "/",
+			force:        true,
+			expectedCode: 200,
+		},
+		// Read without scope
+		{
+			token:        "forcer",
+			method:       "GET",
+			path:         "/",
+			expectedCode: 403,
+		},
+		// Only the lists the token can access
+		{
+			token:        "writer",
+			method:       "GET",
+			path:         "/lists",
+			expectedCode: 200,
+			expectedBody: "[\"team\"]",
+		},
+		// List the token can't access
+		{
+			token:        "writer",
+			method:       "GET",
+			path:         "/lists/other",
+			expectedCode: 403,
+		},
+		// Create without scope
+		{
+			token:        "writer",
+			method:       "POST",
+			path:         "/lists/new",
+			expectedCode: 403,
+		},
+		// Delete without scope
+		{
+			token:        "writer",
+			method:       "DELETE",
+			path:         "/lists/team",
+			expectedCode: 403,
+		},
+		// Create
+		{
+			token:        "admin",
+			method:       "POST",
+			path:         "/lists/new",
+			expectedCode: 201,
+		},
+	}
+
+	server, addr := serve(NewHandler(tokens, nil, store.NewMemoryStore([]byte("default"), time.Now(), logger), lists, logger).(*handler), t)
+	defer shutdown(server, t)
+
+	client := &http.Client{}
+
+	for i, c := range cases {
+		req, err := http.NewRequest(c.method, addr+c.path, bytes.NewReader([]byte("hola")))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Add("Token", c.token)
+		if c.etag != "" {
+			req.Header.Add("If-Match", c.etag)
+		}
+		if c.force {
+			req.Header.Add("Force", "true")
+			req.Header.Add("Last-Modified", time.Now().UTC().Format(time.RFC1123))
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != c.expectedCode {
+			t.Fatalf("Expected %d status, got %d in case %d", c.expectedCode, resp.StatusCode, i)
+		}
+
+		if c.expectedBody != "" && string(body) != c.expectedBody {
+			t.Fatalf("Expected body %s, got %s in case %d", c.expectedBody, string(body), i)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/carlosmecha/todo/auth"
+	"github.com/carlosmecha/todo/store"
+)
+
+var (
+	// ShareDuration is the time the share links are valid by default
+	ShareDuration = 24 * time.Hour
+
+	// ShareLimit is the longest time the share links can be valid
+	ShareLimit = 30 * 24 * time.Hour
+)
+
+// shareInfo is the JSON representation of a share link
+type shareInfo struct {
+	Share   string `json:"share"`
+	URL     string `json:"url"`
+	File    string `json:"file"`
+	Expires string `json:"expires"`
+}
+
+// share creates a read-only link to the file for people without a token:
+//
+//	POST /share                creates a link valid for a day
+//	POST /share?expires=72h    creates a link valid for the duration
+//
+// The url is the HTML view and file the Markdown file.
+func (h *handler) share(resp http.ResponseWriter, req *http.Request, s store.Store, path string) {
+	if h.shares == nil || req.Method != "POST" || (path != "/share" && path != "/share/") {
+		h.logger.Info("Invalid path")
+		resp.WriteHeader(404)
+		return
+	}
+
+	duration := ShareDuration
+	if duration > ShareLimit {
+		duration = ShareLimit
+	}
+	if value := req.URL.Query().Get("expires"); value != "" {
+		var err error
+		duration, err = time.ParseDuration(value)
+		if err != nil || duration <= 0 || duration > ShareLimit {
+			h.logger.Info("Invalid share duration")
+			resp.WriteHeader(400)
+			return
+		}
+	}
+
+	if _, err := s.GetCurrentVersion(); err != nil {
+		if err == store.ErrNotFound {
+			h.logger.Info("File not found")
+			resp.WriteHeader(404)
+			return
+		}
+		h.logger.Error("Error getting current version", "error", err)
+		resp.WriteHeader(500)
+		return
+	}
+
+	key := fileKey(req, path)
+	expires := time.Now().Add(duration)
+	share := h.shares.Sign(strings.TrimPrefix(key, "/lists/"), expires)
+
+	h.logger.Info("Shared list", "token", tokenOf(req).Name, "list", key+"/", "expires", expires)
+	h.writeJSON(resp, 201, shareInfo{
+		Share:   share,
+		URL:     "/index.html?share=" + url.QueryEscape(share),
+		File:    key + "/?share=" + url.QueryEscape(share),
+		Expires: expires.UTC().Format(time.RFC1123),
+	})
+}
+
+// serveShared serves the requests with a share link instead of a token. They
+// can only get the file shared, as Markdown or rendered.
+func (h *handler) serveShared(resp http.ResponseWriter, req *http.Request) {
+	list, err := h.verifyShare(req)
+	if err != nil {
+		h.logger.Info("Unauthorized request", "error", err)
+		resp.WriteHeader(401)
+		return
+	}
+
+	path := req.URL.Path
+	// Only the shared list, default or named
+	named := path == "/lists" || strings.HasPrefix(path, "/lists/")
+	prefix := "/lists/" + list
+	if (list == "") == named || (list != "" && path != prefix && !strings.HasPrefix(path, prefix+"/")) {
+		h.logger.Info("Unauthorized request, the share is for another list")
+		resp.WriteHeader(401)
+		return
+	}
+	if list != "" {
+		path = strings.TrimPrefix(path, prefix)
+	}
+
+	if req.Method != "GET" || (path != "" && path != "/" && path != "/render") {
+		h.logger.Info("Invalid request for a share")
+		resp.WriteHeader(404)
+		return
+	}
+
+	s, err := h.open(list)
+	if err != nil {
+		if err == store.ErrNotFound {
+			h.logger.Info("List not found", "list", list)
+			resp.WriteHeader(404)
+			return
+		}
+		h.logger.Error("Error opening list", "list", list, "error", err)
+		resp.WriteHeader(500)
+		return
+	}
+
+	if path == "/render" {
+		h.getRendered(resp, req, s, path)
+	} else {
+		h.get(resp, req, s, path)
+	}
+}
+
+// verifyShare returns the list of the share link in the request.
+func (h *handler) verifyShare(req *http.Request) (string, error) {
+	if h.shares == nil {
+		return "", auth.ErrInvalidShare
+	}
+	return h.shares.Verify(req.URL.Query().Get("share"), time.Now())
+}
+
+// open returns the store of the list, empty for the default one.
+func (h *handler) open(list string) (store.Store, error) {
+	if list == "" {
+		return h.store, nil
+	}
+	if h.lists == nil {
+		return nil, store.ErrNotFound
+	}
+	return h.lists.Open(list)
+}
+
+// isShare returns true if the request creates a share link.
+func isShare(req *http.Request, path string) bool {
+	return req.Method == "POST" && (path == "/share" || path == "/share/")
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carlosmecha/todo/auth"
+	"github.com/carlosmecha/todo/store"
+)
+
+func TestShare(t *testing.T) {
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	lists := store.NewMemoryLists(logger)
+	if _, err := lists.Create("team"); err != nil {
+		t.Fatal(err)
+	}
+
+	shares := auth.NewShares([]byte("key"))
+	mock := store.NewMemoryStore([]byte("# TODO\n- [ ] Share\n"), time.Now(), logger)
+	server, addr := serve(NewHandler(fullAccess("test", t), shares, mock, lists, logger).(*handler), t)
+	defer shutdown(server, t)
+
+	client := &http.Client{}
+
+	// do sends the request and returns the status code and the body
+	do := func(method, path, token string) (int, string) {
+		req, err := http.NewRequest(method, addr+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Add("Token", token)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(body)
+	}
+
+	code, body := do("POST", "/share?expires=1h", "test")
+	if code != 201 {
+		t.Fatalf("Expected status code 201, got %d", code)
+	}
+
+	info := shareInfo{}
+	if err := json.Unmarshal([]byte(body), &info); err != nil {
+		t.Fatal(err)
+	}
+
+	listShare := shares.Sign("team", time.Now().Add(time.Hour))
+	expired := shares.Sign("", time.Now().Add(-time.Hour))
+
+	cases := []struct {
+		method       string
+		path         string
+		token        string
+		expectedCode int
+		expectedBody string
+	}{
+		// File
+		{
+			method:       "GET",
+			path:         info.File,
+			expectedCode: 200,
+			expectedBody: "# TODO\n- [ ] Share\n",
+		},
+		// Rendered
+		{
+			method:       "GET",
+			path:         "/render?share=" + info.Share,
+			expectedCode: 200,
+			expectedBody: "<h1>TODO</h1>",
+		},
+		// View
+		{
+			method:       "GET",
+			path:         info.URL,
+			expectedCode: 200,
+			expectedBody: "<h1>TODO</h1>",
+		},
+		// Read only
+		{
+			method:       "PUT",
+			path:         info.File,
+			expectedCode: 404,
+		},
+		// Only the file
+		{
+			method:       "GET",
+			path:         "/history?share=" + info.Share,
+			expectedCode: 404,
+		},
+		// Another list
+		{
+			method:       "GET",
+			path:         "/lists/team/?share=" + info.Share,
+			expectedCode: 401,
+		},
+		// Named list
+		{
+			method:       "GET",
+			path:         "/lists/team/?share=" + listShare,
+			expectedCode: 200,
+		},
+		// Expired
+		{
+			method:       "GET",
+			path:         "/?share=" + expired,
+			expectedCode: 401,
+		},
+		// Expired view
+		{
+			method:       "GET",
+			path:         "/index.html?share=" + expired,
+			expectedCode: 200,
+			expectedBody: "placeholder=\"Auth\"",
+		},
+		// Invalid
+		{
+			method:       "GET",
+			path:         "/?share=foo",
+			expectedCode: 401,
+		},
+		// Without token
+		{
+			method:       "POST",
+			path:         "/share",
+			expectedCode: 401,
+		},
+		// Too long
+		{
+			method:       "POST",
+			path:         "/share?expires=10000h",
+			token:        "test",
+			expectedCode: 400,
+		},
+		// List
+		{
+			method:       "POST",
+			path:         "/lists/team/share",
+			token:        "test",
+			expectedCode: 201,
+			expectedBody: "/lists/team/?share=",
+		},
+	}
+
+	for i, c := range cases {
+		code, body := do(c.method, c.path, c.token)
+		if code != c.expectedCode {
+			t.Fatalf("Expected status code %d, got %d in case %d", c.expectedCode, code, i)
+		}
+		if !strings.Contains(body, c.expectedBody) {
+			t.Fatalf("Expected body with %q, got %q in case %d", c.expectedBody, body, i)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/carlosmecha/todo/store"
+)
+
+// blockedStore holds the writes until they are released
+type blockedStore struct {
+	store.Store
+	writing chan struct{}
+	release chan struct{}
+}
+
+func (s *blockedStore) SafePut(revision store.Revision, version time.Time, size int64, content io.ReadSeeker) (store.Revision, error) {
+	s.writing <- struct{}{}
+	<-s.release
+	return s.Store.SafePut(revision, version, size, content)
+}
+
+// waitFor checks the condition until it's true, failing after a few seconds.
+func waitFor(condition func() bool, message string, t *testing.T) {
+	for start := time.Now(); !condition(); time.Sleep(5 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal(message)
+		}
+	}
+}
+
+func TestShutdown(t *testing.T) {
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	client := &http.Client{}
+
+	request := func(method, url string, body []byte, header ...string) (*http.Response, error) {
+		req, err := http.NewRequest(method, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("Token", "test")
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Add(header[i], header[i+1])
+		}
+		return client.Do(req)
+	}
+
+	// status returns the status code of the request, 0 if it failed
+	status := func(method, url string, body []byte, header ...string) int {
+		resp, err := request(method, url, body, header...)
+		if err != nil {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	cases := []struct {
+		shutdown     Shutdown
+		expectedPut  int
+		expectedFail bool
+	}{
+		// The write finishes after the drain, before the timeout
+		{
+			shutdown:    Shutdown{Drain: 200 * time.Millisecond, Timeout: 5 * time.Second},
+			expectedPut: 200,
+		},
+		// The write takes longer than the timeout
+		{
+			shutdown:     Shutdown{Timeout: 100 * time.Millisecond},
+			expectedFail: true,
+		},
+	}
+
+	for i, c := range cases {
+		mock := &blockedStore{
+			Store:   store.NewMemoryStore([]byte("hola"), time.Now().Add(-time.Hour), logger),
+			writing: make(chan struct{}, 1),
+			release: make(chan struct{}),
+		}
+		current, _ := mock.GetCurrentVersion()
+
+		listener, err := net.Listen("tcp", ":0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := listener.Addr().(*net.TCPAddr).Port
+		listener.Close()
+		addr := fmt.Sprintf("localhost:%d", port)
+
+		h := NewHandler(fullAccess("test", t), nil, mock, nil, logger).(*handler)
+		ctx, cancel := context.WithCancel(context.Background())
+		served := make(chan error, 1)
+		go func() {
+			served <- h.serve(ctx, &http.Server{Addr: addr, Handler: h}, c.shutdown, logger)
+		}()
+
+		waitFor(func() bool { return status("GET", "http://"+addr+"/healthz", nil) == 200 }, "Expected the server listening", t)
+
+		events, err := request("GET", "http://"+addr+"/events", nil)
+		if err != nil {
+			t.Fatalf("Unexpected error %s in case %d", err.Error(), i)
+		}
+
+		polled := make(chan int, 1)
+		go func() {
+			polled <- status("GET", "http://"+addr+"/?wait=60", nil, "If-None-Match", current.ETag())
+		}()
+
+		put := make(chan int, 1)
+		go func() {
+			put <- status("PUT", "http://"+addr+"/", []byte("adios"), "If-Match", current.ETag())
+		}()
+
+		// The write is in progress, the events and the waiting request subscribed
+		<-mock.writing
+		waitFor(func() bool {
+			h.hub.mutex.Lock()
+			defer h.hub.mutex.Unlock()
+			return len(h.hub.subscribers[""]) == 2
+		}, "Expected the events and the waiting request subscribed", t)
+		cancel()
+
+		if c.shutdown.Drain > 0 {
+			waitFor(func() bool { return h.health.draining.Load() }, "Expected the server draining", t)
+			if code := status("GET", "http://"+addr+"/readyz", nil); code != 503 {
+				t.Fatalf("Expected status code 503 while draining, got %d in case %d", code, i)
+			}
+		}
+
+		// The events and the waiting requests end when shutting down
+		if code := <-polled; code != 304 {
+			t.Fatalf("Expected status code 304 waiting, got %d in case %d", code, i)
+		}
+		if _, err := ioutil.ReadAll(events.Body); err != nil {
+			t.Fatalf("Unexpected error %s reading the events in case %d", err.Error(), i)
+		}
+		events.Body.Close()
+
+		if !c.expectedFail {
+			close(mock.release)
+		}
+
+		err = <-served
+		if c.expectedFail && err == nil {
+			t.Fatalf("Expected error shutting down in case %d", i)
+		}
+		if !c.expectedFail && err != nil {
+			t.Fatalf("Unexpected error %s in case %d", err.Error(), i)
+		}
+
+		if c.expectedFail {
+			close(mock.release)
+		}
+		if code := <-put; code != c.expectedPut {
+			t.Fatalf("Expected status code %d writing, got %d in case %d", c.expectedPut, code, i)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/carlosmecha/todo/store"
+	"github.com/carlosmecha/todo/tasks"
+)
+
+// TaskRetries is the number of times a task change is tried when the file
+// changes in between.
+const TaskRetries = 5
+
+// errInvalidTitle when the task title is empty or has more than one line
+var errInvalidTitle = errors.New("invalid title")
+
+// sectionInfo is the JSON representation of a section
+type sectionInfo struct {
+	Section int        `json:"section"`
+	Title   string     `json:"title"`
+	Level   int        `json:"level"`
+	Tasks   []taskInfo `json:"tasks"`
+}
+
+// taskInfo is the JSON representation of a task
+type taskInfo struct {
+	ID       string     `json:"id"`
+	Title    string     `json:"title"`
+	Done     bool       `json:"done"`
+	Subtasks []taskInfo `json:"subtasks,omitempty"`
+}
+
+// taskRequest is the body of the requests changing tasks. The position is
+// the index among the other tasks, the task goes last when it's missing.
+type taskRequest struct {
+	Title    *string `json:"title"`
+	Done     *bool   `json:"done"`
+	Section  int     `json:"section"`
+	Parent   string  `json:"parent"`
+	Position *int    `json:"position"`
+}
+
+// section returns the section the task goes to, -1 if it goes to the parent
+// task.
+func (r taskRequest) section() int {
+	if r.Parent != "" {
+		return -1
+	}
+	return r.Section
+}
+
+// tasks serves the tasks of the file:
+//
+//	GET /tasks              lists the sections and their tasks
+//	POST /tasks             adds a task
+//	GET /tasks/{id}         retrieves a task
+//	PATCH /tasks/{id}       changes the title or completes a task
+//	POST /tasks/{id}/move   moves a task to another section or task
+//	DELETE /tasks/{id}      deletes a task and its subtasks
+//
+// The changes are stored with the revision they were applied to, and applied
+// again to the new revision if the file changed in between, unless the tasks
+// or the sections they refer to changed or moved, which is a conflict. The
+// standard conditional headers are evaluated against the current revision.
+func (h *handler) tasks(resp http.ResponseWriter, req *http.Request, s store.Store, path string) {
+	path = strings.Trim(strings.TrimPrefix(path, "/tasks"), "/")
+	parts := strings.Split(path, "/")
+
+	switch {
+	case req.Method == "GET" && path == "":
+		h.listTasks(resp, req, s)
+	case req.Method == "POST" && path == "":
+		h.addTask(resp, req, s)
+	case req.Method == "GET" && len(parts) == 1:
+		h.getTask(resp, req, s, parts[0])
+	case req.Method == "PATCH" && len(parts) == 1:
+		h.editTask(resp, req, s, parts[0])
+	case req.Method == "POST" && len(parts) == 2 && parts[1] == "move":
+		h.moveTask(resp, req, s, parts[0])
+	case req.Method == "DELETE" && len(parts) == 1:
+		h.deleteTask(resp, req, s, parts[0])
+	default:
+		h.logger.Info("Invalid path")
+		resp.WriteHeader(404)
+	}
+}
+
+// listTasks returns the sections and their tasks as JSON.
+func (h *handler) listTasks(resp http.ResponseWriter, req *http.Request, s store.Store) {
+	doc, revision, err := h.readTasks(s)
+	if err != nil {
+		h.logger.Error("Error getting file", "error", err)
+		resp.WriteHeader(500)
+		return
+	}
+
+	infos := make([]sectionInfo, 0, len(doc.Sections))
+	for i, section := range doc.Sections {
+		infos = append(infos, sectionInfo{
+			Section: i,
+			Title:   section.Title,
+			Level:   section.Level,
+			Tasks:   newTaskInfos(section.Tasks()),
+		})
+	}
+
+	if !revision.IsZero() {
+		setRevision(resp, revision)
+	}
+	h.writeJSON(resp, 200, infos)
+}
+
+// getTask returns a task as JSON.
+func (h *handler) getTask(resp http.ResponseWriter, req *http.Request, s store.Store, id string) {
+	doc, revision, err := h.readTasks(s)
+	if err != nil {
+		h.logger.Error("Error getting file", "error", err)
+		resp.WriteHeader(500)
+		return
+	}
+
+	task := doc.Task(id)
+	if task == nil {
+		h.logger.Info("Task not found", "task", id)
+		resp.WriteHeader(404)
+		return
+	}
+
+	setRevision(resp, revision)
+	h.writeJSON(resp, 200, newTaskInfo(task))
+}
+
+// addTask adds a task to a section or to another task.
+func (h *handler) addTask(resp http.ResponseWriter, req *http.Request, s store.Store) {
+	body, ok := h.readTaskRequest(resp, req)
+	if !ok {
+		return
+	}
+
+	if body.Title == nil || validTitle(*body.Title) != nil {
+		h.logger.Info("Invalid task title")
+		resp.WriteHeader(400)
+		return
+	}
+	title := strings.TrimSpace(*body.Title)
+
+	h.updateTasks(resp, req, s, 201, body.section(), []string{body.Parent}, func(doc *tasks.Document) (*tasks.Task, error) {
+		return doc.Add(title, body.Section, body.Parent, position(body.Position))
+	})
+}
+
+// editTask changes the title or the state of a task.
+func (h *handler) editTask(resp http.ResponseWriter, req *http.Request, s store.Store, id string) {
+	body, ok := h.readTaskRequest(resp, req)
+	if !ok {
+		return
+	}
+
+	if body.Title != nil && validTitle(*body.Title) != nil {
+		h.logger.Info("Invalid task title")
+		resp.WriteHeader(400)
+		return
+	}
+
+	h.updateTasks(resp, req, s, 200, -1, []string{id}, func(doc *tasks.Document) (*tasks.Task, error) {
+		task := doc.Task(id)
+		if task == nil {
+			return nil, tasks.ErrNotFound
+		}
+		if body.Title != nil {
+			task.Title = strings.TrimSpace(*body.Title)
+		}
+		if body.Done != nil {
+			task.Done = *body.Done
+		}
+		return task, nil
+	})
+}
+
+// moveTask moves a task to another section or task.
+func (h *handler) moveTask(resp http.ResponseWriter, req *http.Request, s store.Store, id string) {
+	body, ok := h.readTaskRequest(resp, req)
+	if !ok {
+		return
+	}
+
+	h.updateTasks(resp, req, s, 200, body.section(), []string{id, body.Parent}, func(doc *tasks.Document) (*tasks.Task, error) {
+		return doc.Move(id, body.Section, body.Parent, position(body.Position))
+	})
+}
+
+// deleteTask deletes a task and its subtasks.
+func (h *handler) deleteTask(resp http.ResponseWriter, req *http.Request, s store.Store, id string) {
+	h.updateTasks(resp, req, s, 204, -1, []string{id}, func(doc *tasks.Document) (*tasks.Task, error) {
+		return doc.Remove(id)
+	})
+}
+
+// updateTasks applies the change to the current revision and stores it,
+// trying again if the file changed in between. The tasks with the IDs the
+// change refers to, and the section if it's not -1, must be the same ones
+// when trying again, or it fails with a conflict. The changed task is
+// returned with the status provided.
+func (h *handler) updateTasks(resp http.ResponseWriter, req *http.Request, s store.Store, status, section int, ids []string, change func(*tasks.Document) (*tasks.Task, error)) {
+	conditional := hasPreconditions(req)
+	targets := make(map[string]tasks.Task)
+	var heading *tasks.Section
+
+	for i := 0; i < TaskRetries; i++ {
+		doc, current, err := h.readTasks(s)
+		if err != nil {
+			h.logger.Error("Error getting file", "error", err)
+			resp.WriteHeader(500)
+			return
+		}
+
+		changed := false
+		for _, id := range ids {
+			task := doc.Task(id)
+			if i == 0 {
+				// Copied, the change modifies the task
+				if task != nil {
+					targets[id] = *task
+				}
+				continue
+			}
+
+			target, found := targets[id]
+			if found != (task != nil) || found && !task.Same(&target) {
+				h.logger.Info("The task changed in between", "task", id)
+				changed = true
+			}
+		}
+
+		if section >= 0 && section < len(doc.Sections) {
+			current := doc.Sections[section]
+			if i == 0 {
+				heading = &tasks.Section{Title: current.Title, Level: current.Level}
+			} else if heading == nil || !current.Same(heading) {
+				h.logger.Info("The section changed in between", "section", section)
+				changed = true
+			}
+		} else if section >= 0 && heading != nil {
+			h.logger.Info("The section was removed in between", "section", section)
+			changed = true
+		}
+
+		if changed {
+			if conditional {
+				resp.WriteHeader(412)
+			} else {
+				resp.WriteHeader(409)
+			}
+			return
+		}
+
+		if conditional {
+			code, err := preconditions(req, current)
+			if err != nil {
+				h.logger.Info("Unrecognized conditional header")
+				resp.WriteHeader(400)
+				return
+			}
+
+			if code != 0 {
+				h.logger.Info("Precondition failed", "stored", current.ETag())
+				resp.WriteHeader(code)
+				return
+			}
+		}
+
+		task, err := change(doc)
+		if err != nil {
+			h.logger.Info("Invalid task change", "error", err)
+			if err == tasks.ErrNotFound {
+				resp.WriteHeader(404)
+			} else {
+				resp.WriteHeader(400)
+			}
+			return
+		}
+
+		content := doc.Bytes()
+		if int64(len(content)) >= SizeLimit {
+			h.logger.Info("File too large")
+			resp.WriteHeader(413)
+			return
+		}
+
+		revision, err := s.SafePut(current, time.Now(), int64(len(content)), bytes.NewReader(content))
+		if err != nil {
+			if err != store.ErrVersionConflict {
+				h.logger.Error("Error writing file", "error", err)
+				resp.WriteHeader(500)
+				return
+			}
+			h.logger.Info("Version conflict changing task, trying again")
+			continue
+		}
+
+		setRevision(resp, revision)
+		if status == 204 {
+			resp.WriteHeader(status)
+			return
+		}
+		h.writeJSON(resp, status, newTaskInfo(task))
+		return
+	}
+
+	h.logger.Warn("Too many version conflicts changing task")
+	resp.WriteHeader(409)
+}
+
+// readTasks parses the current revision. A missing file is an empty one.
+func (h *handler) readTasks(s store.Store) (*tasks.Document, store.Revision, error) {
+	buff := &bytes.Buffer{}
+	revision, err := s.Get(store.Revision{}, buff)
+	if err != nil && err != store.ErrNotFound {
+		return nil, store.Revision{}, err
+	}
+	return tasks.Parse(buff.Bytes()), revision, nil
+}
+
+// readTaskRequest decodes the body of the request.
+func (h *handler) readTaskRequest(resp http.ResponseWriter, req *http.Request) (*taskRequest, bool) {
+	body := &taskRequest{}
+	if err := json.NewDecoder(http.MaxBytesReader(resp, req.Body, SizeLimit)).Decode(body); err != nil {
+		h.logger.Info("Invalid task request", "error", err)
+		resp.WriteHeader(400)
+		return nil, false
+	}
+	return body, true
+}
+
+// writeJSON writes the value as the response.
+func (h *handler) writeJSON(resp http.ResponseWriter, status int, value interface{}) {
+	content, err := json.Marshal(value)
+	if err != nil {
+		h.logger.Error("Error encoding the response", "error", err)
+		resp.WriteHeader(500)
+		return
+	}
+
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(status)
+	if _, err := resp.Write(content); err != nil {
+		h.logger.Error("Error writing the response", "error", err)
+	}
+}
+
+func newTaskInfo(task *tasks.Task) taskInfo {
+	return taskInfo{
+		ID:       task.ID,
+		Title:    task.Title,
+		Done:     task.Done,
+		Subtasks: newTaskInfos(task.Subtasks()),
+	}
+}
+
+func newTaskInfos(list []*tasks.Task) []taskInfo {
+	infos := make([]taskInfo, 0, len(list))
+	for _, task := range list {
+		infos = append(infos, newTaskInfo(task))
+	}
+	return infos
+}
+
+// validTitle checks the title fits in the task line.
+func validTitle(title string) error {
+	if strings.TrimSpace(title) == "" || strings.ContainsAny(title, "\r\n") {
+		return errInvalidTitle
+	}
+	return nil
+}
+
+// position returns the requested position, -1 adds the task last.
+func position(requested *int) int {
+	if requested == nil {
+		return -1
+	}
+	return *requested
+}
@@ -0,0 +1,336 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/carlosmecha/todo/store"
+)
+
+// racingStore changes the file right before the first SafePut, like another
+// client writing at the same time.
+type racingStore struct {
+	store.Store
+	content string
+	raced   bool
+}
+
+func (s *racingStore) SafePut(revision store.Revision, modified time.Time, contentLength int64, reader io.ReadSeeker) (store.Revision, error) {
+	if !s.raced {
+		s.raced = true
+		current, _ := s.Store.GetCurrentVersion()
+		content := []byte(s.content)
+		if _, err := s.Store.SafePut(current, modified, int64(len(content)), bytes.NewReader(content)); err != nil {
+			return store.Revision{}, err
+		}
+	}
+	return s.Store.SafePut(revision, modified, contentLength, reader)
+}
+
+func TestTasks(t *testing.T) {
+
+	mock := store.NewMemoryStore([]byte("# Work\n- [ ] Write\n  - [x] Collect\n\n# Home\n- [ ] Cook\n"), time.Now(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	current, _ := mock.GetCurrentVersion()
+
+	cases := []struct {
+		method       string
+		path         string
+		body         string
+		etag         string
+		expectedCode int
+		expectedBody string
+		expectedFile string
+	}{
+		// List
+		{
+			method:       "GET",
+			path:         "/tasks",
+			expectedCode: 200,
+			expectedBody: `[{"section":0,"title":"","level":0,"tasks":[]},{"section":1,"title":"Work","level":1,"tasks":[{"id":"1.1","title":"Write","done":false,"subtasks":[{"id":"1.1.1","title":"Collect","done":true}]}]},{"section":2,"title":"Home","level":1,"tasks":[{"id":"2.1","title":"Cook","done":false}]}]`,
+		},
+		// Get
+		{
+			method:       "GET",
+			path:         "/tasks/2.1",
+			expectedCode: 200,
+			expectedBody: `{"id":"2.1","title":"Cook","done":false}`,
+		},
+		// Get not found
+		{
+			method:       "GET",
+			path:         "/tasks/2.2",
+			expectedCode: 404,
+		},
+		// Add
+		{
+			method:       "POST",
+			path:         "/tasks",
+			body:         `{"title":"Clean","section":2}`,
+			expectedCode: 201,
+			expectedBody: `{"id":"2.2","title":"Clean","done":false}`,
+			expectedFile: "# Work\n- [ ] Write\n  - [x] Collect\n\n# Home\n- [ ] Cook\n- [ ] Clean\n",
+		},
+		// Add subtask first
+		{
+			method:       "POST",
+			path:         "/tasks",
+			body:         `{"title":"Plan","parent":"1.1","position":0}`,
+			expectedCode: 201,
+			expectedBody: `{"id":"1.1.1","title":"Plan","done":false}`,
+			expectedFile: "# Work\n- [ ] Write\n  - [ ] Plan\n  - [x] Collect\n\n# Home\n- [ ] Cook\n- [ ] Clean\n",
+		},
+		// Add without title
+		{
+			method:       "POST",
+			path:         "/tasks",
+			body:         `{"title":" "}`,
+			expectedCode: 400,
+		},
+		// Add to a missing section
+		{
+			method:       "POST",
+			path:         "/tasks",
+			body:         `{"title":"Clean","section":5}`,
+			expectedCode: 400,
+		},
+		// Invalid body
+		{
+			method:       "POST",
+			path:         "/tasks",
+			body:         `foo`,
+			expectedCode: 400,
+		},
+		// Complete
+		{
+			method:       "PATCH",
+			path:         "/tasks/2.1",
+			body:         `{"done":true}`,
+			expectedCode: 200,
+			expectedBody: `{"id":"2.1","title":"Cook","done":true}`,
+			expectedFile: "# Work\n- [ ] Write\n  - [ ] Plan\n  - [x] Collect\n\n# Home\n- [x] Cook\n- [ ] Clean\n",
+		},
+		// Edit title with an old ETag
+		{
+			method:       "PATCH",
+			path:         "/tasks/2.1",
+			body:         `{"title":"Cook dinner"}`,
+			etag:         current.ETag(),
+			expectedCode: 412,
+		},
+		// Edit title
+		{
+			method:       "PATCH",
+			path:         "/tasks/2.1",
+			body:         `{"title":"Cook dinner"}`,
+			expectedCode: 200,
+			expectedFile: "# Work\n- [ ] Write\n  - [ ] Plan\n  - [x] Collect\n\n# Home\n- [x] Cook dinner\n- [ ] Clean\n",
+		},
+		// Move
+		{
+			method:       "POST",
+			path:         "/tasks/2.2/move",
+			body:         `{"section":1,"position":0}`,
+			expectedCode: 200,
+			expectedBody: `{"id":"1.1","title":"Clean","done":false}`,
+			expectedFile: "# Work\n- [ ] Clean\n- [ ] Write\n  - [ ] Plan\n  - [x] Collect\n\n# Home\n- [x] Cook dinner\n",
+		},
+		// Move inside itself
+		{
+			method:       "POST",
+			path:         "/tasks/1.2/move",
+			body:         `{"parent":"1.2.1"}`,
+			expectedCode: 400,
+		},
+		// Delete
+		{
+			method:       "DELETE",
+			path:         "/tasks/1.2",
+			expectedCode: 204,
+			expectedFile: "# Work\n- [ ] Clean\n\n# Home\n- [x] Cook dinner\n",
+		},
+		// Delete not found
+		{
+			method:       "DELETE",
+			path:         "/tasks/1.2",
+			expectedCode: 404,
+		},
+		// Invalid method
+		{
+			method:       "PUT",
+			path:         "/tasks/1.1",
+			expectedCode: 404,
+		},
+	}
+
+	server, addr := testServer("test", mock, t)
+	defer shutdown(server, t)
+
+	client := &http.Client{}
+
+	for _, c := range cases {
+		req, err := http.NewRequest(c.method, addr+c.path, bytes.NewReader([]byte(c.body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Add("Token", "test")
+		if c.etag != "" {
+			req.Header.Add("If-Match", c.etag)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != c.expectedCode {
+			t.Fatalf("Expected %d status, got %d for case %+v", c.expectedCode, resp.StatusCode, c)
+		}
+
+		if c.expectedBody != "" && string(body) != c.expectedBody {
+			t.Fatalf("Expected body %s, got %s for case %+v", c.expectedBody, string(body), c)
+		}
+
+		if c.expectedFile != "" {
+			buff := &bytes.Buffer{}
+			revision, err := mock.Get(store.Revision{}, buff)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if buff.String() != c.expectedFile {
+				t.Fatalf("Expected file %q, got %q for case %+v", c.expectedFile, buff.String(), c)
+			}
+			if resp.Header.Get("ETag") != revision.ETag() {
+				t.Fatalf("Expected ETag %s, got %s for case %+v", revision.ETag(), resp.Header.Get("ETag"), c)
+			}
+		}
+	}
+}
+
+func TestTasksRetry(t *testing.T) {
+
+	cases := []struct {
+		method       string
+		path         string
+		body         string
+		race         string
+		expectedCode int
+		expectedFile string
+	}{
+		// Added below the task, the change is applied on top of it
+		{
+			method:       "PATCH",
+			path:         "/tasks/1.1",
+			body:         `{"done":true}`,
+			race:         "# Work\n- [ ] Write\n- [ ] Review\n- [ ] Ship\n\n# Home\n",
+			expectedCode: 200,
+			expectedFile: "# Work\n- [x] Write\n- [ ] Review\n- [ ] Ship\n\n# Home\n",
+		},
+		// Added above the task, the ID is another task now
+		{
+			method:       "PATCH",
+			path:         "/tasks/1.1",
+			body:         `{"done":true}`,
+			race:         "# Work\n- [ ] Plan\n- [ ] Write\n",
+			expectedCode: 409,
+			expectedFile: "# Work\n- [ ] Plan\n- [ ] Write\n",
+		},
+		{
+			method:       "DELETE",
+			path:         "/tasks/1.2",
+			race:         "# Work\n- [ ] Write\n\n# Home\n",
+			expectedCode: 409,
+			expectedFile: "# Work\n- [ ] Write\n\n# Home\n",
+		},
+		{
+			method:       "POST",
+			path:         "/tasks/1.1/move",
+			body:         `{"section":2}`,
+			race:         "# Work\n- [ ] Plan\n- [ ] Write\n- [ ] Review\n\n# Home\n",
+			expectedCode: 409,
+			expectedFile: "# Work\n- [ ] Plan\n- [ ] Write\n- [ ] Review\n\n# Home\n",
+		},
+		// The task completed in between
+		{
+			method:       "PATCH",
+			path:         "/tasks/1.1",
+			body:         `{"title":"Write more"}`,
+			race:         "# Work\n- [x] Write\n- [ ] Review\n\n# Home\n",
+			expectedCode: 409,
+			expectedFile: "# Work\n- [x] Write\n- [ ] Review\n\n# Home\n",
+		},
+		// Added a section above the one of the task, the index is another
+		// section now
+		{
+			method:       "POST",
+			path:         "/tasks",
+			body:         `{"title":"Ship","section":2}`,
+			race:         "# Work\n- [ ] Write\n- [ ] Review\n\n# Other\n\n# Home\n",
+			expectedCode: 409,
+			expectedFile: "# Work\n- [ ] Write\n- [ ] Review\n\n# Other\n\n# Home\n",
+		},
+		{
+			method:       "POST",
+			path:         "/tasks/1.1/move",
+			body:         `{"section":2}`,
+			race:         "# Work\n- [ ] Write\n- [ ] Review\n",
+			expectedCode: 409,
+			expectedFile: "# Work\n- [ ] Write\n- [ ] Review\n",
+		},
+		// Added a section below, the change is applied on top of it
+		{
+			method:       "POST",
+			path:         "/tasks",
+			body:         `{"title":"Ship","section":2}`,
+			race:         "# Work\n- [ ] Write\n- [ ] Review\n\n# Home\n\n# Other\n",
+			expectedCode: 201,
+			expectedFile: "# Work\n- [ ] Write\n- [ ] Review\n\n# Home\n- [ ] Ship\n\n# Other\n",
+		},
+	}
+
+	for i, c := range cases {
+		mock := &racingStore{
+			Store:   store.NewMemoryStore([]byte("# Work\n- [ ] Write\n- [ ] Review\n\n# Home\n"), time.Now(), slog.New(slog.NewTextHandler(os.Stdout, nil))),
+			content: c.race,
+		}
+
+		server, addr := testServer("test", mock, t)
+
+		req, err := http.NewRequest(c.method, addr+c.path, bytes.NewReader([]byte(c.body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Add("Token", "test")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		shutdown(server, t)
+
+		if resp.StatusCode != c.expectedCode {
+			t.Fatalf("Expected status code %d, got %d in case %d", c.expectedCode, resp.StatusCode, i)
+		}
+
+		buff := &bytes.Buffer{}
+		if _, err := mock.Get(store.Revision{}, buff); err != nil {
+			t.Fatal(err)
+		}
+		if buff.String() != c.expectedFile {
+			t.Fatalf("Expected %q, got %q in case %d", c.expectedFile, buff.String(), i)
+		}
+	}
+}
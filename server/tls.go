@@ -0,0 +1,179 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrInvalidCA when the client CA file has no certificates
+var ErrInvalidCA = errors.New("no certificates in the client CA file")
+
+// certificate keeps the server certificate, reloading it when the files
+// change so it can be renewed without restarting.
+type certificate struct {
+	certFile string
+	keyFile  string
+	logger   *slog.Logger
+
+	cert     *tls.Certificate
+	modified time.Time
+	mutex    sync.RWMutex
+}
+
+// NewCertificate loads the certificate and key files.
+func NewCertificate(certFile, keyFile string, logger *slog.Logger) (*certificate, error) {
+	c := &certificate{
+		certFile: certFile,
+		keyFile:  keyFile,
+		logger:   logger,
+	}
+
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload loads the files again. The current certificate is kept if they are
+// invalid.
+func (c *certificate) Reload() error {
+	modified, err := c.lastModified()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.cert = &cert
+	c.modified = modified
+	c.logger.Info("Certificate loaded", "file", c.certFile)
+	return nil
+}
+
+// Watch reloads the certificate when the files change, checking them every
+// interval until stop is closed.
+func (c *certificate) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			modified, err := c.lastModified()
+			if err != nil {
+				c.logger.Error("Error checking the certificate", "error", err)
+				continue
+			}
+
+			c.mutex.RLock()
+			changed := modified.After(c.modified)
+			c.mutex.RUnlock()
+
+			if changed {
+				if err := c.Reload(); err != nil {
+					c.logger.Error("Error reloading the certificate", "error", err)
+				}
+			}
+		}
+	}
+}
+
+// GetCertificate returns the certificate for the TLS handshakes.
+func (c *certificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.cert, nil
+}
+
+// lastModified returns the latest modification date of the files.
+func (c *certificate) lastModified() (time.Time, error) {
+	var modified time.Time
+	for _, path := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return modified, err
+		}
+		if info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+	}
+	return modified, nil
+}
+
+// TLSConfig returns the configuration serving the certificate. With a client
+// CA file, clients can authenticate with a certificate signed by it instead
+// of the token: the common name is the name of their token.
+func TLSConfig(cert *certificate, clientCA string) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: cert.GetCertificate,
+	}
+
+	if clientCA != "" {
+		content, err := ioutil.ReadFile(clientCA)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(content) {
+			return nil, ErrInvalidCA
+		}
+
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return config, nil
+}
+
+// RunRedirect starts a server in the address redirecting the requests to the
+// HTTPS port.
+func RunRedirect(addr string, httpsPort int, logger *slog.Logger) *http.Server {
+
+	server := &http.Server{
+		Addr:    addr,
+		Handler: redirect(httpsPort),
+	}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error("Redirect server shutdown", "error", err)
+		}
+	}()
+
+	return server
+}
+
+// redirect returns the handler redirecting to HTTPS, keeping the method.
+func redirect(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		}
+
+		http.Redirect(resp, req, fmt.Sprintf("https://%s%s", host, req.URL.RequestURI()), 308)
+	})
+}
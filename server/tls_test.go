@@ -0,0 +1,292 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carlosmecha/todo/auth"
+	"github.com/carlosmecha/todo/store"
+)
+
+// testCert is a certificate created for the tests
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCert creates a certificate for localhost signed by the parent, or a
+// CA if it's nil.
+func newTestCert(name string, serial int64, parent *testCert, t *testing.T) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// write writes the certificate and key files, modified at the date.
+func (c *testCert) write(certFile, keyFile string, modified time.Time, t *testing.T) {
+	for path, content := range map[string][]byte{certFile: c.certPEM, keyFile: c.keyPEM} {
+		if err := ioutil.WriteFile(path, content, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func (c *testCert) tls(t *testing.T) tls.Certificate {
+	cert, err := tls.X509KeyPair(c.certPEM, c.keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestCertificateReload(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	ca := newTestCert("ca", 1, nil, t)
+	now := time.Now()
+	newTestCert("localhost", 2, ca, t).write(certFile, keyFile, now.Add(-time.Minute), t)
+
+	cert, err := NewCertificate(certFile, keyFile, slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serial := func() int64 {
+		current, err := cert.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := x509.ParseCertificate(current.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed.SerialNumber.Int64()
+	}
+
+	if s := serial(); s != 2 {
+		t.Fatalf("Expected serial 2, got %d", s)
+	}
+
+	// Invalid files keep the current certificate
+	if err := ioutil.WriteFile(keyFile, []byte("foo"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.Reload(); err == nil {
+		t.Fatalf("Expected error reloading an invalid key")
+	}
+	if s := serial(); s != 2 {
+		t.Fatalf("Expected serial 2, got %d", s)
+	}
+
+	// Renewed
+	stop := make(chan struct{})
+	defer close(stop)
+	go cert.Watch(10*time.Millisecond, stop)
+
+	newTestCert("localhost", 3, ca, t).write(certFile, keyFile, now, t)
+	for i := 0; i < 100 && serial() != 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s := serial(); s != 3 {
+		t.Fatalf("Expected serial 3, got %d", s)
+	}
+}
+
+func TestClientCertificates(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert("ca", 1, nil, t)
+	other := newTestCert("other", 2, nil, t)
+
+	certFile, keyFile, caFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), filepath.Join(dir, "ca.pem")
+	newTestCert("localhost", 3, ca, t).write(certFile, keyFile, time.Now(), t)
+	if err := ioutil.WriteFile(caFile, ca.certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	cert, err := NewCertificate(certFile, keyFile, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := TLSConfig(cert, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := auth.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := auth.NewRegistry(auth.Token{Name: "team", Hash: hash, Lists: []string{auth.DefaultList}, Scopes: []auth.Scope{auth.Read}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewUnstartedServer(NewHandler(tokens, nil, store.NewMemoryStore([]byte("hola"), time.Now(), logger), nil, logger))
+	ts.TLS = config
+	ts.StartTLS()
+	defer ts.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	cases := []struct {
+		cert         *testCert
+		token        string
+		expectedCode int
+	}{
+		// Client certificate
+		{
+			cert:         newTestCert("team", 4, ca, t),
+			expectedCode: 200,
+		},
+		// Token
+		{
+			token:        "secret",
+			expectedCode: 200,
+		},
+		// Neither
+		{
+			expectedCode: 401,
+		},
+		// Unknown name
+		{
+			cert:         newTestCert("foo", 5, ca, t),
+			expectedCode: 401,
+		},
+		// Signed by another CA, not sent or rejected
+		{
+			cert:         newTestCert("team", 6, other, t),
+			expectedCode: 401,
+		},
+	}
+
+	for i, c := range cases {
+		clientConfig := &tls.Config{RootCAs: roots}
+		if c.cert != nil {
+			clientConfig.Certificates = []tls.Certificate{c.cert.tls(t)}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+
+		// The certificate is for localhost, httptest serves its own without
+		// a server name
+		req, err := http.NewRequest("GET", strings.Replace(ts.URL, "127.0.0.1", "localhost", 1)+"/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.token != "" {
+			req.Header.Add("Token", c.token)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Unexpected error %s in case %d", err.Error(), i)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != c.expectedCode {
+			t.Fatalf("Expected status code %d, got %d in case %d", c.expectedCode, resp.StatusCode, i)
+		}
+	}
+}
+
+func TestRedirect(t *testing.T) {
+
+	cases := []struct {
+		port             int
+		host             string
+		expectedLocation string
+	}{
+		{8443, "example.com:8080", "https://example.com:8443/lists/team?wait=10"},
+		{443, "example.com:8080", "https://example.com/lists/team?wait=10"},
+		{443, "example.com", "https://example.com/lists/team?wait=10"},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest("PUT", "http://"+c.host+"/lists/team?wait=10", nil)
+		resp := httptest.NewRecorder()
+		redirect(c.port).ServeHTTP(resp, req)
+
+		if resp.Code != 308 {
+			t.Fatalf("Expected status code 308, got %d for %+v", resp.Code, c)
+		}
+		if location := resp.Header().Get("Location"); location != c.expectedLocation {
+			t.Fatalf("Expected location %s, got %s", c.expectedLocation, location)
+		}
+	}
+}
@@ -1,39 +1,183 @@
 package server
 
-const htmlView = `
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+
+	"github.com/carlosmecha/todo/auth"
+	"github.com/carlosmecha/todo/render"
+	"github.com/carlosmecha/todo/store"
+)
+
+// loginView asks for the token and loads the rendered file.
+const loginView = `
 <!DOCTYPE html>
 <html>
     <head>
         <meta http-equiv="Content-Type" content="text/html; charset=utf-8" />
-        <script type="text/javascript" src="https://cdnjs.cloudflare.com/ajax/libs/markdown-it/8.4.0/markdown-it.min.js"></script>
         <title>TODO</title>
     </head>
     <body>
         <form action="#" onsubmit="return get()">
-            <input id="auth" type="text" name="auth" value="Auth"/>
+            <input id="auth" type="password" name="auth" placeholder="Auth"/>
             <input type="submit">
         </form>
         <div id="view" style="width: 600px; padding: 0 10px"></div>
         <script type="text/javascript">
         function get(){
+            load(document.getElementById("auth").value, true);
+            return false;
+        }
+        function load(token, first){
             var input = document.getElementById("auth");
             var view = document.getElementById("view");
-			var xmlhttp = new XMLHttpRequest();
-			var token = input.value
+            var xmlhttp = new XMLHttpRequest();
             xmlhttp.onreadystatechange = function(){
-                if (xmlhttp.readyState == 4 && xmlhttp.status == 200){
-                    view.innerHTML = (window.markdownit()).render(xmlhttp.responseText);
+                if (xmlhttp.readyState != 4) {
+                    return;
+                }
+                if (xmlhttp.status == 200){
+                    // The server sanitizes the rendered file
+                    view.innerHTML = xmlhttp.responseText;
                     document.getElementsByTagName("form")[0].style.visibility = "hidden";
-                } else {
-                    input.value = "Error requesting file";
+                    if (first) {
+                        watch(token);
+                    }
+                } else if (first) {
+                    input.value = "";
+                    input.placeholder = "Error requesting file";
                 }
             }
-            xmlhttp.open("GET", "/", true);
+            xmlhttp.open("GET", "/render", true);
             xmlhttp.setRequestHeader("Token", token);
-			xmlhttp.send();
-			return false
+            xmlhttp.send();
         }
-      </script>
+        // watch reloads the file when it changes. EventSource can't send the
+        // token header, so it reads the event stream.
+        function watch(token){
+            if (!window.fetch || !window.TextDecoder) {
+                return;
+            }
+            var retry = function(){ setTimeout(function(){ watch(token); }, 3000); };
+            fetch("/events", {headers: {"Token": token}}).then(function(resp){
+                var reader = resp.body.getReader();
+                var decoder = new TextDecoder();
+                var buffer = "";
+                var read = function(){
+                    return reader.read().then(function(result){
+                        if (result.done) {
+                            return retry();
+                        }
+                        buffer += decoder.decode(result.value, {stream: true});
+                        var events = buffer.split("\n\n");
+                        buffer = events.pop();
+                        for (var i = 0; i < events.length; i++) {
+                            if (events[i].indexOf("event: revision") >= 0) {
+                                load(token, false);
+                                break;
+                            }
+                        }
+                        return read();
+                    });
+                };
+                return read();
+            }).catch(retry);
+        }
+        </script>
     </body>
 </html>
 `
+
+// documentView is the page with the rendered file.
+var documentView = template.Must(template.New("view").Parse(`
+<!DOCTYPE html>
+<html>
+    <head>
+        <meta http-equiv="Content-Type" content="text/html; charset=utf-8" />
+        <title>TODO</title>
+    </head>
+    <body>
+        <div id="view" style="width: 600px; padding: 0 10px">{{.}}</div>
+    </body>
+</html>
+`))
+
+// getView returns the HTML page. Requests with a token that can read the
+// default list get the rendered file, the ones with a share link the file
+// shared and the rest a form asking for the token.
+func (h *handler) getView(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	s := h.store
+	if req.Header.Get("Token") == "" && req.URL.Query().Get("share") != "" {
+		list, err := h.verifyShare(req)
+		if err == nil {
+			s, err = h.open(list)
+		}
+		if err != nil {
+			h.logger.Info("Invalid share", "error", err)
+			h.writeLogin(resp)
+			return
+		}
+	} else if token, err := h.auth(req); err != nil || !token.Allows("", auth.Read) {
+		h.writeLogin(resp)
+		return
+	}
+
+	buff := &bytes.Buffer{}
+	if _, err := s.Get(store.Revision{}, buff); err != nil && err != store.ErrNotFound {
+		h.logger.Error("Error getting file", "error", err)
+		resp.WriteHeader(500)
+		return
+	}
+
+	page := &bytes.Buffer{}
+	// The rendered file is already sanitized
+	if err := documentView.Execute(page, template.HTML(render.HTML(buff.Bytes()))); err != nil {
+		h.logger.Error("Error getting view", "error", err)
+		resp.WriteHeader(500)
+		return
+	}
+
+	resp.WriteHeader(200)
+	if _, err := resp.Write(page.Bytes()); err != nil {
+		h.logger.Error("Error writing the response", "error", err)
+	}
+}
+
+// writeLogin writes the page asking for the token.
+func (h *handler) writeLogin(resp http.ResponseWriter) {
+	if _, err := resp.Write([]byte(loginView)); err != nil {
+		h.logger.Error("Error writing the response", "error", err)
+	}
+}
+
+// getRendered returns the file rendered as HTML.
+func (h *handler) getRendered(resp http.ResponseWriter, req *http.Request, s store.Store, path string) {
+	if req.Method != "GET" || (path != "/render" && path != "/render/") {
+		h.logger.Info("Invalid path")
+		resp.WriteHeader(404)
+		return
+	}
+
+	buff := &bytes.Buffer{}
+	revision, err := s.Get(store.Revision{}, buff)
+	if err != nil {
+		if err == store.ErrNotFound {
+			h.logger.Info("File not found")
+			resp.WriteHeader(404)
+			return
+		}
+		h.logger.Error("Error getting file", "error", err)
+		resp.WriteHeader(500)
+		return
+	}
+
+	setRevision(resp, revision)
+	resp.Header().Set("Content-Type", "text/html; charset=utf-8")
+	resp.WriteHeader(200)
+	if _, err := resp.Write(render.HTML(buff.Bytes())); err != nil {
+		h.logger.Error("Error writing the response", "error", err)
+	}
+}
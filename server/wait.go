@@ -0,0 +1,99 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/carlosmecha/todo/store"
+)
+
+var (
+	// WaitLimit is the longest a request can wait for a new revision
+	WaitLimit = 5 * time.Minute
+
+	// WaitPoll is the interval to check the stored revision while waiting,
+	// to find the ones written by other servers
+	WaitPoll = 5 * time.Second
+)
+
+// errInvalidWait when the wait parameter is not a positive duration
+var errInvalidWait = errors.New("invalid wait duration")
+
+// waitDuration returns the time the request wants to wait for a new revision,
+// zero if it doesn't wait. It's limited to WaitLimit.
+func waitDuration(req *http.Request) (time.Duration, error) {
+	value := req.URL.Query().Get("wait")
+	if value == "" {
+		return 0, nil
+	}
+
+	var wait time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		wait = time.Duration(seconds) * time.Second
+	} else if wait, err = time.ParseDuration(value); err != nil {
+		return 0, errInvalidWait
+	}
+
+	if wait < 0 {
+		return 0, errInvalidWait
+	}
+	if wait > WaitLimit {
+		wait = WaitLimit
+	}
+	return wait, nil
+}
+
+// waitChange waits until the stored revision is not the current one, the
+// wait time elapses, the client leaves or the server shuts down. Returns true
+// if the file changed.
+func (h *handler) waitChange(req *http.Request, s store.Store, path string, current store.Revision, wait time.Duration) bool {
+	updates, unsubscribe := h.subscribe(req, path)
+	defer unsubscribe()
+
+	timeout := time.NewTimer(wait)
+	defer timeout.Stop()
+
+	poll := time.NewTicker(WaitPoll)
+	defer poll.Stop()
+
+	// changed checks the stored revision, a deleted file also changed
+	changed := func() bool {
+		revision, err := s.GetCurrentVersion()
+		if err != nil {
+			if err == store.ErrNotFound {
+				return true
+			}
+			h.logger.Error("Error getting current version while waiting", "error", err)
+			return false
+		}
+		return !revision.Equal(current)
+	}
+
+	// Written before subscribing
+	if changed() {
+		return true
+	}
+
+	h.logger.Info("Waiting for a new revision", "wait", wait)
+	for {
+		select {
+		case <-req.Context().Done():
+			return false
+		case <-h.hub.closed:
+			h.logger.Info("Shutting down, stopped waiting")
+			return false
+		case <-timeout.C:
+			return false
+		case revision := <-updates:
+			if !revision.Equal(current) {
+				return true
+			}
+		case <-poll.C:
+			if changed() {
+				return true
+			}
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/carlosmecha/todo/store"
+)
+
+func TestWait(t *testing.T) {
+
+	WaitPoll = 20 * time.Millisecond
+
+	modified := time.Now().Add(-time.Hour).Truncate(time.Second)
+	mock := store.NewMemoryStore([]byte("hola"), modified, slog.New(slog.NewTextHandler(os.Stdout, nil)))
+
+	server, addr := testServer("test", mock, t)
+	defer shutdown(server, t)
+
+	client := &http.Client{}
+
+	// put writes through the server, notifying the waiting requests
+	put := func(content string) {
+		current, _ := mock.GetCurrentVersion()
+		req, err := http.NewRequest("PUT", addr+"/", bytes.NewReader([]byte(content)))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		req.Header.Add("Token", "test")
+		req.Header.Add("If-Match", current.ETag())
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		resp.Body.Close()
+	}
+
+	// replica writes in the store directly, like another server
+	replica := func(content string) {
+		current, _ := mock.GetCurrentVersion()
+		if _, err := mock.SafePut(current, time.Now(), int64(len(content)), bytes.NewReader([]byte(content))); err != nil {
+			t.Error(err)
+		}
+	}
+
+	first, _ := mock.GetCurrentVersion()
+
+	cases := []struct {
+		wait         string
+		etag         func() string
+		date         string
+		write        func()
+		expectedCode int
+		expectedBody string
+		expectedWait time.Duration
+	}{
+		// Timeout
+		{
+			wait:         "0.1s",
+			etag:         func() string { return first.ETag() },
+			expectedCode: 304,
+			expectedWait: 100 * time.Millisecond,
+		},
+		// Timeout with dates as versions
+		{
+			wait:         "0.1s",
+			date:         modified.Format(time.RFC1123),
+			expectedCode: 304,
+			expectedWait: 100 * time.Millisecond,
+		},
+		// Written through the server
+		{
+			wait:         "10",
+			etag:         func() string { return first.ETag() },
+			write:        func() { put("adios") },
+			expectedCode: 200,
+			expectedBody: "adios",
+		},
+		// Written by another server
+		{
+			wait: "10s",
+			etag: func() string {
+				current, _ := mock.GetCurrentVersion()
+				return current.ETag()
+			},
+			write:        func() { replica("hello") },
+			expectedCode: 200,
+			expectedBody: "hello",
+		},
+		// Already changed
+		{
+			wait:         "10s",
+			etag:         func() string { return first.ETag() },
+			expectedCode: 200,
+			expectedBody: "hello",
+		},
+		// Invalid wait
+		{
+			wait:         "foo",
+			etag:         func() string { return first.ETag() },
+			expectedCode: 400,
+		},
+		// Negative wait
+		{
+			wait:         "-1",
+			etag:         func() string { return first.ETag() },
+			expectedCode: 400,
+		},
+	}
+
+	for i, c := range cases {
+		req, err := http.NewRequest("GET", addr+"/?wait="+c.wait, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Add("Token", "test")
+		if c.etag != nil {
+			req.Header.Add("If-None-Match", c.etag())
+		}
+		if c.date != "" {
+			req.Header.Add("If-Modified-Since", c.date)
+		}
+
+		if c.write != nil {
+			time.AfterFunc(50*time.Millisecond, c.write)
+		}
+
+		start := time.Now()
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != c.expectedCode {
+			t.Fatalf("Expected status code %d, got %d in case %d", c.expectedCode, resp.StatusCode, i)
+		}
+
+		if string(body) != c.expectedBody {
+			t.Fatalf("Expected body %q, got %q in case %d", c.expectedBody, string(body), i)
+		}
+
+		if elapsed := time.Since(start); elapsed < c.expectedWait {
+			t.Fatalf("Expected to wait %s, got %s in case %d", c.expectedWait, elapsed, i)
+		}
+	}
+}
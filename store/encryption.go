@@ -0,0 +1,140 @@
+package store
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Server-side encryption modes of the objects in S3
+const (
+	// SSENone stores the objects with the default encryption of the bucket
+	SSENone = ""
+
+	// SSES3 encrypts the objects with the keys managed by S3
+	SSES3 = "sse-s3"
+
+	// SSEKMS encrypts the objects with a KMS key
+	SSEKMS = "sse-kms"
+
+	// SSECustomer encrypts the objects with a key provided in every request
+	SSECustomer = "sse-c"
+)
+
+// customerKeySize is the size of the SSE-C keys, AES-256
+const customerKeySize = 32
+
+// ErrInvalidEncryption when the encryption mode or its key are not valid
+var ErrInvalidEncryption = errors.New("invalid encryption")
+
+// Encryption is the server-side encryption of the objects stored in S3,
+// the files and their history.
+type Encryption struct {
+	// Mode is one of SSENone, SSES3, SSEKMS or SSECustomer
+	Mode string
+
+	// KMSKeyID is the KMS key of SSEKMS, the default one of the account if
+	// it's empty
+	KMSKeyID string
+
+	// CustomerKey is the key of SSECustomer, sent in every request
+	CustomerKey []byte
+}
+
+// LoadCustomerKey reads the SSE-C key from a file, as 32 bytes or encoded in
+// base64.
+func LoadCustomerKey(path string) ([]byte, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(content) == customerKeySize {
+		return content, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(content)))
+	if err != nil || len(key) != customerKeySize {
+		return nil, fmt.Errorf("%w: the customer key must have %d bytes", ErrInvalidEncryption, customerKeySize)
+	}
+	return key, nil
+}
+
+// Validate checks the mode and its key.
+func (e Encryption) Validate() error {
+	switch e.Mode {
+	case SSENone, SSES3, SSEKMS, SSECustomer:
+	default:
+		return fmt.Errorf("%w: unknown mode %q", ErrInvalidEncryption, e.Mode)
+	}
+
+	if e.KMSKeyID != "" && e.Mode != SSEKMS {
+		return fmt.Errorf("%w: the KMS key requires %s", ErrInvalidEncryption, SSEKMS)
+	}
+	if e.Mode == SSECustomer && len(e.CustomerKey) != customerKeySize {
+		return fmt.Errorf("%w: the customer key must have %d bytes", ErrInvalidEncryption, customerKeySize)
+	}
+	if e.Mode != SSECustomer && e.CustomerKey != nil {
+		return fmt.Errorf("%w: the customer key requires %s", ErrInvalidEncryption, SSECustomer)
+	}
+	return nil
+}
+
+// Encrypt stores the objects of the store with the server-side encryption,
+// and reads them with the customer key if it's SSE-C.
+func (s *store) Encrypt(encryption Encryption) error {
+	if err := encryption.Validate(); err != nil {
+		return err
+	}
+	s.encryption = encryption
+	return nil
+}
+
+// Encrypt stores the objects of the lists with the server-side encryption,
+// and reads them with the customer key if it's SSE-C.
+func (l *lists) Encrypt(encryption Encryption) error {
+	if err := encryption.Validate(); err != nil {
+		return err
+	}
+	l.encryption = encryption
+	return nil
+}
+
+// put sets the encryption of the object written. The SDK encodes the
+// customer key and adds its MD5.
+func (e Encryption) put(input *s3.PutObjectInput) {
+	switch e.Mode {
+	case SSES3:
+		input.ServerSideEncryption = aws.String(s3.ServerSideEncryptionAes256)
+	case SSEKMS:
+		input.ServerSideEncryption = aws.String(s3.ServerSideEncryptionAwsKms)
+		if e.KMSKeyID != "" {
+			input.SSEKMSKeyId = aws.String(e.KMSKeyID)
+		}
+	case SSECustomer:
+		input.SSECustomerAlgorithm = aws.String(s3.ServerSideEncryptionAes256)
+		input.SSECustomerKey = aws.String(string(e.CustomerKey))
+	}
+}
+
+// get sets the customer key reading the object, S3 decrypts the others
+// without it.
+func (e Encryption) get(input *s3.GetObjectInput) {
+	if e.Mode == SSECustomer {
+		input.SSECustomerAlgorithm = aws.String(s3.ServerSideEncryptionAes256)
+		input.SSECustomerKey = aws.String(string(e.CustomerKey))
+	}
+}
+
+// head sets the customer key reading the object metadata.
+func (e Encryption) head(input *s3.HeadObjectInput) {
+	if e.Mode == SSECustomer {
+		input.SSECustomerAlgorithm = aws.String(s3.ServerSideEncryptionAes256)
+		input.SSECustomerKey = aws.String(string(e.CustomerKey))
+	}
+}
@@ -0,0 +1,223 @@
+package store
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// sseS3mock checks the encryption headers like S3: the objects encrypted
+// with a customer key can only be read with that key, and only them.
+type sseS3mock struct {
+	*s3mock
+	encryption map[string]s3.PutObjectInput
+}
+
+func (m *sseS3mock) check(bucket, key, algorithm, customerKey *string) error {
+	stored, ok := m.encryption[fmt.Sprintf("s3://%s/%s", *bucket, *key)]
+	if !ok {
+		return nil
+	}
+
+	if aws.StringValue(stored.SSECustomerAlgorithm) != aws.StringValue(algorithm) || aws.StringValue(stored.SSECustomerKey) != aws.StringValue(customerKey) {
+		return awserr.NewRequestFailure(awserr.New("BadRequest", "bad request", nil), 400, "")
+	}
+	return nil
+}
+
+func (m *sseS3mock) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	if err := m.check(input.Bucket, input.Key, input.SSECustomerAlgorithm, input.SSECustomerKey); err != nil {
+		return nil, err
+	}
+	return m.s3mock.GetObject(input)
+}
+
+func (m *sseS3mock) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	if err := m.check(input.Bucket, input.Key, input.SSECustomerAlgorithm, input.SSECustomerKey); err != nil {
+		return nil, err
+	}
+	return m.s3mock.HeadObject(input)
+}
+
+func (m *sseS3mock) PutObjectWithContext(ctx aws.Context, input *s3.PutObjectInput, options ...request.Option) (*s3.PutObjectOutput, error) {
+	output, err := m.s3mock.PutObjectWithContext(ctx, input, options...)
+	if err == nil {
+		m.encryption[fmt.Sprintf("s3://%s/%s", *input.Bucket, *input.Key)] = *input
+	}
+	return output, err
+}
+
+func TestEncryption(t *testing.T) {
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	key := bytes.Repeat([]byte("k"), 32)
+	other := bytes.Repeat([]byte("o"), 32)
+
+	cases := []struct {
+		encryption    Encryption
+		read          Encryption
+		expectedSSE   string
+		expectedKMS   string
+		expectedError bool
+	}{
+		// Bucket default
+		{},
+		// SSE-S3
+		{
+			encryption:  Encryption{Mode: SSES3},
+			expectedSSE: "AES256",
+		},
+		// SSE-KMS with the default key
+		{
+			encryption:  Encryption{Mode: SSEKMS},
+			expectedSSE: "aws:kms",
+		},
+		// SSE-KMS
+		{
+			encryption:  Encryption{Mode: SSEKMS, KMSKeyID: "alias/todo"},
+			expectedSSE: "aws:kms",
+			expectedKMS: "alias/todo",
+		},
+		// SSE-C
+		{
+			encryption: Encryption{Mode: SSECustomer, CustomerKey: key},
+			read:       Encryption{Mode: SSECustomer, CustomerKey: key},
+		},
+		// SSE-C with another key
+		{
+			encryption:    Encryption{Mode: SSECustomer, CustomerKey: key},
+			read:          Encryption{Mode: SSECustomer, CustomerKey: other},
+			expectedError: true,
+		},
+		// SSE-C without key
+		{
+			encryption:    Encryption{Mode: SSECustomer, CustomerKey: key},
+			expectedError: true,
+		},
+	}
+
+	for i, c := range cases {
+		mock := &sseS3mock{s3mock: &s3mock{t: t}, encryption: make(map[string]s3.PutObjectInput)}
+		lists := &lists{s3: mock, bucket: aws.String("test"), prefix: "lists/", logger: logger}
+		if err := lists.Encrypt(c.encryption); err != nil {
+			t.Fatalf("Unexpected error %s in case %d", err.Error(), i)
+		}
+
+		s, err := lists.Create("team")
+		if err != nil {
+			t.Fatalf("Unexpected error %s in case %d", err.Error(), i)
+		}
+		current, err := s.GetCurrentVersion()
+		if err != nil {
+			t.Fatalf("Unexpected error %s in case %d", err.Error(), i)
+		}
+		revision, err := s.SafePut(current, time.Now(), 4, bytes.NewReader([]byte("hola")))
+		if err != nil {
+			t.Fatalf("Unexpected error %s in case %d", err.Error(), i)
+		}
+
+		// The file and its history
+		for _, key := range []string{"s3://test/lists/team", fmt.Sprintf("s3://test/lists/team%s%020d-%s", historySuffix, revision.Number, revision.Hash)} {
+			stored, ok := mock.encryption[key]
+			if !ok {
+				t.Fatalf("Expected %s stored in case %d", key, i)
+			}
+			if sse := aws.StringValue(stored.ServerSideEncryption); sse != c.expectedSSE {
+				t.Fatalf("Expected encryption %q, got %q of %s in case %d", c.expectedSSE, sse, key, i)
+			}
+			if kms := aws.StringValue(stored.SSEKMSKeyId); kms != c.expectedKMS {
+				t.Fatalf("Expected KMS key %q, got %q of %s in case %d", c.expectedKMS, kms, key, i)
+			}
+		}
+
+		reader := &store{s3: mock, bucket: aws.String("test"), key: aws.String("lists/team"), logger: logger}
+		if err := reader.Encrypt(c.read); err != nil {
+			t.Fatalf("Unexpected error %s in case %d", err.Error(), i)
+		}
+
+		buff := &bytes.Buffer{}
+		_, getErr := reader.Get(Revision{}, buff)
+		_, headErr := reader.GetCurrentVersion()
+		_, historyErr := reader.GetRevision(revision.Number, &bytes.Buffer{})
+		for _, err := range []error{getErr, headErr, historyErr} {
+			if c.expectedError && err == nil {
+				t.Fatalf("Expected error reading in case %d", i)
+			}
+			if !c.expectedError && err != nil {
+				t.Fatalf("Unexpected error %s in case %d", err.Error(), i)
+			}
+		}
+		if !c.expectedError && buff.String() != "hola" {
+			t.Fatalf("Expected hola, got %s in case %d", buff.String(), i)
+		}
+	}
+}
+
+func TestCustomerKey(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "todo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key := bytes.Repeat([]byte{0xfe}, 32)
+
+	cases := []struct {
+		content       []byte
+		expectedError bool
+	}{
+		// Raw
+		{content: key},
+		// Base64
+		{content: []byte(base64.StdEncoding.EncodeToString(key) + "\n")},
+		// Too short
+		{content: []byte(base64.StdEncoding.EncodeToString(key[:16])), expectedError: true},
+		// Not base64
+		{content: bytes.Repeat([]byte("?"), 44), expectedError: true},
+	}
+
+	for i, c := range cases {
+		path := filepath.Join(dir, fmt.Sprintf("key%d", i))
+		if err := ioutil.WriteFile(path, c.content, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		loaded, err := LoadCustomerKey(path)
+		if c.expectedError {
+			if !errors.Is(err, ErrInvalidEncryption) {
+				t.Fatalf("Expected error %s, got %v in case %d", ErrInvalidEncryption.Error(), err, i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error %s in case %d", err.Error(), i)
+		}
+		if !bytes.Equal(loaded, key) {
+			t.Fatalf("Expected the key %x, got %x in case %d", key, loaded, i)
+		}
+	}
+
+	for i, encryption := range []Encryption{
+		{Mode: "aes"},
+		{Mode: SSES3, KMSKeyID: "alias/todo"},
+		{Mode: SSECustomer},
+		{Mode: SSEKMS, CustomerKey: key},
+	} {
+		if err := encryption.Validate(); !errors.Is(err, ErrInvalidEncryption) {
+			t.Fatalf("Expected error %s, got %v in case %d", ErrInvalidEncryption.Error(), err, i)
+		}
+	}
+}
@@ -0,0 +1,523 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metadataSuffix is appended to the file name to store its metadata
+const metadataSuffix = ".meta"
+
+const (
+	// pendingKey marks the metadata written before the file it describes
+	pendingKey = "Pending"
+
+	// previousPrefix is prepended to the metadata of the revision replaced,
+	// kept with the pending metadata
+	previousPrefix = "Previous-"
+)
+
+// fileStore uses the local filesystem to store the files. The metadata is
+// kept next to the file, in the same format S3 uses for the object.
+type fileStore struct {
+	path   string
+	mutex  *sync.Mutex
+	logger *slog.Logger
+}
+
+// NewFileStore creates a new store using the provided file path
+func NewFileStore(path string, logger *slog.Logger) *fileStore {
+	return &fileStore{
+		path:   path,
+		mutex:  &sync.Mutex{},
+		logger: logger,
+	}
+}
+
+// GetCurrentVersion retrieves the revision stored.
+func (s *fileStore) GetCurrentVersion() (Revision, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.currentRevision()
+}
+
+// Get retrieves the file if the stored revision is different from the
+// provided one. A zero revision always retrieves the file.
+func (s *fileStore) Get(revision Revision, writer io.Writer) (Revision, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	currentRevision, err := s.currentRevision()
+	if err != nil {
+		return Revision{}, err
+	}
+
+	if err := checkRevision(currentRevision, revision, s.logger); err != nil {
+		return Revision{}, err
+	}
+
+	file, err := os.Open(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			s.logger.Debug("File not found")
+			return Revision{}, ErrNotFound
+		}
+		s.logger.Error("Error opening file", "error", err)
+		return Revision{}, err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(writer, file); err != nil {
+		s.logger.Error("Error writing file", "error", err)
+		return Revision{}, err
+	}
+
+	return currentRevision, nil
+}
+
+// SafePut overwrites the file if the stored revision is still the provided one.
+func (s *fileStore) SafePut(revision Revision, modified time.Time, contentLength int64, reader io.ReadSeeker) (Revision, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	currentRevision, err := s.currentRevision()
+	if err != nil {
+		if err != ErrNotFound {
+			return Revision{}, err
+		}
+		currentRevision = Revision{}
+	}
+
+	if !currentRevision.Equal(revision) {
+		s.logger.Info("Version conflict", "stored", currentRevision.ETag())
+		return Revision{}, ErrVersionConflict
+	}
+
+	return s.write(currentRevision, modified, reader)
+}
+
+// Overwrite overwrites the revision stored.
+func (s *fileStore) Overwrite(contentLength int64, reader io.ReadSeeker) (Revision, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	currentRevision, err := s.currentRevision()
+	if err != nil && err != ErrNotFound {
+		return Revision{}, err
+	}
+
+	return s.write(currentRevision, time.Now(), reader)
+}
+
+// History retrieves the stored revisions, newest first.
+func (s *fileStore) History() ([]Revision, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	files, err := ioutil.ReadDir(s.historyDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		s.logger.Error("Error listing the history", "error", err)
+		return nil, err
+	}
+
+	var revisions []Revision
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), metadataSuffix) {
+			continue
+		}
+
+		path := filepath.Join(s.historyDir(), strings.TrimSuffix(file.Name(), metadataSuffix))
+		revision, err := s.readRevision(path)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+
+	sortRevisions(revisions)
+	return revisions, nil
+}
+
+// GetRevision retrieves the content of a stored revision.
+func (s *fileStore) GetRevision(number int64, writer io.Writer) (Revision, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	path := s.historyPath(number)
+	revision, err := s.readRevision(path)
+	if err != nil {
+		return Revision{}, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			s.logger.Debug("File not found")
+			return Revision{}, ErrNotFound
+		}
+		s.logger.Error("Error opening file", "error", err)
+		return Revision{}, err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(writer, file); err != nil {
+		s.logger.Error("Error writing file", "error", err)
+		return Revision{}, err
+	}
+
+	return revision, nil
+}
+
+// currentRevision reads the revision from the metadata file. The caller must
+// hold the lock.
+func (s *fileStore) currentRevision() (Revision, error) {
+	return s.readRevision(s.path)
+}
+
+// readRevision reads the revision of the file in the path from its metadata
+// file. The caller must hold the lock.
+func (s *fileStore) readRevision(path string) (Revision, error) {
+	content, err := ioutil.ReadFile(path + metadataSuffix)
+	if err != nil {
+		if os.IsNotExist(err) {
+			s.logger.Debug("File not found")
+			return Revision{}, ErrNotFound
+		}
+		s.logger.Error("Error getting file info", "error", err)
+		return Revision{}, err
+	}
+
+	metadata := make(map[string]string)
+	if err := json.Unmarshal(content, &metadata); err != nil {
+		s.logger.Error("Invalid metadata file", "error", err)
+		return Revision{}, ErrInvalidVersion
+	}
+
+	revision, err := parseMetadata(metadata)
+	if err != nil {
+		s.logger.Error("Invalid stored version", "metadata", metadata)
+		return Revision{}, err
+	}
+
+	if metadata[pendingKey] != "" {
+		return s.pendingRevision(path, revision, metadata)
+	}
+
+	// Files written before revisions existed don't have a hash
+	if revision.Hash == "" {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			s.logger.Error("Error reading file", "error", err)
+			return Revision{}, err
+		}
+		revision.Hash = hashContent(content)
+	}
+
+	// Neither the date it was stored
+	if revision.Stored.IsZero() {
+		if info, err := os.Stat(path); err == nil {
+			revision.Stored = info.ModTime().Truncate(time.Second)
+		}
+	}
+
+	return revision, nil
+}
+
+// pendingRevision returns the revision of a write interrupted between its
+// metadata and its file: the new one if the file was replaced, the one
+// replaced otherwise. The caller must hold the lock.
+func (s *fileStore) pendingRevision(path string, revision Revision, metadata map[string]string) (Revision, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		s.logger.Error("Error reading file", "error", err)
+		return Revision{}, err
+	}
+	found := err == nil
+
+	if found && hashContent(content) == revision.Hash {
+		return revision, nil
+	}
+
+	previous := make(map[string]string)
+	for k, v := range metadata {
+		if strings.HasPrefix(k, previousPrefix) {
+			previous[strings.TrimPrefix(k, previousPrefix)] = v
+		}
+	}
+
+	// The first write never got to replace the file
+	if len(previous) == 0 || !found {
+		s.logger.Warn("Interrupted write, file not found")
+		return Revision{}, ErrNotFound
+	}
+
+	previousRevision, err := parseMetadata(previous)
+	if err != nil {
+		s.logger.Error("Invalid stored version", "metadata", metadata)
+		return Revision{}, err
+	}
+	if previousRevision.Hash == "" {
+		previousRevision.Hash = hashContent(content)
+	}
+
+	if hashContent(content) != previousRevision.Hash {
+		s.logger.Error("The file doesn't match its metadata", "stored", revision.ETag(), "previous", previousRevision.ETag())
+		return Revision{}, ErrInvalidVersion
+	}
+
+	s.logger.Warn("Interrupted write, using the previous revision", "revision", previousRevision.ETag())
+	return previousRevision, nil
+}
+
+// pendingMetadata returns the metadata of the revision written before its
+// file, with the revision replaced to fall back to if the file isn't.
+func pendingMetadata(revision, previous Revision) map[string]string {
+	metadata := revision.metadata()
+	metadata[pendingKey] = "true"
+	if !previous.IsZero() {
+		for k, v := range previous.metadata() {
+			metadata[previousPrefix+k] = v
+		}
+	}
+	return metadata
+}
+
+// write replaces the file with the revision following the provided one. The
+// metadata is written first as pending and then completed, so an
+// interrupted write can't leave the file with the metadata of another
+// revision. The caller must hold the lock.
+func (s *fileStore) write(revision Revision, modified time.Time, reader io.Reader) (Revision, error) {
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		s.logger.Error("Can't read the file", "error", err)
+		return Revision{}, err
+	}
+
+	newRevision := revision.next(content, modified)
+
+	pending, err := json.Marshal(pendingMetadata(newRevision, revision))
+	if err != nil {
+		return Revision{}, err
+	}
+
+	if err := s.replace(s.path+metadataSuffix, bytes.NewReader(pending)); err != nil {
+		s.logger.Error("Can't store the metadata", "error", err)
+		return Revision{}, err
+	}
+
+	if err := s.replace(s.path, bytes.NewReader(content)); err != nil {
+		s.logger.Error("Can't store the file", "error", err)
+		return Revision{}, err
+	}
+
+	metadata, err := json.Marshal(newRevision.metadata())
+	if err != nil {
+		return Revision{}, err
+	}
+
+	if err := s.replace(s.path+metadataSuffix, bytes.NewReader(metadata)); err != nil {
+		s.logger.Error("Can't store the metadata", "error", err)
+		return Revision{}, err
+	}
+
+	// The file is already stored, a missing copy only affects the history
+	if err := s.writeHistory(newRevision.Number, content, metadata); err != nil {
+		s.logger.Error("Can't store the revision in the history", "revision", newRevision.Number, "error", err)
+	}
+
+	return newRevision, nil
+}
+
+// writeHistory stores a copy of the revision in the history directory. The
+// caller must hold the lock.
+func (s *fileStore) writeHistory(number int64, content, metadata []byte) error {
+	if err := os.MkdirAll(s.historyDir(), 0755); err != nil {
+		return err
+	}
+
+	// The revisions are listed by their metadata, written last
+	path := s.historyPath(number)
+	if err := os.Remove(path + metadataSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := s.replace(path, bytes.NewReader(content)); err != nil {
+		return err
+	}
+
+	return s.replace(path+metadataSuffix, bytes.NewReader(metadata))
+}
+
+// replace writes the content in a temporary file in the same directory and
+// renames it to the destination, so readers never see a partial file.
+func (s *fileStore) replace(path string, reader io.Reader) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(tmp, reader); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return nil
+}
+
+func (s *fileStore) historyDir() string {
+	return s.path + strings.TrimSuffix(historySuffix, "/")
+}
+
+func (s *fileStore) historyPath(number int64) string {
+	return filepath.Join(s.historyDir(), fmt.Sprintf("%020d", number))
+}
+
+// listSuffix is appended to the list name to get its file name
+const listSuffix = ".md"
+
+// fileLists keeps every list as a file in a directory. The stores are kept
+// open so all the requests to a list share its lock.
+type fileLists struct {
+	dir    string
+	stores map[string]*fileStore
+	mutex  *sync.Mutex
+	logger *slog.Logger
+}
+
+// NewFileLists creates the lists stored in the directory
+func NewFileLists(dir string, logger *slog.Logger) *fileLists {
+	return &fileLists{
+		dir:    dir,
+		stores: make(map[string]*fileStore),
+		mutex:  &sync.Mutex{},
+		logger: logger,
+	}
+}
+
+// Names retrieves the names of the lists, sorted.
+func (l *fileLists) Names() ([]string, error) {
+	files, err := ioutil.ReadDir(l.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		l.logger.Error("Error listing the lists", "error", err)
+		return nil, err
+	}
+
+	var names []string
+	for _, file := range files {
+		name := strings.TrimSuffix(file.Name(), listSuffix)
+		if file.Mode().IsRegular() && name != file.Name() && ValidName(name) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// Open retrieves the store of an existing list.
+func (l *fileLists) Open(name string) (Store, error) {
+	s, err := l.store(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.GetCurrentVersion(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Create creates an empty list and retrieves its store.
+func (l *fileLists) Create(name string) (Store, error) {
+	s, err := l.store(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(l.dir, 0755); err != nil {
+		l.logger.Error("Can't create the lists directory", "error", err)
+		return nil, err
+	}
+
+	if err := create(s); err != nil {
+		return nil, err
+	}
+	l.logger.Info("Created list", "list", name)
+	return s, nil
+}
+
+// Delete removes the list and its history.
+func (l *fileLists) Delete(name string) error {
+	s, err := l.store(name)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, err := s.currentRevision(); err != nil {
+		return err
+	}
+
+	// Without the metadata the list is not found anymore
+	for _, path := range []string{s.path + metadataSuffix, s.path, s.historyDir()} {
+		if err := os.RemoveAll(path); err != nil {
+			l.logger.Error("Error deleting", "path", path, "error", err)
+			return err
+		}
+	}
+	l.logger.Info("Deleted list", "list", name)
+	return nil
+}
+
+// store retrieves the store of the list.
+func (l *fileLists) store(name string) (*fileStore, error) {
+	if !ValidName(name) {
+		return nil, ErrInvalidName
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	s, ok := l.stores[name]
+	if !ok {
+		s = NewFileStore(filepath.Join(l.dir, name+listSuffix), l.logger)
+		l.stores[name] = s
+	}
+
+	// The lists may log with another logger than the store
+	c := *s
+	c.logger = l.logger
+	return &c, nil
+}
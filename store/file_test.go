@@ -0,0 +1,388 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testFileStore creates a file store in a temporary directory with the
+// content and metadata provided. A nil metadata skips the metadata file.
+func testFileStore(content []byte, metadata map[string]string, t *testing.T) (*fileStore, func()) {
+	dir, err := ioutil.TempDir("", "todo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "todo.md")
+	if content != nil {
+		if err := ioutil.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if metadata != nil {
+		encoded, err := json.Marshal(metadata)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path+".meta", encoded, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return NewFileStore(path, slog.New(slog.NewTextHandler(os.Stdout, nil))), func() { os.RemoveAll(dir) }
+}
+
+func TestFileGetCurrentVersion(t *testing.T) {
+
+	version, _ := time.Parse(time.RFC1123, time.Now().Format(time.RFC1123))
+	revision := Revision{}.next([]byte("hola"), version)
+	revision.Stored = version.Add(-time.Hour)
+	newRevision := revision.next([]byte("adios"), version)
+
+	cases := []struct {
+		content          []byte
+		metadata         map[string]string
+		expectedRevision Revision
+		expectedError    error
+	}{
+		// Write interrupted before replacing the file
+		{
+			content:          []byte("hola"),
+			metadata:         pendingMetadata(newRevision, revision),
+			expectedRevision: revision,
+		},
+		// Write interrupted after replacing the file
+		{
+			content:          []byte("adios"),
+			metadata:         pendingMetadata(newRevision, revision),
+			expectedRevision: newRevision,
+		},
+		// First write interrupted
+		{
+			metadata:      pendingMetadata(revision, Revision{}),
+			expectedError: ErrNotFound,
+		},
+		// The file doesn't match any revision
+		{
+			content:       []byte("foo"),
+			metadata:      pendingMetadata(newRevision, revision),
+			expectedError: ErrInvalidVersion,
+		},
+		// OK
+		{
+			content:          []byte("hola"),
+			metadata:         revision.metadata(),
+			expectedRevision: revision,
+		},
+		// Legacy version
+		{
+			content:          []byte("hola"),
+			metadata:         map[string]string{Version: version.Format(time.RFC1123)},
+			expectedRevision: Revision{Hash: hashContent([]byte("hola"))},
+		},
+		// Not found
+		{
+			expectedError: ErrNotFound,
+		},
+		// Invalid version
+		{
+			content:       []byte("hola"),
+			metadata:      map[string]string{Version: "foo"},
+			expectedError: ErrInvalidVersion,
+		},
+	}
+
+	for _, c := range cases {
+		s, cleanup := testFileStore(c.content, c.metadata, t)
+		defer cleanup()
+
+		if got, err := s.GetCurrentVersion(); err != nil {
+			if c.expectedError == nil {
+				t.Fatalf("Unexpected error %s", err.Error())
+			} else if c.expectedError != err {
+				t.Fatalf("Expected error %s, got %s", c.expectedError.Error(), err.Error())
+			}
+		} else if !got.Equal(c.expectedRevision) {
+			t.Fatalf("Expected revision %s, got %s", c.expectedRevision.ETag(), got.ETag())
+		} else if !got.Modified.Equal(version) {
+			t.Fatalf("Expected version %s, got %s", version.Format(time.RFC1123), got.Modified.Format(time.RFC1123))
+		} else if stored := c.expectedRevision.Stored; stored.IsZero() && got.Stored.IsZero() || !stored.IsZero() && !got.Stored.Equal(stored) {
+			// Legacy versions are stored when the file was modified
+			t.Fatalf("Expected stored date %s, got %s", stored.Format(time.RFC1123), got.Stored.Format(time.RFC1123))
+		}
+	}
+
+}
+
+func TestFileGet(t *testing.T) {
+
+	version, _ := time.Parse(time.RFC1123, time.Now().Format(time.RFC1123))
+	revision := Revision{}.next([]byte("hola"), version)
+
+	cases := []struct {
+		content          []byte
+		metadata         map[string]string
+		revision         Revision
+		expectedBody     []byte
+		expectedRevision Revision
+		expectedError    error
+	}{
+		// OK
+		{
+			content:          []byte("hola"),
+			metadata:         revision.metadata(),
+			expectedBody:     []byte("hola"),
+			expectedRevision: revision,
+		},
+		// Not found
+		{
+			revision:      revision,
+			expectedError: ErrNotFound,
+		},
+		// Same revision
+		{
+			content:       []byte("hola"),
+			metadata:      revision.metadata(),
+			revision:      revision,
+			expectedError: ErrNotModified,
+		},
+		// Newer revision
+		{
+			content:       []byte("hola"),
+			metadata:      revision.metadata(),
+			revision:      revision.next([]byte("adios"), version),
+			expectedError: ErrVersionConflict,
+		},
+	}
+
+	for _, c := range cases {
+		s, cleanup := testFileStore(c.content, c.metadata, t)
+		defer cleanup()
+
+		buff := &bytes.Buffer{}
+
+		if got, err := s.Get(c.revision, buff); err != nil {
+			if c.expectedError == nil {
+				t.Fatalf("Unexpected error %s", err.Error())
+			} else if c.expectedError != err {
+				t.Fatalf("Expected error %s, got %s", c.expectedError.Error(), err.Error())
+			}
+		} else if !got.Equal(c.expectedRevision) {
+			t.Fatalf("Expected revision %s, got %s", c.expectedRevision.ETag(), got.ETag())
+		} else if string(c.expectedBody) != buff.String() {
+			t.Fatalf("Expected %s, got %s", string(c.expectedBody), buff.String())
+		}
+	}
+
+}
+
+func TestFileSafePut(t *testing.T) {
+
+	version, _ := time.Parse(time.RFC1123, time.Now().Format(time.RFC1123))
+	revision := Revision{}.next([]byte("hola"), version)
+
+	cases := []struct {
+		content          []byte
+		metadata         map[string]string
+		revision         Revision
+		body             []byte
+		expectedRevision Revision
+		expectedBody     []byte
+		expectedError    error
+	}{
+		// OK
+		{
+			content:          []byte("hola"),
+			metadata:         revision.metadata(),
+			revision:         revision,
+			body:             []byte("adios"),
+			expectedRevision: revision.next([]byte("adios"), version),
+			expectedBody:     []byte("adios"),
+		},
+		// Not found
+		{
+			body:             []byte("adios"),
+			expectedRevision: Revision{}.next([]byte("adios"), version),
+			expectedBody:     []byte("adios"),
+		},
+		// Old revision
+		{
+			content:       []byte("hola"),
+			metadata:      revision.metadata(),
+			body:          []byte("adios"),
+			expectedBody:  []byte("hola"),
+			expectedError: ErrVersionConflict,
+		},
+		// Unknown revision
+		{
+			content:       []byte("hola"),
+			metadata:      revision.metadata(),
+			revision:      revision.next([]byte("adios"), version),
+			body:          []byte("adios"),
+			expectedBody:  []byte("hola"),
+			expectedError: ErrVersionConflict,
+		},
+	}
+
+	for _, c := range cases {
+		s, cleanup := testFileStore(c.content, c.metadata, t)
+		defer cleanup()
+
+		if got, err := s.SafePut(c.revision, version, int64(len(c.body)), bytes.NewReader(c.body)); err != nil {
+			if c.expectedError == nil {
+				t.Fatalf("Unexpected error %s", err.Error())
+			} else if c.expectedError != err {
+				t.Fatalf("Expected error %s, got %s", c.expectedError.Error(), err.Error())
+			}
+		} else if !got.Equal(c.expectedRevision) {
+			t.Fatalf("Expected revision %s, got %s", c.expectedRevision.ETag(), got.ETag())
+		} else if stored, err := s.GetCurrentVersion(); err != nil {
+			t.Fatalf("Unexpected error %s", err.Error())
+		} else if !stored.Equal(c.expectedRevision) {
+			t.Fatalf("Expected stored revision %s, got %s", c.expectedRevision.ETag(), stored.ETag())
+		}
+
+		gotBody, err := ioutil.ReadFile(s.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(c.expectedBody) != string(gotBody) {
+			t.Fatalf("Expected %s, got %s", string(c.expectedBody), string(gotBody))
+		}
+	}
+}
+
+func TestFileOverwrite(t *testing.T) {
+
+	version, _ := time.Parse(time.RFC1123, time.Now().Format(time.RFC1123))
+	revision := Revision{}.next([]byte("hola"), version)
+
+	cases := []struct {
+		content        []byte
+		metadata       map[string]string
+		body           []byte
+		expectedNumber int64
+		expectedBody   []byte
+	}{
+		// OK
+		{
+			content:        []byte("hola"),
+			metadata:       revision.metadata(),
+			body:           []byte("adios"),
+			expectedNumber: 2,
+			expectedBody:   []byte("adios"),
+		},
+		// Not found
+		{
+			body:           []byte("adios"),
+			expectedNumber: 1,
+			expectedBody:   []byte("adios"),
+		},
+	}
+
+	for _, c := range cases {
+		s, cleanup := testFileStore(c.content, c.metadata, t)
+		defer cleanup()
+
+		got, err := s.Overwrite(int64(len(c.body)), bytes.NewReader(c.body))
+		if err != nil {
+			t.Fatalf("Unexpected error %s", err.Error())
+		}
+
+		if got.Number != c.expectedNumber {
+			t.Fatalf("Expected revision number %d, got %d", c.expectedNumber, got.Number)
+		}
+
+		gotBody, err := ioutil.ReadFile(s.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(c.expectedBody) != string(gotBody) {
+			t.Fatalf("Expected %s, got %s", string(c.expectedBody), string(gotBody))
+		}
+	}
+}
+
+func TestFileHistory(t *testing.T) {
+
+	version, _ := time.Parse(time.RFC1123, time.Now().Format(time.RFC1123))
+	revision := Revision{}.next([]byte("hola"), version)
+
+	s, cleanup := testFileStore([]byte("hola"), revision.metadata(), t)
+	defer cleanup()
+
+	second, err := s.SafePut(revision, version, 5, bytes.NewReader([]byte("adios")))
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	third, err := s.Overwrite(5, bytes.NewReader([]byte("hello")))
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	history, err := s.History()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	expected := []Revision{third, second}
+	if len(history) != len(expected) {
+		t.Fatalf("Expected %d revisions, got %d", len(expected), len(history))
+	}
+	for i := range expected {
+		if !history[i].Equal(expected[i]) {
+			t.Fatalf("Expected revision %s, got %s", expected[i].ETag(), history[i].ETag())
+		}
+	}
+
+	cases := []struct {
+		number           int64
+		expectedBody     []byte
+		expectedRevision Revision
+		expectedError    error
+	}{
+		// OK
+		{
+			number:           2,
+			expectedBody:     []byte("adios"),
+			expectedRevision: second,
+		},
+		// Current
+		{
+			number:           3,
+			expectedBody:     []byte("hello"),
+			expectedRevision: third,
+		},
+		// Not found
+		{
+			number:        1,
+			expectedError: ErrNotFound,
+		},
+	}
+
+	for _, c := range cases {
+		buff := &bytes.Buffer{}
+
+		if got, err := s.GetRevision(c.number, buff); err != nil {
+			if c.expectedError == nil {
+				t.Fatalf("Unexpected error %s", err.Error())
+			} else if c.expectedError != err {
+				t.Fatalf("Expected error %s, got %s", c.expectedError.Error(), err.Error())
+			}
+		} else if !got.Equal(c.expectedRevision) {
+			t.Fatalf("Expected revision %s, got %s", c.expectedRevision.ETag(), got.ETag())
+		} else if string(c.expectedBody) != buff.String() {
+			t.Fatalf("Expected %s, got %s", string(c.expectedBody), buff.String())
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+var (
+	// ErrExists when creating a list that already exists
+	ErrExists = errors.New("already exists")
+
+	// ErrInvalidName when the list name is not valid
+	ErrInvalidName = errors.New("invalid name")
+
+	validName = regexp.MustCompile("^[a-zA-Z0-9_-]{1,64}$")
+)
+
+// Lists manages the named TODO lists, each one stored in its own Store.
+type Lists interface {
+
+	// Names retrieves the names of the lists, sorted.
+	Names() ([]string, error)
+
+	// Open retrieves the store of an existing list.
+	Open(string) (Store, error)
+
+	// Create creates an empty list and retrieves its store.
+	Create(string) (Store, error)
+
+	// Delete removes the list and its history.
+	Delete(string) error
+}
+
+// ValidName returns true if the name can be used for a list. Names are
+// limited to letters, numbers, dashes and underscores.
+func ValidName(name string) bool {
+	return validName.MatchString(name)
+}
+
+// create stores an empty file as the first revision of the list.
+func create(s Store) error {
+	if _, err := s.SafePut(Revision{}, time.Now(), 0, bytes.NewReader(nil)); err != nil {
+		if err == ErrVersionConflict {
+			return ErrExists
+		}
+		return err
+	}
+	return nil
+}
+
+// lists stores each list in S3, using the list name after the prefix as key.
+type lists struct {
+	s3         s3iface.S3API
+	bucket     *string
+	prefix     string
+	encryption Encryption
+	logger     *slog.Logger
+}
+
+// NewLists creates the lists stored in the bucket under the key prefix
+func NewLists(bucket, prefix, region string, logger *slog.Logger) *lists {
+	return &lists{
+		s3:     newS3Client(region),
+		bucket: aws.String(bucket),
+		prefix: prefix,
+		logger: logger,
+	}
+}
+
+// Names retrieves the names of the lists, sorted.
+func (l *lists) Names() ([]string, error) {
+	var names []string
+	input := &s3.ListObjectsV2Input{
+		Bucket:    l.bucket,
+		Prefix:    aws.String(l.prefix),
+		Delimiter: aws.String("/"),
+	}
+
+	for {
+		resp, err := l.s3.ListObjectsV2(input)
+		if err != nil {
+			l.logger.Error("Error listing the lists", "error", err)
+			return nil, err
+		}
+
+		for _, object := range resp.Contents {
+			// The history of every list is under the same prefix
+			if name := strings.TrimPrefix(*object.Key, l.prefix); ValidName(name) {
+				names = append(names, name)
+			}
+		}
+
+		if resp.IsTruncated == nil || !*resp.IsTruncated {
+			break
+		}
+		input.ContinuationToken = resp.NextContinuationToken
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+// Open retrieves the store of an existing list.
+func (l *lists) Open(name string) (Store, error) {
+	s, err := l.store(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.GetCurrentVersion(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Create creates an empty list and retrieves its store.
+func (l *lists) Create(name string) (Store, error) {
+	s, err := l.store(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := create(s); err != nil {
+		return nil, err
+	}
+	l.logger.Info("Created list", "list", name)
+	return s, nil
+}
+
+// Delete removes the list and its history.
+func (l *lists) Delete(name string) error {
+	s, err := l.store(name)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.GetCurrentVersion(); err != nil {
+		return err
+	}
+
+	if err := s.listHistory(func(object *s3.Object) error { return l.delete(object.Key) }); err != nil {
+		return err
+	}
+
+	if err := l.delete(s.key); err != nil {
+		return err
+	}
+	l.logger.Info("Deleted list", "list", name)
+	return nil
+}
+
+// store creates the store of the list.
+func (l *lists) store(name string) (*store, error) {
+	if !ValidName(name) {
+		return nil, ErrInvalidName
+	}
+
+	return &store{
+		s3:         l.s3,
+		bucket:     l.bucket,
+		key:        aws.String(l.prefix + name),
+		encryption: l.encryption,
+		logger:     l.logger,
+	}, nil
+}
+
+// delete removes the object in the key.
+func (l *lists) delete(key *string) error {
+	if _, err := l.s3.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: l.bucket,
+		Key:    key,
+	}); err != nil {
+		l.logger.Error("Error deleting", "key", *key, "error", err)
+		return err
+	}
+	return nil
+}
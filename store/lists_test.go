@@ -0,0 +1,153 @@
+package store
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// testLists creates, updates, enumerates and deletes lists.
+func testLists(l Lists, t *testing.T) {
+
+	if _, err := l.Create("personal"); err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	s, err := l.Create("team")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	revision, err := s.GetCurrentVersion()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if revision.Number != 1 {
+		t.Fatalf("Expected revision number 1, got %d", revision.Number)
+	}
+
+	if _, err := s.SafePut(revision, time.Now(), 4, bytes.NewReader([]byte("hola"))); err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	names, err := l.Names()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if expected := []string{"personal", "team"}; !reflect.DeepEqual(expected, names) {
+		t.Fatalf("Expected names %v, got %v", expected, names)
+	}
+
+	cases := []struct {
+		name          string
+		expectedBody  []byte
+		expectedError error
+	}{
+		// OK
+		{
+			name:         "team",
+			expectedBody: []byte("hola"),
+		},
+		// Empty
+		{
+			name:         "personal",
+			expectedBody: []byte(""),
+		},
+		// Not found
+		{
+			name:          "sprint",
+			expectedError: ErrNotFound,
+		},
+		// Invalid name
+		{
+			name:          "../team",
+			expectedError: ErrInvalidName,
+		},
+	}
+
+	for _, c := range cases {
+		buff := &bytes.Buffer{}
+
+		if s, err := l.Open(c.name); err != nil {
+			if c.expectedError == nil {
+				t.Fatalf("Unexpected error %s", err.Error())
+			} else if c.expectedError != err {
+				t.Fatalf("Expected error %s, got %s", c.expectedError.Error(), err.Error())
+			}
+		} else if _, err := s.Get(Revision{}, buff); err != nil {
+			t.Fatalf("Unexpected error %s", err.Error())
+		} else if string(c.expectedBody) != buff.String() {
+			t.Fatalf("Expected %s, got %s", string(c.expectedBody), buff.String())
+		}
+	}
+
+	if _, err := l.Create("team"); err != ErrExists {
+		t.Fatalf("Expected error %s, got %v", ErrExists.Error(), err)
+	}
+
+	if err := l.Delete("team"); err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	if err := l.Delete("team"); err != ErrNotFound {
+		t.Fatalf("Expected error %s, got %v", ErrNotFound.Error(), err)
+	}
+
+	if _, err := l.Open("team"); err != ErrNotFound {
+		t.Fatalf("Expected error %s, got %v", ErrNotFound.Error(), err)
+	}
+
+	// A new list with the same name starts from scratch
+	s, err = l.Create("team")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	history, err := s.History()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if len(history) != 1 {
+		t.Fatalf("Expected 1 revision, got %d", len(history))
+	}
+}
+
+func TestLists(t *testing.T) {
+	mock := &s3mock{t: t}
+
+	testLists(&lists{
+		s3:     mock,
+		bucket: aws.String("test"),
+		prefix: "lists/",
+		logger: slog.New(slog.NewTextHandler(os.Stdout, nil)),
+	}, t)
+
+	if _, ok := mock.data["s3://test/lists/team"]; !ok {
+		t.Fatalf("Expected the list in s3://test/lists/team")
+	}
+}
+
+func TestFileLists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "todo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	testLists(NewFileLists(filepath.Join(dir, "lists"), slog.New(slog.NewTextHandler(os.Stdout, nil))), t)
+
+	if _, err := os.Stat(filepath.Join(dir, "lists", "team.md")); err != nil {
+		t.Fatalf("Expected the list in team.md, got %s", err.Error())
+	}
+}
+
+func TestMemoryLists(t *testing.T) {
+	testLists(NewMemoryLists(slog.New(slog.NewTextHandler(os.Stdout, nil))), t)
+}
@@ -0,0 +1,66 @@
+package store
+
+import "log/slog"
+
+// logging is implemented by the stores that can log with another logger
+type logging interface {
+	withLogger(*slog.Logger) Store
+}
+
+// loggingLists is implemented by the lists that can log with another logger
+type loggingLists interface {
+	withLogger(*slog.Logger) Lists
+}
+
+// WithLogger returns a copy of the store logging with the logger, like one
+// with the ID of the request using it. The copy shares the file with the
+// store. Other stores are returned as they are.
+func WithLogger(s Store, logger *slog.Logger) Store {
+	if l, ok := s.(logging); ok {
+		return l.withLogger(logger)
+	}
+	return s
+}
+
+// ListsWithLogger returns a copy of the lists logging with the logger, also
+// the stores they open. Other lists, or nil, are returned as they are.
+func ListsWithLogger(l Lists, logger *slog.Logger) Lists {
+	if ll, ok := l.(loggingLists); ok {
+		return ll.withLogger(logger)
+	}
+	return l
+}
+
+func (s *store) withLogger(logger *slog.Logger) Store {
+	c := *s
+	c.logger = logger
+	return &c
+}
+
+func (l *lists) withLogger(logger *slog.Logger) Lists {
+	c := *l
+	c.logger = logger
+	return &c
+}
+
+func (s *fileStore) withLogger(logger *slog.Logger) Store {
+	c := *s
+	c.logger = logger
+	return &c
+}
+
+func (l *fileLists) withLogger(logger *slog.Logger) Lists {
+	c := *l
+	c.logger = logger
+	return &c
+}
+
+func (s *memoryStore) withLogger(logger *slog.Logger) Store {
+	return &memoryStore{memoryFile: s.memoryFile, logger: logger}
+}
+
+func (l *memoryLists) withLogger(logger *slog.Logger) Lists {
+	c := *l
+	c.logger = logger
+	return &c
+}
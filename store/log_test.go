@@ -0,0 +1,68 @@
+package store
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithLogger(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	output := &bytes.Buffer{}
+	other := slog.New(slog.NewTextHandler(output, &slog.HandlerOptions{Level: slog.LevelDebug})).With("request_id", "abc")
+
+	cases := []Lists{
+		NewMemoryLists(logger),
+		NewFileLists(filepath.Join(dir, "lists"), logger),
+	}
+
+	for i, lists := range cases {
+		output.Reset()
+
+		if _, err := ListsWithLogger(lists, other).Create("team"); err != nil {
+			t.Fatalf("Unexpected error %s in case %d", err.Error(), i)
+		}
+
+		s, err := lists.Open("team")
+		if err != nil {
+			t.Fatalf("Unexpected error %s in case %d", err.Error(), i)
+		}
+
+		// The copy shares the file
+		logged := WithLogger(s, other)
+		if _, err := logged.SafePut(Revision{}, time.Now(), 4, bytes.NewReader([]byte("hola"))); err != ErrVersionConflict {
+			t.Fatalf("Expected error %v, got %v in case %d", ErrVersionConflict, err, i)
+		}
+		current, _ := s.GetCurrentVersion()
+		if _, err := logged.SafePut(current, time.Now(), 4, bytes.NewReader([]byte("hola"))); err != nil {
+			t.Fatalf("Unexpected error %s in case %d", err.Error(), i)
+		}
+
+		buff := &bytes.Buffer{}
+		if _, err := s.Get(Revision{}, buff); err != nil || buff.String() != "hola" {
+			t.Fatalf("Expected hola, got %s and %v in case %d", buff.String(), err, i)
+		}
+
+		for _, expected := range []string{`msg="Created list" request_id=abc list=team`, `msg="Version conflict" request_id=abc`} {
+			if !strings.Contains(output.String(), expected) {
+				t.Fatalf("Expected %s in the log, got %s in case %d", expected, output.String(), i)
+			}
+		}
+	}
+
+	if ListsWithLogger(nil, other) != nil {
+		t.Fatalf("Expected nil lists")
+	}
+}
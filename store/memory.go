@@ -0,0 +1,242 @@
+package store
+
+import (
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryStore keeps the file in memory. It's safe for concurrent use and
+// follows the same semantics as the other stores.
+type memoryStore struct {
+	*memoryFile
+	logger *slog.Logger
+}
+
+// memoryFile is the file shared by the store and its copies with other
+// loggers
+type memoryFile struct {
+	content  []byte
+	revision Revision
+	found    bool
+	history  []memoryRevision
+	mutex    sync.Mutex
+}
+
+// memoryRevision is a revision kept in the history
+type memoryRevision struct {
+	revision Revision
+	content  []byte
+}
+
+// NewMemoryStore creates a new store with the provided content, stored as the
+// first revision with the modification date provided, also used as the date
+// it was stored. A nil content creates an empty store.
+func NewMemoryStore(content []byte, modified time.Time, logger *slog.Logger) *memoryStore {
+	s := &memoryStore{memoryFile: &memoryFile{}, logger: logger}
+	if content != nil {
+		s.content = content
+		s.revision = Revision{}.next(content, modified)
+		s.revision.Stored = s.revision.Modified
+		s.found = true
+		s.history = []memoryRevision{{s.revision, content}}
+	}
+	return s
+}
+
+// GetCurrentVersion retrieves the revision stored.
+func (s *memoryStore) GetCurrentVersion() (Revision, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.found {
+		s.logger.Debug("File not found")
+		return Revision{}, ErrNotFound
+	}
+
+	return s.revision, nil
+}
+
+// Get retrieves the file if the stored revision is different from the
+// provided one. A zero revision always retrieves the file.
+func (s *memoryStore) Get(revision Revision, writer io.Writer) (Revision, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.found {
+		s.logger.Debug("File not found")
+		return Revision{}, ErrNotFound
+	}
+
+	if err := checkRevision(s.revision, revision, s.logger); err != nil {
+		return Revision{}, err
+	}
+
+	if _, err := writer.Write(s.content); err != nil {
+		s.logger.Error("Error writing file", "error", err)
+		return Revision{}, err
+	}
+
+	return s.revision, nil
+}
+
+// SafePut overwrites the file if the stored revision is still the provided one.
+func (s *memoryStore) SafePut(revision Revision, modified time.Time, contentLength int64, reader io.ReadSeeker) (Revision, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.revision.Equal(revision) {
+		s.logger.Info("Version conflict", "stored", s.revision.ETag())
+		return Revision{}, ErrVersionConflict
+	}
+
+	return s.write(modified, reader)
+}
+
+// Overwrite overwrites the revision stored.
+func (s *memoryStore) Overwrite(contentLength int64, reader io.ReadSeeker) (Revision, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.write(time.Now(), reader)
+}
+
+// write replaces the content with a new revision. The caller must hold the
+// lock.
+func (s *memoryStore) write(modified time.Time, reader io.Reader) (Revision, error) {
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		s.logger.Error("Can't store the file", "error", err)
+		return Revision{}, err
+	}
+
+	s.content = content
+	s.revision = s.revision.next(content, modified)
+	s.found = true
+	s.history = append(s.history, memoryRevision{s.revision, content})
+	return s.revision, nil
+}
+
+// History retrieves the stored revisions, newest first.
+func (s *memoryStore) History() ([]Revision, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	revisions := make([]Revision, 0, len(s.history))
+	for _, stored := range s.history {
+		revisions = append(revisions, stored.revision)
+	}
+
+	sortRevisions(revisions)
+	return revisions, nil
+}
+
+// GetRevision retrieves the content of a stored revision.
+func (s *memoryStore) GetRevision(number int64, writer io.Writer) (Revision, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, stored := range s.history {
+		if stored.revision.Number != number {
+			continue
+		}
+
+		if _, err := writer.Write(stored.content); err != nil {
+			s.logger.Error("Error writing file", "error", err)
+			return Revision{}, err
+		}
+		return stored.revision, nil
+	}
+
+	s.logger.Debug("Revision not found")
+	return Revision{}, ErrNotFound
+}
+
+// memoryLists keeps the lists in memory.
+type memoryLists struct {
+	stores map[string]*memoryStore
+	mutex  *sync.Mutex
+	logger *slog.Logger
+}
+
+// NewMemoryLists creates an empty set of lists
+func NewMemoryLists(logger *slog.Logger) *memoryLists {
+	return &memoryLists{
+		stores: make(map[string]*memoryStore),
+		mutex:  &sync.Mutex{},
+		logger: logger,
+	}
+}
+
+// Names retrieves the names of the lists, sorted.
+func (l *memoryLists) Names() ([]string, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	names := make([]string, 0, len(l.stores))
+	for name := range l.stores {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Open retrieves the store of an existing list.
+func (l *memoryLists) Open(name string) (Store, error) {
+	if !ValidName(name) {
+		return nil, ErrInvalidName
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	s, ok := l.stores[name]
+	if !ok {
+		l.logger.Debug("List not found", "list", name)
+		return nil, ErrNotFound
+	}
+	return s.withLogger(l.logger), nil
+}
+
+// Create creates an empty list and retrieves its store.
+func (l *memoryLists) Create(name string) (Store, error) {
+	if !ValidName(name) {
+		return nil, ErrInvalidName
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if _, ok := l.stores[name]; ok {
+		return nil, ErrExists
+	}
+
+	s := NewMemoryStore(nil, time.Time{}, l.logger)
+	if err := create(s); err != nil {
+		return nil, err
+	}
+	l.stores[name] = s
+	l.logger.Info("Created list", "list", name)
+	return s, nil
+}
+
+// Delete removes the list and its history.
+func (l *memoryLists) Delete(name string) error {
+	if !ValidName(name) {
+		return ErrInvalidName
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if _, ok := l.stores[name]; !ok {
+		l.logger.Debug("List not found", "list", name)
+		return ErrNotFound
+	}
+	delete(l.stores, name)
+	l.logger.Info("Deleted list", "list", name)
+	return nil
+}
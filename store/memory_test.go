@@ -0,0 +1,236 @@
+package store
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryGet(t *testing.T) {
+
+	version, _ := time.Parse(time.RFC1123, time.Now().Format(time.RFC1123))
+	revision := Revision{}.next([]byte("hola"), version)
+
+	cases := []struct {
+		content          []byte
+		revision         Revision
+		expectedBody     []byte
+		expectedRevision Revision
+		expectedError    error
+	}{
+		// OK
+		{
+			content:          []byte("hola"),
+			expectedBody:     []byte("hola"),
+			expectedRevision: revision,
+		},
+		// Not found
+		{
+			revision:      revision,
+			expectedError: ErrNotFound,
+		},
+		// Same revision
+		{
+			content:       []byte("hola"),
+			revision:      revision,
+			expectedError: ErrNotModified,
+		},
+		// Newer revision
+		{
+			content:       []byte("hola"),
+			revision:      revision.next([]byte("adios"), version),
+			expectedError: ErrVersionConflict,
+		},
+	}
+
+	for _, c := range cases {
+		s := NewMemoryStore(c.content, version, slog.New(slog.NewTextHandler(os.Stdout, nil)))
+
+		buff := &bytes.Buffer{}
+
+		if got, err := s.Get(c.revision, buff); err != nil {
+			if c.expectedError == nil {
+				t.Fatalf("Unexpected error %s", err.Error())
+			} else if c.expectedError != err {
+				t.Fatalf("Expected error %s, got %s", c.expectedError.Error(), err.Error())
+			}
+		} else if !got.Equal(c.expectedRevision) {
+			t.Fatalf("Expected revision %s, got %s", c.expectedRevision.ETag(), got.ETag())
+		} else if string(c.expectedBody) != buff.String() {
+			t.Fatalf("Expected %s, got %s", string(c.expectedBody), buff.String())
+		}
+	}
+
+}
+
+func TestMemorySafePut(t *testing.T) {
+
+	version, _ := time.Parse(time.RFC1123, time.Now().Format(time.RFC1123))
+	revision := Revision{}.next([]byte("hola"), version)
+
+	cases := []struct {
+		content          []byte
+		revision         Revision
+		body             []byte
+		expectedRevision Revision
+		expectedBody     []byte
+		expectedError    error
+	}{
+		// OK
+		{
+			content:          []byte("hola"),
+			revision:         revision,
+			body:             []byte("adios"),
+			expectedRevision: revision.next([]byte("adios"), version),
+			expectedBody:     []byte("adios"),
+		},
+		// Not found
+		{
+			body:             []byte("adios"),
+			expectedRevision: Revision{}.next([]byte("adios"), version),
+			expectedBody:     []byte("adios"),
+		},
+		// Old revision
+		{
+			content:       []byte("hola"),
+			body:          []byte("adios"),
+			expectedBody:  []byte("hola"),
+			expectedError: ErrVersionConflict,
+		},
+		// Unknown revision
+		{
+			content:       []byte("hola"),
+			revision:      revision.next([]byte("adios"), version),
+			body:          []byte("adios"),
+			expectedBody:  []byte("hola"),
+			expectedError: ErrVersionConflict,
+		},
+	}
+
+	for _, c := range cases {
+		s := NewMemoryStore(c.content, version, slog.New(slog.NewTextHandler(os.Stdout, nil)))
+
+		if got, err := s.SafePut(c.revision, version, int64(len(c.body)), bytes.NewReader(c.body)); err != nil {
+			if c.expectedError == nil {
+				t.Fatalf("Unexpected error %s", err.Error())
+			} else if c.expectedError != err {
+				t.Fatalf("Expected error %s, got %s", c.expectedError.Error(), err.Error())
+			}
+		} else if !got.Equal(c.expectedRevision) {
+			t.Fatalf("Expected revision %s, got %s", c.expectedRevision.ETag(), got.ETag())
+		}
+
+		if string(c.expectedBody) != string(s.content) {
+			t.Fatalf("Expected %s, got %s", string(c.expectedBody), string(s.content))
+		}
+	}
+}
+
+func TestMemoryConcurrentSafePut(t *testing.T) {
+
+	s := NewMemoryStore([]byte("hola"), time.Now(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	revision, _ := s.GetCurrentVersion()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.SafePut(revision, time.Now(), 5, bytes.NewReader([]byte("adios")))
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		if err == nil {
+			succeeded++
+		} else if err != ErrVersionConflict {
+			t.Fatalf("Unexpected error %s", err.Error())
+		}
+	}
+
+	if succeeded != 1 {
+		t.Fatalf("Expected 1 successful put, got %d", succeeded)
+	}
+}
+
+func TestMemoryHistory(t *testing.T) {
+
+	version, _ := time.Parse(time.RFC1123, time.Now().Format(time.RFC1123))
+	revision := Revision{}.next([]byte("hola"), version)
+
+	s := NewMemoryStore([]byte("hola"), version, slog.New(slog.NewTextHandler(os.Stdout, nil)))
+
+	second, err := s.SafePut(revision, version, 5, bytes.NewReader([]byte("adios")))
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	third, err := s.Overwrite(5, bytes.NewReader([]byte("hello")))
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	history, err := s.History()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	expected := []Revision{third, second, revision}
+	if len(history) != len(expected) {
+		t.Fatalf("Expected %d revisions, got %d", len(expected), len(history))
+	}
+	for i := range expected {
+		if !history[i].Equal(expected[i]) {
+			t.Fatalf("Expected revision %s, got %s", expected[i].ETag(), history[i].ETag())
+		}
+	}
+
+	cases := []struct {
+		number           int64
+		expectedBody     []byte
+		expectedRevision Revision
+		expectedError    error
+	}{
+		// OK
+		{
+			number:           2,
+			expectedBody:     []byte("adios"),
+			expectedRevision: second,
+		},
+		// Current
+		{
+			number:           3,
+			expectedBody:     []byte("hello"),
+			expectedRevision: third,
+		},
+		// Not found
+		{
+			number:        4,
+			expectedError: ErrNotFound,
+		},
+	}
+
+	for _, c := range cases {
+		buff := &bytes.Buffer{}
+
+		if got, err := s.GetRevision(c.number, buff); err != nil {
+			if c.expectedError == nil {
+				t.Fatalf("Unexpected error %s", err.Error())
+			} else if c.expectedError != err {
+				t.Fatalf("Expected error %s, got %s", c.expectedError.Error(), err.Error())
+			}
+		} else if !got.Equal(c.expectedRevision) {
+			t.Fatalf("Expected revision %s, got %s", c.expectedRevision.ETag(), got.ETag())
+		} else if string(c.expectedBody) != buff.String() {
+			t.Fatalf("Expected %s, got %s", string(c.expectedBody), buff.String())
+		}
+	}
+}
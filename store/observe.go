@@ -0,0 +1,73 @@
+package store
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// Observer is called after every S3 request with the operation, how long it
+// took and its error. Missing keys are expected and not reported as errors.
+type Observer func(operation string, elapsed time.Duration, err error)
+
+// Observe calls the observer after every S3 request of the store.
+func (s *store) Observe(observer Observer) {
+	s.s3 = &observedS3{S3API: s.s3, observer: observer}
+}
+
+// Observe calls the observer after every S3 request of the lists and their
+// stores.
+func (l *lists) Observe(observer Observer) {
+	l.s3 = &observedS3{S3API: l.s3, observer: observer}
+}
+
+// observedS3 reports the requests made by the stores
+type observedS3 struct {
+	s3iface.S3API
+	observer Observer
+}
+
+func (o *observedS3) observe(operation string, start time.Time, err error) {
+	if isNotFound(err) {
+		err = nil
+	}
+	o.observer(operation, time.Since(start), err)
+}
+
+func (o *observedS3) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	start := time.Now()
+	output, err := o.S3API.HeadObject(input)
+	o.observe("HeadObject", start, err)
+	return output, err
+}
+
+func (o *observedS3) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	start := time.Now()
+	output, err := o.S3API.GetObject(input)
+	o.observe("GetObject", start, err)
+	return output, err
+}
+
+func (o *observedS3) PutObjectWithContext(ctx aws.Context, input *s3.PutObjectInput, options ...request.Option) (*s3.PutObjectOutput, error) {
+	start := time.Now()
+	output, err := o.S3API.PutObjectWithContext(ctx, input, options...)
+	o.observe("PutObject", start, err)
+	return output, err
+}
+
+func (o *observedS3) ListObjectsV2(input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+	start := time.Now()
+	output, err := o.S3API.ListObjectsV2(input)
+	o.observe("ListObjectsV2", start, err)
+	return output, err
+}
+
+func (o *observedS3) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	start := time.Now()
+	output, err := o.S3API.DeleteObject(input)
+	o.observe("DeleteObject", start, err)
+	return output, err
+}
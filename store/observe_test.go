@@ -0,0 +1,110 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// failingS3mock fails listing the objects
+type failingS3mock struct {
+	*s3mock
+}
+
+func (m *failingS3mock) ListObjectsV2(input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+	return nil, awserr.New("InternalError", "internal error", errors.New("internal error"))
+}
+
+func TestObserve(t *testing.T) {
+
+	version, _ := time.Parse(time.RFC1123, time.Now().Format(time.RFC1123))
+	revision := Revision{}.next([]byte("hola"), version)
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	type call struct {
+		operation string
+		failed    bool
+	}
+	var calls []call
+	observer := func(operation string, elapsed time.Duration, err error) {
+		if elapsed < 0 {
+			t.Fatalf("Expected a positive duration, got %s", elapsed)
+		}
+		calls = append(calls, call{operation, err != nil})
+	}
+
+	s := &store{
+		key:    aws.String("test"),
+		bucket: aws.String("test"),
+		logger: logger,
+		s3:     &failingS3mock{testMock([]byte("hola"), revision, t)},
+	}
+	s.Observe(observer)
+	missing := &store{key: aws.String("missing"), bucket: s.bucket, logger: logger, s3: s.s3}
+
+	cases := []struct {
+		request       func() error
+		expectedCalls []call
+	}{
+		// Found
+		{
+			request: func() error {
+				_, err := s.Get(Revision{}, &bytes.Buffer{})
+				return err
+			},
+			expectedCalls: []call{{"GetObject", false}},
+		},
+		// Missing keys are not errors
+		{
+			request: func() error {
+				_, err := missing.Get(Revision{}, &bytes.Buffer{})
+				if err == ErrNotFound {
+					return nil
+				}
+				return err
+			},
+			expectedCalls: []call{{"GetObject", false}},
+		},
+		// Put
+		{
+			request: func() error {
+				_, err := s.SafePut(revision, version.Add(time.Second), 5, bytes.NewReader([]byte("adios")))
+				return err
+			},
+			expectedCalls: []call{{"HeadObject", false}, {"PutObject", false}, {"PutObject", false}},
+		},
+		// Failed
+		{
+			request: func() error {
+				if _, err := s.History(); err == nil {
+					return errors.New("expected error listing the history")
+				}
+				return nil
+			},
+			expectedCalls: []call{{"ListObjectsV2", true}},
+		},
+	}
+
+	for i, c := range cases {
+		calls = nil
+		if err := c.request(); err != nil {
+			t.Fatalf("Unexpected error %s in case %d", err.Error(), i)
+		}
+
+		if len(calls) != len(c.expectedCalls) {
+			t.Fatalf("Expected calls %v, got %v in case %d", c.expectedCalls, calls, i)
+		}
+		for j := range calls {
+			if calls[j] != c.expectedCalls[j] {
+				t.Fatalf("Expected calls %v, got %v in case %d", c.expectedCalls, calls, i)
+			}
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// RevisionNumber is the metadata field name of the revision number
+	RevisionNumber = "Revision"
+
+	// ContentHash is the metadata field name of the content hash
+	ContentHash = "Hash"
+
+	// StoredDate is the metadata field name of the date the server wrote
+	// the revision
+	StoredDate = "Stored"
+)
+
+// Revision identifies a version of the file. The number is assigned by the
+// store and grows with every write, the hash is computed from the content.
+// Modified is informative and only kept for backward compatibility, it's set
+// by the clients. Stored is when the store wrote the revision, zero if it's
+// unknown.
+type Revision struct {
+	Number   int64
+	Hash     string
+	Modified time.Time
+	Stored   time.Time
+}
+
+// ETag returns the revision as a strong entity tag.
+func (r Revision) ETag() string {
+	return fmt.Sprintf("\"%d-%s\"", r.Number, r.Hash)
+}
+
+// IsZero returns true when the revision doesn't identify any content.
+func (r Revision) IsZero() bool {
+	return r.Number == 0 && r.Hash == ""
+}
+
+// Equal returns true when both revisions identify the same content.
+func (r Revision) Equal(other Revision) bool {
+	return r.Number == other.Number && r.Hash == other.Hash
+}
+
+// ParseETag parses an entity tag created by Revision.ETag.
+func ParseETag(etag string) (Revision, error) {
+	if len(etag) < 2 || etag[0] != '"' || etag[len(etag)-1] != '"' {
+		return Revision{}, ErrInvalidVersion
+	}
+
+	parts := strings.SplitN(etag[1:len(etag)-1], "-", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return Revision{}, ErrInvalidVersion
+	}
+
+	number, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || number < 0 {
+		return Revision{}, ErrInvalidVersion
+	}
+
+	return Revision{Number: number, Hash: parts[1]}, nil
+}
+
+// next returns the revision that follows r for the provided content, stored
+// now. The dates are kept with the same precision as the metadata.
+func (r Revision) next(content []byte, modified time.Time) Revision {
+	return Revision{
+		Number:   r.Number + 1,
+		Hash:     hashContent(content),
+		Modified: modified.Truncate(time.Second),
+		Stored:   time.Now().Truncate(time.Second),
+	}
+}
+
+// metadata encodes the revision as object metadata.
+func (r Revision) metadata() map[string]string {
+	metadata := map[string]string{
+		Version:        r.Modified.Format(time.RFC1123),
+		RevisionNumber: strconv.FormatInt(r.Number, 10),
+		ContentHash:    r.Hash,
+	}
+	if !r.Stored.IsZero() {
+		metadata[StoredDate] = r.Stored.UTC().Format(time.RFC1123)
+	}
+	return metadata
+}
+
+// parseMetadata decodes the revision from the object metadata. Files written
+// before revisions existed only have the Version field, so they get the
+// revision number 0 and an empty hash the caller must fill. The stored date
+// is zero if it's missing.
+func parseMetadata(metadata map[string]string) (Revision, error) {
+	stored, found := metadata[Version]
+	if !found {
+		return Revision{}, ErrInvalidVersion
+	}
+
+	modified, err := time.Parse(time.RFC1123, stored)
+	if err != nil {
+		return Revision{}, ErrInvalidVersion
+	}
+
+	revision := Revision{Modified: modified, Hash: metadata[ContentHash]}
+
+	if number, found := metadata[RevisionNumber]; found {
+		if revision.Number, err = strconv.ParseInt(number, 10, 64); err != nil {
+			return Revision{}, ErrInvalidVersion
+		}
+	}
+
+	if date, found := metadata[StoredDate]; found {
+		if revision.Stored, err = time.Parse(time.RFC1123, date); err != nil {
+			return Revision{}, ErrInvalidVersion
+		}
+	}
+
+	return revision, nil
+}
+
+// hashContent returns the hash used to identify the content.
+func hashContent(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:8])
+}
+
+// sortRevisions sorts the revisions, newest first.
+func sortRevisions(revisions []Revision) {
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].Number > revisions[j].Number
+	})
+}
@@ -0,0 +1,62 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseETag(t *testing.T) {
+
+	revision := Revision{}.next([]byte("hola"), time.Now())
+
+	cases := []struct {
+		etag             string
+		expectedRevision Revision
+		expectedError    error
+	}{
+		// OK
+		{
+			etag:             revision.ETag(),
+			expectedRevision: revision,
+		},
+		// Legacy revision
+		{
+			etag:             "\"0-abc\"",
+			expectedRevision: Revision{Hash: "abc"},
+		},
+		// Not quoted
+		{
+			etag:          "1-abc",
+			expectedError: ErrInvalidVersion,
+		},
+		// Missing hash
+		{
+			etag:          "\"1-\"",
+			expectedError: ErrInvalidVersion,
+		},
+		// Invalid number
+		{
+			etag:          "\"foo-abc\"",
+			expectedError: ErrInvalidVersion,
+		},
+		// Weak ETag
+		{
+			etag:          "W/\"1-abc\"",
+			expectedError: ErrInvalidVersion,
+		},
+	}
+
+	for _, c := range cases {
+		if got, err := ParseETag(c.etag); err != nil {
+			if c.expectedError == nil {
+				t.Fatalf("Unexpected error %s", err.Error())
+			} else if c.expectedError != err {
+				t.Fatalf("Expected error %s, got %s", c.expectedError.Error(), err.Error())
+			}
+		} else if c.expectedError != nil {
+			t.Fatalf("Expected error %s for %s", c.expectedError.Error(), c.etag)
+		} else if !got.Equal(c.expectedRevision) {
+			t.Fatalf("Expected revision %s, got %s", c.expectedRevision.ETag(), got.ETag())
+		}
+	}
+}
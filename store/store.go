@@ -1,22 +1,35 @@
 package store
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 )
 
-// Version is the metadata field name
+// Version is the metadata field name of the modification date
 const Version = "Version"
 
+// historySuffix is appended to the file name to store its revisions
+const historySuffix = ".history/"
+
+// overwriteRetries is the number of times Overwrite reads the revision again
+// when the file changes while writing it
+const overwriteRetries = 10
+
 var (
 	// ErrNotModified is returned when the stored version is the same as provided
 	ErrNotModified = errors.New("not modified")
@@ -36,158 +49,415 @@ var (
 // Store retrieves and updates the TODO list
 type Store interface {
 
-	// GetCurrentVersion retrieves the version stored.
-	GetCurrentVersion() (time.Time, error)
+	// GetCurrentVersion retrieves the revision stored.
+	GetCurrentVersion() (Revision, error)
+
+	// Get retrieves the file if the stored revision is different from the
+	// provided one. A zero revision always retrieves the file.
+	Get(Revision, io.Writer) (Revision, error)
 
-	// Get retrieves the file
-	Get(time.Time, io.Writer) (time.Time, error)
+	// SafePut overwrites the file if the stored revision is still the provided
+	// one, so the file can't change between reading and writing it. The
+	// modification date is informative.
+	SafePut(Revision, time.Time, int64, io.ReadSeeker) (Revision, error)
 
-	// SafePut overwrites the file if the new version is newer than the stored one.
-	SafePut(time.Time, int64, io.ReadSeeker) error
+	// Overwrite overwrites the revision stored.
+	Overwrite(int64, io.ReadSeeker) (Revision, error)
 
-	// Overwrite overwrites the version stored.
-	Overwrite(int64, io.ReadSeeker) error
+	// History retrieves the stored revisions, newest first.
+	History() ([]Revision, error)
+
+	// GetRevision retrieves the content of a stored revision.
+	GetRevision(int64, io.Writer) (Revision, error)
 }
 
 // store uses S3 to store the files
 type store struct {
-	s3     s3iface.S3API
-	bucket *string
-	key    *string
-	logger *log.Logger
+	s3         s3iface.S3API
+	bucket     *string
+	key        *string
+	encryption Encryption
+	logger     *slog.Logger
 }
 
 // NewStore creates a new store using the provided key and bucket
-func NewStore(bucket, key, region string, logger *log.Logger) *store {
-	s3Client := s3.New(session.New(&aws.Config{
-		Region:     aws.String(region),
-		MaxRetries: aws.Int(5),
-	}))
-
+func NewStore(bucket, key, region string, logger *slog.Logger) *store {
 	return &store{
-		s3:     s3Client,
+		s3:     newS3Client(region),
 		bucket: aws.String(bucket),
 		key:    aws.String(key),
 		logger: logger,
 	}
 }
 
-// GetCurrentVersion retrieves the version stored.
-func (s *store) GetCurrentVersion() (time.Time, error) {
-	resp, err := s.s3.HeadObject(&s3.HeadObjectInput{
+// newS3Client creates the S3 client for the region
+func newS3Client(region string) s3iface.S3API {
+	return s3.New(session.New(&aws.Config{
+		Region:     aws.String(region),
+		MaxRetries: aws.Int(5),
+	}))
+}
+
+// GetCurrentVersion retrieves the revision stored.
+func (s *store) GetCurrentVersion() (Revision, error) {
+	revision, _, err := s.head(s.key)
+	return revision, err
+}
+
+// head retrieves the revision stored in the key and the ETag of the object.
+func (s *store) head(key *string) (Revision, *string, error) {
+	input := &s3.HeadObjectInput{
 		Bucket: s.bucket,
-		Key:    s.key,
-	})
+		Key:    key,
+	}
+	s.encryption.head(input)
+
+	resp, err := s.s3.HeadObject(input)
 
 	if err != nil {
 		if isNotFound(err) {
-			s.logger.Print("File not found")
-			return time.Time{}, ErrNotFound
+			s.logger.Debug("File not found")
+			return Revision{}, nil, ErrNotFound
 		}
-		s.logger.Printf("Error getting file info: %s", err.Error())
-		return time.Time{}, err
+		s.logger.Error("Error getting file info", "error", err)
+		return Revision{}, nil, err
 	}
 
-	metadata, found := resp.Metadata[Version]
-	if !found {
-		s.logger.Printf("Missing stored version, found metadata %+v", resp.Metadata)
-		return time.Time{}, ErrInvalidVersion
+	revision, err := s.parseRevision(resp.Metadata, resp.ETag, resp.LastModified)
+	if err != nil {
+		return Revision{}, nil, err
 	}
 
-	version, err := time.Parse(time.RFC1123, *metadata)
+	return revision, resp.ETag, nil
+}
+
+// Get retrieves the file if the stored revision is different from the
+// provided one. A zero revision always retrieves the file.
+func (s *store) Get(revision Revision, writer io.Writer) (Revision, error) {
+	return s.get(s.key, revision, writer)
+}
+
+// GetRevision retrieves the content of a stored revision.
+func (s *store) GetRevision(number int64, writer io.Writer) (Revision, error) {
+	key, err := s.findHistoryKey(number)
 	if err != nil {
-		s.logger.Printf("Invalid stored version: %s", err.Error())
-		return time.Time{}, ErrInvalidVersion
+		return Revision{}, err
 	}
-
-	return version, nil
+	return s.get(key, Revision{}, writer)
 }
 
-// Get retrieves the file
-func (s *store) Get(version time.Time, writer io.Writer) (time.Time, error) {
-	resp, err := s.s3.GetObject(&s3.GetObjectInput{
+// get retrieves the object in the key if its revision is different from the
+// provided one.
+func (s *store) get(key *string, revision Revision, writer io.Writer) (Revision, error) {
+	input := &s3.GetObjectInput{
 		Bucket: s.bucket,
-		Key:    s.key,
-	})
+		Key:    key,
+	}
+	s.encryption.get(input)
+
+	resp, err := s.s3.GetObject(input)
 	if err != nil {
 		if isNotFound(err) {
-			s.logger.Print("File not found")
-			return time.Time{}, ErrNotFound
+			s.logger.Debug("File not found")
+			return Revision{}, ErrNotFound
 		}
-		s.logger.Printf("Error getting file: %s", err.Error())
-		return time.Time{}, err
+		s.logger.Error("Error getting file", "error", err)
+		return Revision{}, err
 	}
 	defer resp.Body.Close()
 
-	metadata, found := resp.Metadata[Version]
-	if !found {
-		s.logger.Print("Missing stored version")
-		return time.Time{}, ErrInvalidVersion
+	currentRevision, err := s.parseRevision(resp.Metadata, resp.ETag, resp.LastModified)
+	if err != nil {
+		return Revision{}, err
 	}
 
-	currentVersion, err := time.Parse(time.RFC1123, *metadata)
-	if err != nil {
-		s.logger.Printf("Invalid stored version: %s", err.Error())
-		return time.Time{}, ErrInvalidVersion
+	if err := checkRevision(currentRevision, revision, s.logger); err != nil {
+		return Revision{}, err
 	}
 
-	if currentVersion.After(version) {
-		// Read all in memory
-		content, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			s.logger.Printf("Error reading file: %s", err.Error())
-			return time.Time{}, err
-		}
+	// Read all in memory
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		s.logger.Error("Error reading file", "error", err)
+		return Revision{}, err
+	}
 
-		if _, err := writer.Write(content); err != nil {
-			s.logger.Printf("Error writing file: %s", err.Error())
-			return time.Time{}, err
-		}
-	} else if currentVersion.Equal(version) {
-		s.logger.Print("The provided version is same as the content")
-		return time.Time{}, ErrNotModified
-	} else {
-		s.logger.Print("The provided version is newer than the content")
-		return time.Time{}, ErrVersionConflict
+	if _, err := writer.Write(content); err != nil {
+		s.logger.Error("Error writing file", "error", err)
+		return Revision{}, err
 	}
 
-	return currentVersion, nil
+	return currentRevision, nil
 }
 
-// SafePut overwrites the file if the new version is newer than the stored one.
-func (s *store) SafePut(version time.Time, contentLength int64, reader io.ReadSeeker) error {
-	currentVersion, err := s.GetCurrentVersion()
+// SafePut overwrites the file if the stored revision is still the provided
+// one. The write is conditional on the object not changing since its
+// revision was checked, so concurrent puts can't overwrite each other.
+func (s *store) SafePut(revision Revision, modified time.Time, contentLength int64, reader io.ReadSeeker) (Revision, error) {
+	currentRevision, etag, err := s.head(s.key)
 	if err != nil {
 		if err != ErrNotFound {
-			return err
+			return Revision{}, err
 		}
-		currentVersion = time.Time{}
+		currentRevision = Revision{}
+	}
+
+	if !currentRevision.Equal(revision) {
+		s.logger.Info("Version conflict", "stored", currentRevision.ETag())
+		return Revision{}, ErrVersionConflict
 	}
 
-	if currentVersion.Before(version) {
-		return s.write(version, contentLength, reader)
+	condition := withHeader("If-None-Match", "*")
+	if etag != nil {
+		condition = withHeader("If-Match", *etag)
+	}
+
+	newRevision, err := s.write(currentRevision, modified, contentLength, reader, condition)
+	if err != nil {
+		if isPreconditionFailed(err) {
+			s.logger.Info("Version conflict, the file changed while writing")
+			return Revision{}, ErrVersionConflict
+		}
+		return Revision{}, err
 	}
 
-	s.logger.Printf("Version conflict, the stored version is newer")
-	return ErrVersionConflict
+	return newRevision, nil
 }
 
-// Overwrite overwrites the version stored.
-func (s *store) Overwrite(contentLength int64, reader io.ReadSeeker) error {
-	return s.write(time.Now(), contentLength, reader)
+// Overwrite overwrites the revision stored. The write is conditional too, so
+// a concurrent put can't store the same revision number: if the file changes
+// while writing, it's written again after the new revision.
+func (s *store) Overwrite(contentLength int64, reader io.ReadSeeker) (Revision, error) {
+	for i := 0; i < overwriteRetries; i++ {
+		currentRevision, etag, err := s.head(s.key)
+		if err != nil && err != ErrNotFound {
+			return Revision{}, err
+		}
+
+		condition := withHeader("If-None-Match", "*")
+		if etag != nil {
+			condition = withHeader("If-Match", *etag)
+		}
+
+		newRevision, err := s.write(currentRevision, time.Now(), contentLength, reader, condition)
+		if err == nil {
+			return newRevision, nil
+		}
+		if !isPreconditionFailed(err) {
+			return Revision{}, err
+		}
+		s.logger.Info("The file changed while overwriting it, trying again")
+	}
+
+	s.logger.Warn("Too many version conflicts overwriting the file")
+	return Revision{}, ErrVersionConflict
 }
 
-func (s *store) write(version time.Time, contentLength int64, reader io.ReadSeeker) error {
-	if _, err := s.s3.PutObject(&s3.PutObjectInput{
+// write stores the content as the revision following the provided one. The
+// content is read from the start, so the write can be retried.
+func (s *store) write(revision Revision, modified time.Time, contentLength int64, reader io.ReadSeeker, options ...request.Option) (Revision, error) {
+	if _, err := reader.Seek(0, io.SeekStart); err != nil {
+		s.logger.Error("Can't read the file", "error", err)
+		return Revision{}, err
+	}
+
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		s.logger.Error("Can't read the file", "error", err)
+		return Revision{}, err
+	}
+
+	if _, err := reader.Seek(0, io.SeekStart); err != nil {
+		s.logger.Error("Can't read the file", "error", err)
+		return Revision{}, err
+	}
+
+	newRevision := revision.next(content, modified)
+
+	metadata := make(map[string]*string)
+	for k, v := range newRevision.metadata() {
+		metadata[k] = aws.String(v)
+	}
+
+	input := &s3.PutObjectInput{
 		Body:          reader,
 		Bucket:        s.bucket,
 		Key:           s.key,
 		ContentType:   contentType,
 		ContentLength: aws.Int64(contentLength),
-		Metadata:      map[string]*string{Version: aws.String(version.Format(time.RFC1123))},
-	}); err != nil {
-		s.logger.Printf("Can't store the file: %s", err.Error())
-		return err
+		Metadata:      metadata,
+	}
+	s.encryption.put(input)
+
+	if _, err := s.s3.PutObjectWithContext(context.Background(), input, options...); err != nil {
+		s.logger.Error("Can't store the file", "error", err)
+		return Revision{}, err
+	}
+
+	history := &s3.PutObjectInput{
+		Body:          bytes.NewReader(content),
+		Bucket:        s.bucket,
+		Key:           s.historyKey(newRevision),
+		ContentType:   contentType,
+		ContentLength: aws.Int64(int64(len(content))),
+		Metadata:      metadata,
+	}
+	s.encryption.put(history)
+
+	// The file is already stored, a missing copy only affects the history
+	if _, err := s.s3.PutObjectWithContext(context.Background(), history); err != nil {
+		s.logger.Error("Can't store the revision in the history", "revision", newRevision.Number, "error", err)
+	}
+
+	return newRevision, nil
+}
+
+// History retrieves the stored revisions, newest first. The revisions are
+// read from the keys of the history, without reading every object.
+func (s *store) History() ([]Revision, error) {
+	var revisions []Revision
+	err := s.listHistory(func(object *s3.Object) error {
+		revision, ok := s.parseHistoryKey(*object.Key)
+		if !ok {
+			// Stored before the keys had the hash
+			legacy, _, err := s.head(object.Key)
+			if err != nil {
+				return err
+			}
+			revisions = append(revisions, legacy)
+			return nil
+		}
+
+		revision.Modified = aws.TimeValue(object.LastModified)
+		revision.Stored = revision.Modified
+		revisions = append(revisions, revision)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortRevisions(revisions)
+	return revisions, nil
+}
+
+// listHistory calls the function with every object of the history.
+func (s *store) listHistory(each func(*s3.Object) error) error {
+	input := &s3.ListObjectsV2Input{
+		Bucket: s.bucket,
+		Prefix: aws.String(*s.key + historySuffix),
+	}
+
+	for {
+		resp, err := s.s3.ListObjectsV2(input)
+		if err != nil {
+			s.logger.Error("Error listing the history", "error", err)
+			return err
+		}
+
+		for _, object := range resp.Contents {
+			if err := each(object); err != nil {
+				return err
+			}
+		}
+
+		if resp.IsTruncated == nil || !*resp.IsTruncated {
+			return nil
+		}
+		input.ContinuationToken = resp.NextContinuationToken
+	}
+}
+
+// historyKey returns the key of a revision in the history: its number,
+// padded to sort them, and its hash.
+func (s *store) historyKey(revision Revision) *string {
+	return aws.String(fmt.Sprintf("%s%s%020d-%s", *s.key, historySuffix, revision.Number, revision.Hash))
+}
+
+// parseHistoryKey returns the revision of a key in the history, false if
+// the key doesn't have the hash.
+func (s *store) parseHistoryKey(key string) (Revision, bool) {
+	parts := strings.SplitN(strings.TrimPrefix(key, *s.key+historySuffix), "-", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return Revision{}, false
+	}
+
+	number, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return Revision{}, false
+	}
+	return Revision{Number: number, Hash: parts[1]}, true
+}
+
+// findHistoryKey returns the key of the revision number in the history,
+// with or without the hash.
+func (s *store) findHistoryKey(number int64) (*string, error) {
+	resp, err := s.s3.ListObjectsV2(&s3.ListObjectsV2Input{
+		Bucket:  s.bucket,
+		Prefix:  aws.String(fmt.Sprintf("%s%s%020d", *s.key, historySuffix, number)),
+		MaxKeys: aws.Int64(1),
+	})
+	if err != nil {
+		s.logger.Error("Error listing the history", "error", err)
+		return nil, err
+	}
+
+	if len(resp.Contents) == 0 {
+		s.logger.Debug("Revision not found", "revision", number)
+		return nil, ErrNotFound
+	}
+	return resp.Contents[0].Key, nil
+}
+
+// parseRevision reads the revision from the object metadata. Objects
+// written before revisions existed use the S3 ETag as content hash, and the
+// S3 modification date as the date they were stored.
+func (s *store) parseRevision(metadata map[string]*string, etag *string, lastModified *time.Time) (Revision, error) {
+	values := make(map[string]string)
+	for k, v := range metadata {
+		if v != nil {
+			values[k] = *v
+		}
+	}
+
+	revision, err := parseMetadata(values)
+	if err != nil {
+		s.logger.Error("Invalid stored version", "metadata", values)
+		return Revision{}, err
+	}
+
+	if revision.Hash == "" && etag != nil {
+		revision.Hash = strings.Trim(*etag, "\"")
+	}
+	if revision.Stored.IsZero() {
+		revision.Stored = aws.TimeValue(lastModified)
+	}
+
+	return revision, nil
+}
+
+// withHeader sets a header in the S3 request. Used for the conditional
+// write headers, which aren't part of PutObjectInput.
+func withHeader(key, value string) request.Option {
+	return func(r *request.Request) {
+		r.HTTPRequest.Header.Set(key, value)
+	}
+}
+
+// checkRevision compares the stored revision with the one the client has.
+func checkRevision(current, revision Revision, logger *slog.Logger) error {
+	if revision.IsZero() {
+		return nil
+	}
+
+	if current.Equal(revision) {
+		logger.Debug("The provided revision is same as the content")
+		return ErrNotModified
+	}
+
+	if current.Number < revision.Number {
+		logger.Info("The provided revision is newer than the content")
+		return ErrVersionConflict
 	}
 
 	return nil
@@ -202,3 +472,12 @@ func isNotFound(err error) bool {
 	}
 	return false
 }
+
+// isPreconditionFailed returns true when a conditional write was rejected,
+// either because the ETag didn't match or because of a concurrent write.
+func isPreconditionFailed(err error) bool {
+	if aerr, ok := err.(awserr.RequestFailure); ok {
+		return aerr.StatusCode() == 412 || aerr.StatusCode() == 409
+	}
+	return false
+}
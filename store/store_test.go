@@ -2,23 +2,30 @@ package store
 
 import (
 	"bytes"
+	"crypto/md5"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/carlosmecha/todo/util/testutil"
 )
 
 type s3mock struct {
-	data    map[string][]byte
-	version map[string]string
-	t       *testing.T
+	data     map[string][]byte
+	metadata map[string]map[string]string
+	t        *testing.T
+	mutex    sync.Mutex
 
 	s3iface.S3API
 }
@@ -26,6 +33,8 @@ type s3mock struct {
 func (m *s3mock) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
 	url := fmt.Sprintf("s3://%s/%s", *input.Bucket, *input.Key)
 	m.t.Logf("Called GetObject %s", url)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 	if _, ok := m.data[url]; !ok {
 		return nil, awserr.New(s3.ErrCodeNoSuchKey, "not found", ErrNotFound)
 	}
@@ -36,13 +45,16 @@ func (m *s3mock) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error
 		Body:          buffer,
 		ContentLength: aws.Int64(int64(len(m.data[url]))),
 		ContentType:   aws.String("text/plan"),
-		Metadata:      map[string]*string{Version: aws.String(m.version[url])},
+		ETag:          etag(m.data[url]),
+		Metadata:      aws.StringMap(m.metadata[url]),
 	}, nil
 }
 
 func (m *s3mock) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
 	url := fmt.Sprintf("s3://%s/%s", *input.Bucket, *input.Key)
 	m.t.Logf("Called HeadObject %s", url)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 	if _, ok := m.data[url]; !ok {
 		return nil, awserr.New(s3.ErrCodeNoSuchKey, "not found", ErrNotFound)
 	}
@@ -50,11 +62,12 @@ func (m *s3mock) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, er
 	return &s3.HeadObjectOutput{
 		ContentLength: aws.Int64(int64(len(m.data[url]))),
 		ContentType:   aws.String("text/plan"),
-		Metadata:      map[string]*string{Version: aws.String(m.version[url])},
+		ETag:          etag(m.data[url]),
+		Metadata:      aws.StringMap(m.metadata[url]),
 	}, nil
 }
 
-func (m *s3mock) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+func (m *s3mock) PutObjectWithContext(_ aws.Context, input *s3.PutObjectInput, options ...request.Option) (*s3.PutObjectOutput, error) {
 	url := fmt.Sprintf("s3://%s/%s", *input.Bucket, *input.Key)
 	m.t.Logf("Called PutObject %s", url)
 	b := new(bytes.Buffer)
@@ -62,32 +75,117 @@ func (m *s3mock) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error
 		return nil, err
 	}
 
+	req := &request.Request{HTTPRequest: &http.Request{Header: http.Header{}}}
+	req.ApplyOptions(options...)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	current, found := m.data[url]
+	if match := req.HTTPRequest.Header.Get("If-Match"); match != "" && (!found || match != *etag(current)) {
+		return nil, awserr.NewRequestFailure(awserr.New("PreconditionFailed", "precondition failed", nil), 412, "")
+	}
+	if req.HTTPRequest.Header.Get("If-None-Match") == "*" && found {
+		return nil, awserr.NewRequestFailure(awserr.New("PreconditionFailed", "precondition failed", nil), 412, "")
+	}
+
 	if m.data == nil {
 		m.data = make(map[string][]byte)
 	}
+	if m.metadata == nil {
+		m.metadata = make(map[string]map[string]string)
+	}
 
 	m.data[url] = b.Bytes()
-	m.version[url] = *input.Metadata[Version]
+	m.metadata[url] = aws.StringValueMap(input.Metadata)
+
+	return &s3.PutObjectOutput{ETag: etag(m.data[url])}, nil
+}
+
+func (m *s3mock) ListObjectsV2(input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+	prefix := fmt.Sprintf("s3://%s/%s", *input.Bucket, aws.StringValue(input.Prefix))
+	m.t.Logf("Called ListObjectsV2 %s", prefix)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var urls []string
+	for url := range m.data {
+		if strings.HasPrefix(url, prefix) {
+			urls = append(urls, url)
+		}
+	}
+	sort.Strings(urls)
 
-	return &s3.PutObjectOutput{}, nil
+	if input.MaxKeys != nil && int64(len(urls)) > *input.MaxKeys {
+		urls = urls[:*input.MaxKeys]
+	}
+
+	output := &s3.ListObjectsV2Output{IsTruncated: aws.Bool(false)}
+	for _, url := range urls {
+		output.Contents = append(output.Contents, &s3.Object{
+			Key:  aws.String(strings.TrimPrefix(url, fmt.Sprintf("s3://%s/", *input.Bucket))),
+			Size: aws.Int64(int64(len(m.data[url]))),
+		})
+	}
+
+	return output, nil
+}
+
+func (m *s3mock) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	url := fmt.Sprintf("s3://%s/%s", *input.Bucket, *input.Key)
+	m.t.Logf("Called DeleteObject %s", url)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.data, url)
+	delete(m.metadata, url)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func etag(data []byte) *string {
+	return aws.String(fmt.Sprintf("\"%x\"", md5.Sum(data)))
+}
+
+// testMock returns a mock with three files: s3://test/test with the revision
+// provided, s3://test/legacy with only the version date and s3://test/test2
+// without metadata.
+func testMock(content []byte, revision Revision, t *testing.T) *s3mock {
+	return &s3mock{
+		data: map[string][]byte{
+			"s3://test/test":   content,
+			"s3://test/legacy": content,
+			"s3://test/test2":  []byte(""),
+		},
+		metadata: map[string]map[string]string{
+			"s3://test/test":   revision.metadata(),
+			"s3://test/legacy": {Version: revision.Modified.Format(time.RFC1123)},
+		},
+		t: t,
+	}
 }
 
 func TestGetCurrentVersion(t *testing.T) {
 
-	currentVersion := time.Now().Format(time.RFC1123)
-	version, _ := time.Parse(time.RFC1123, currentVersion)
+	version, _ := time.Parse(time.RFC1123, time.Now().Format(time.RFC1123))
+	revision := Revision{}.next([]byte("hola"), version)
 
 	cases := []struct {
-		key             string
-		bucket          string
-		expectedVersion time.Time
-		expectedError   error
+		key              string
+		bucket           string
+		expectedRevision Revision
+		expectedError    error
 	}{
 		// OK
 		{
-			key:             "test",
-			bucket:          "test",
-			expectedVersion: version,
+			key:              "test",
+			bucket:           "test",
+			expectedRevision: revision,
+		},
+		// Legacy version
+		{
+			key:              "legacy",
+			bucket:           "test",
+			expectedRevision: Revision{Hash: strings.Trim(*etag([]byte("hola")), "\"")},
 		},
 		// Not found
 		{
@@ -103,22 +201,13 @@ func TestGetCurrentVersion(t *testing.T) {
 		},
 	}
 
-	mock := &s3mock{
-		data: map[string][]byte{
-			"s3://test/test":  []byte(""),
-			"s3://test/test2": []byte(""),
-		},
-		version: map[string]string{
-			"s3://test/test": version.Format(time.RFC1123),
-		},
-		t: t,
-	}
+	mock := testMock([]byte("hola"), revision, t)
 
 	for _, c := range cases {
 		s := &store{
 			key:    aws.String(c.key),
 			bucket: aws.String(c.bucket),
-			logger: log.New(os.Stdout, "", log.LstdFlags),
+			logger: slog.New(slog.NewTextHandler(os.Stdout, nil)),
 			s3:     mock,
 		}
 
@@ -128,8 +217,10 @@ func TestGetCurrentVersion(t *testing.T) {
 			} else if c.expectedError != err {
 				t.Fatalf("Expected error %s, got %s", c.expectedError.Error(), err.Error())
 			}
-		} else if !got.Equal(c.expectedVersion) {
-			t.Fatalf("Expected version %s, got %s", c.expectedVersion.Format(time.RFC1123), got.Format(time.RFC1123))
+		} else if !got.Equal(c.expectedRevision) {
+			t.Fatalf("Expected revision %s, got %s", c.expectedRevision.ETag(), got.ETag())
+		} else if !got.Modified.Equal(version) {
+			t.Fatalf("Expected version %s, got %s", version.Format(time.RFC1123), got.Modified.Format(time.RFC1123))
 		}
 
 	}
@@ -138,85 +229,82 @@ func TestGetCurrentVersion(t *testing.T) {
 
 func TestGet(t *testing.T) {
 
-	now := time.Now()
-	currentVersion := now.Format(time.RFC1123)
-	version, _ := time.Parse(time.RFC1123, currentVersion)
+	version, _ := time.Parse(time.RFC1123, time.Now().Format(time.RFC1123))
+	revision := Revision{}.next([]byte("hola"), version)
 
 	cases := []struct {
-		key             string
-		bucket          string
-		version         time.Time
-		expectedBody    []byte
-		expectedVersion time.Time
-		expectedError   error
+		key              string
+		bucket           string
+		revision         Revision
+		expectedBody     []byte
+		expectedRevision Revision
+		expectedError    error
 	}{
 		// OK
 		{
-			key:             "test",
-			bucket:          "test",
-			version:         now.AddDate(-1, 0, 0),
-			expectedBody:    []byte("hola"),
-			expectedVersion: version,
+			key:              "test",
+			bucket:           "test",
+			expectedBody:     []byte("hola"),
+			expectedRevision: revision,
+		},
+		// Older revision
+		{
+			key:              "test",
+			bucket:           "test",
+			revision:         Revision{Number: 0, Hash: "foo"},
+			expectedBody:     []byte("hola"),
+			expectedRevision: revision,
 		},
 		// Not found
 		{
 			key:           "foo",
 			bucket:        "bar",
-			version:       version,
+			revision:      revision,
 			expectedError: ErrNotFound,
 		},
 		// Missing version
 		{
 			key:           "test2",
 			bucket:        "test",
-			version:       version,
+			revision:      revision,
 			expectedError: ErrInvalidVersion,
 		},
-		// Same version
+		// Same revision
 		{
 			key:           "test",
 			bucket:        "test",
-			version:       version,
+			revision:      revision,
 			expectedError: ErrNotModified,
 		},
-		// Newer version
+		// Newer revision
 		{
 			key:           "test",
 			bucket:        "test",
-			version:       version.AddDate(1, 0, 0),
+			revision:      revision.next([]byte("adios"), version),
 			expectedError: ErrVersionConflict,
 		},
 	}
 
-	mock := &s3mock{
-		data: map[string][]byte{
-			"s3://test/test":  []byte("hola"),
-			"s3://test/test2": []byte(""),
-		},
-		version: map[string]string{
-			"s3://test/test": version.Format(time.RFC1123),
-		},
-		t: t,
-	}
+	mock := testMock([]byte("hola"), revision, t)
 
 	for _, c := range cases {
 		s := &store{
 			key:    aws.String(c.key),
 			bucket: aws.String(c.bucket),
-			logger: log.New(os.Stdout, "", log.LstdFlags),
+			logger: slog.New(slog.NewTextHandler(os.Stdout, nil)),
 			s3:     mock,
 		}
 
 		buff := &bytes.Buffer{}
 
-		if got, err := s.Get(c.version, buff); err != nil {
+		if got, err := s.Get(c.revision, buff); err != nil {
 			if c.expectedError == nil {
 				t.Fatalf("Unexpected error %s", err.Error())
 			} else if c.expectedError != err {
 				t.Fatalf("Expected error %s, got %s", c.expectedError.Error(), err.Error())
 			}
-		} else if !got.Equal(c.expectedVersion) {
-			t.Fatalf("Expected version %s, got %s", c.expectedVersion.Format(time.RFC1123), got.Format(time.RFC1123))
+		} else if !got.Equal(c.expectedRevision) {
+			t.Fatalf("Expected revision %s, got %s", c.expectedRevision.ETag(), got.ETag())
 		} else if string(c.expectedBody) != buff.String() {
 			t.Fatalf("Expected %s, got %s", string(c.expectedBody), buff.String())
 		}
@@ -227,76 +315,77 @@ func TestGet(t *testing.T) {
 
 func TestSafePut(t *testing.T) {
 
-	now := time.Now()
-	currentVersion := now.Format(time.RFC1123)
-	version, _ := time.Parse(time.RFC1123, currentVersion)
+	version, _ := time.Parse(time.RFC1123, time.Now().Format(time.RFC1123))
+	revision := Revision{}.next([]byte("hola"), version)
+	legacy := Revision{Hash: strings.Trim(*etag([]byte("hola")), "\"")}
 
 	cases := []struct {
-		key             string
-		bucket          string
-		version         time.Time
-		body            []byte
-		expectedVersion time.Time
-		expectedBody    []byte
-		expectedError   error
+		key              string
+		bucket           string
+		revision         Revision
+		body             []byte
+		expectedRevision Revision
+		expectedBody     []byte
+		expectedError    error
 	}{
 		// OK
 		{
-			key:             "test",
-			bucket:          "test",
-			version:         version.AddDate(0, 0, 1),
-			body:            []byte("adios"),
-			expectedVersion: version.AddDate(0, 0, 1),
-			expectedBody:    []byte("adios"),
+			key:              "test",
+			bucket:           "test",
+			revision:         revision,
+			body:             []byte("adios"),
+			expectedRevision: revision.next([]byte("adios"), version),
+			expectedBody:     []byte("adios"),
+		},
+		// Legacy version
+		{
+			key:              "legacy",
+			bucket:           "test",
+			revision:         legacy,
+			body:             []byte("adios"),
+			expectedRevision: legacy.next([]byte("adios"), version),
+			expectedBody:     []byte("adios"),
 		},
 		// Not found
 		{
-			key:             "foo",
-			bucket:          "bar",
-			version:         version.AddDate(0, 0, 1),
-			body:            []byte("adios"),
-			expectedVersion: version.AddDate(0, 0, 1),
-			expectedBody:    []byte("adios"),
+			key:              "foo",
+			bucket:           "bar",
+			body:             []byte("adios"),
+			expectedRevision: Revision{}.next([]byte("adios"), version),
+			expectedBody:     []byte("adios"),
 		},
-		// Same date
+		// Old revision
 		{
 			key:           "test",
 			bucket:        "test",
-			version:       version,
+			revision:      Revision{},
 			body:          []byte("adios"),
 			expectedError: ErrVersionConflict,
 		},
-		// Older date
+		// Unknown revision
 		{
 			key:           "test",
 			bucket:        "test",
-			version:       version.AddDate(0, 0, -1),
+			revision:      revision.next([]byte("adios"), version),
 			body:          []byte("adios"),
 			expectedError: ErrVersionConflict,
 		},
 	}
 
-	mock := &s3mock{
-		data: map[string][]byte{
-			"s3://test/test": []byte("hola"),
-		},
-		version: map[string]string{
-			"s3://test/test": version.Format(time.RFC1123),
-		},
-		t: t,
-	}
-
 	for _, c := range cases {
+		mock := testMock([]byte("hola"), revision, t)
+
 		s := &store{
 			key:    aws.String(c.key),
 			bucket: aws.String(c.bucket),
-			logger: log.New(os.Stdout, "", log.LstdFlags),
+			logger: slog.New(slog.NewTextHandler(os.Stdout, nil)),
 			s3:     mock,
 		}
 
 		buff := bytes.NewReader(c.body)
 
-		if err := s.SafePut(c.version, int64(len(c.body)), buff); err != nil {
+		got, err := s.SafePut(c.revision, version, int64(len(c.body)), buff)
+		if err != nil {
 			if c.expectedError == nil {
 				t.Fatalf("Unexpected error %s", err.Error())
 			} else if c.expectedError != err {
@@ -305,72 +394,78 @@ func TestSafePut(t *testing.T) {
 			continue
 		}
 
+		if !got.Equal(c.expectedRevision) {
+			t.Fatalf("Expected revision %s, got %s", c.expectedRevision.ETag(), got.ETag())
+		}
+
 		url := fmt.Sprintf("s3://%s/%s", c.bucket, c.key)
-		gotVersion := mock.version[url]
-		gotBody := mock.data[url]
+		stored, err := parseMetadata(mock.metadata[url])
+		if err != nil {
+			t.Fatalf("Unexpected error %s", err.Error())
+		}
 
-		if gotVersion != c.expectedVersion.Format(time.RFC1123) {
-			t.Fatalf("Expected version %s, got %s", c.expectedVersion.Format(time.RFC1123), gotVersion)
+		if !stored.Equal(c.expectedRevision) {
+			t.Fatalf("Expected stored revision %s, got %s", c.expectedRevision.ETag(), stored.ETag())
 		}
 
-		if string(c.expectedBody) != string(gotBody) {
-			t.Fatalf("Expected %s, got %s", string(c.expectedBody), string(gotBody))
+		if string(c.expectedBody) != string(mock.data[url]) {
+			t.Fatalf("Expected %s, got %s", string(c.expectedBody), string(mock.data[url]))
 		}
 
 	}
 }
 
 func TestOverwrite(t *testing.T) {
-	now := time.Now()
-	currentVersion := now.Format(time.RFC1123)
-	version, _ := time.Parse(time.RFC1123, currentVersion)
+
+	version, _ := time.Parse(time.RFC1123, time.Now().Format(time.RFC1123))
+	revision := Revision{}.next([]byte("hola"), version)
 
 	cases := []struct {
-		key          string
-		bucket       string
-		body         []byte
-		expectedBody []byte
+		key            string
+		bucket         string
+		body           []byte
+		expectedNumber int64
+		expectedBody   []byte
 	}{
 		// OK
 		{
-			key:          "test",
-			bucket:       "test",
-			body:         []byte("adios"),
-			expectedBody: []byte("adios"),
+			key:            "test",
+			bucket:         "test",
+			body:           []byte("adios"),
+			expectedNumber: 2,
+			expectedBody:   []byte("adios"),
 		},
 		// Not found
 		{
-			key:          "foo",
-			bucket:       "bar",
-			body:         []byte("adios"),
-			expectedBody: []byte("adios"),
+			key:            "foo",
+			bucket:         "bar",
+			body:           []byte("adios"),
+			expectedNumber: 1,
+			expectedBody:   []byte("adios"),
 		},
 	}
 
-	mock := &s3mock{
-		data: map[string][]byte{
-			"s3://test/test": []byte("hola"),
-		},
-		version: map[string]string{
-			"s3://test/test": version.Format(time.RFC1123),
-		},
-		t: t,
-	}
+	mock := testMock([]byte("hola"), revision, t)
 
 	for _, c := range cases {
 		s := &store{
 			key:    aws.String(c.key),
 			bucket: aws.String(c.bucket),
-			logger: log.New(os.Stdout, "", log.LstdFlags),
+			logger: slog.New(slog.NewTextHandler(os.Stdout, nil)),
 			s3:     mock,
 		}
 
 		buff := bytes.NewReader(c.body)
 
-		if err := s.Overwrite(int64(len(c.body)), buff); err != nil {
+		got, err := s.Overwrite(int64(len(c.body)), buff)
+		if err != nil {
 			t.Fatalf("Unexpected error %s", err.Error())
 		}
 
+		if got.Number != c.expectedNumber {
+			t.Fatalf("Expected revision number %d, got %d", c.expectedNumber, got.Number)
+		}
+
 		url := fmt.Sprintf("s3://%s/%s", c.bucket, c.key)
 		gotBody := mock.data[url]
 
@@ -380,3 +475,296 @@ func TestOverwrite(t *testing.T) {
 
 	}
 }
+
+func TestHistory(t *testing.T) {
+
+	version, _ := time.Parse(time.RFC1123, time.Now().Format(time.RFC1123))
+	revision := Revision{}.next([]byte("hola"), version)
+
+	mock := testMock([]byte("hola"), revision, t)
+
+	s := &store{
+		key:    aws.String("test"),
+		bucket: aws.String("test"),
+		logger: slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		s3:     mock,
+	}
+
+	second, err := s.SafePut(revision, version, 5, bytes.NewReader([]byte("adios")))
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	third, err := s.Overwrite(5, bytes.NewReader([]byte("hello")))
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	// Stored before the keys had the hash
+	mock.data["s3://test/test.history/00000000000000000001"] = []byte("hola")
+	mock.metadata["s3://test/test.history/00000000000000000001"] = revision.metadata()
+
+	var calls []string
+	s.Observe(func(operation string, elapsed time.Duration, err error) {
+		calls = append(calls, operation)
+	})
+
+	history, err := s.History()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	if len(history) != 3 || !history[0].Equal(third) || !history[1].Equal(second) || !history[2].Equal(revision) {
+		t.Fatalf("Expected history [%s %s %s], got %+v", third.ETag(), second.ETag(), revision.ETag(), history)
+	}
+
+	// Only the legacy revision is read
+	if strings.Join(calls, " ") != "ListObjectsV2 HeadObject" {
+		t.Fatalf("Expected the history listed, got the calls %v", calls)
+	}
+
+	cases := []struct {
+		number           int64
+		expectedBody     []byte
+		expectedRevision Revision
+		expectedError    error
+	}{
+		// OK
+		{
+			number:           2,
+			expectedBody:     []byte("adios"),
+			expectedRevision: second,
+		},
+		// Current
+		{
+			number:           3,
+			expectedBody:     []byte("hello"),
+			expectedRevision: third,
+		},
+		// Legacy
+		{
+			number:           1,
+			expectedBody:     []byte("hola"),
+			expectedRevision: revision,
+		},
+		// Not found
+		{
+			number:        4,
+			expectedError: ErrNotFound,
+		},
+	}
+
+	for _, c := range cases {
+		buff := &bytes.Buffer{}
+
+		if got, err := s.GetRevision(c.number, buff); err != nil {
+			if c.expectedError == nil {
+				t.Fatalf("Unexpected error %s", err.Error())
+			} else if c.expectedError != err {
+				t.Fatalf("Expected error %s, got %s", c.expectedError.Error(), err.Error())
+			}
+		} else if !got.Equal(c.expectedRevision) {
+			t.Fatalf("Expected revision %s, got %s", c.expectedRevision.ETag(), got.ETag())
+		} else if string(c.expectedBody) != buff.String() {
+			t.Fatalf("Expected %s, got %s", string(c.expectedBody), buff.String())
+		}
+	}
+}
+
+func TestConcurrentSafePut(t *testing.T) {
+
+	version, _ := time.Parse(time.RFC1123, time.Now().Format(time.RFC1123))
+	revision := Revision{}.next([]byte("hola"), version)
+
+	cases := []struct {
+		key      string
+		bucket   string
+		revision Revision
+	}{
+		// Existing file
+		{
+			key:      "test",
+			bucket:   "test",
+			revision: revision,
+		},
+		// Not found
+		{
+			key:    "foo",
+			bucket: "bar",
+		},
+	}
+
+	for _, c := range cases {
+		mock := testMock([]byte("hola"), revision, t)
+
+		s := &store{
+			key:    aws.String(c.key),
+			bucket: aws.String(c.bucket),
+			logger: slog.New(slog.NewTextHandler(os.Stdout, nil)),
+			s3:     mock,
+		}
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 10)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				body := []byte(fmt.Sprintf("adios %d", i))
+				_, err := s.SafePut(c.revision, version, int64(len(body)), bytes.NewReader(body))
+				errs <- err
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+
+		succeeded := 0
+		for err := range errs {
+			if err == nil {
+				succeeded++
+			} else if err != ErrVersionConflict {
+				t.Fatalf("Unexpected error %s", err.Error())
+			}
+		}
+
+		if succeeded != 1 {
+			t.Fatalf("Expected 1 successful put, got %d", succeeded)
+		}
+	}
+}
+
+func TestSafePutPreconditionFailed(t *testing.T) {
+
+	version, _ := time.Parse(time.RFC1123, time.Now().Format(time.RFC1123))
+	revision := Revision{}.next([]byte("hola"), version)
+
+	mock := testMock([]byte("hola"), revision, t)
+
+	// The object changes between the revision check and the write
+	racing := &racingS3mock{s3mock: mock, body: []byte("otro")}
+
+	s := &store{
+		key:    aws.String("test"),
+		bucket: aws.String("test"),
+		logger: slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		s3:     racing,
+	}
+
+	if _, err := s.SafePut(revision, version, 5, bytes.NewReader([]byte("adios"))); err != ErrVersionConflict {
+		t.Fatalf("Expected error %s, got %v", ErrVersionConflict.Error(), err)
+	}
+
+	if string(mock.data["s3://test/test"]) != "otro" {
+		t.Fatalf("Expected otro, got %s", string(mock.data["s3://test/test"]))
+	}
+}
+
+// racingS3mock writes the body right after the first HeadObject call.
+type racingS3mock struct {
+	*s3mock
+	body []byte
+}
+
+func (m *racingS3mock) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	resp, err := m.s3mock.HeadObject(input)
+	if err == nil && m.body != nil {
+		url := fmt.Sprintf("s3://%s/%s", *input.Bucket, *input.Key)
+		m.mutex.Lock()
+		m.data[url] = m.body
+		m.mutex.Unlock()
+		m.body = nil
+	}
+	return resp, err
+}
+
+func TestOverwritePreconditionFailed(t *testing.T) {
+
+	version, _ := time.Parse(time.RFC1123, time.Now().Format(time.RFC1123))
+	revision := Revision{}.next([]byte("hola"), version)
+
+	mock := testMock([]byte("hola"), revision, t)
+
+	// The object changes between the revision check and the write
+	racing := &racingS3mock{s3mock: mock, body: []byte("otro")}
+
+	s := &store{
+		key:    aws.String("test"),
+		bucket: aws.String("test"),
+		logger: slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		s3:     racing,
+	}
+
+	got, err := s.Overwrite(5, bytes.NewReader([]byte("adios")))
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	// Written again after reading the revision that changed
+	if string(mock.data["s3://test/test"]) != "adios" {
+		t.Fatalf("Expected adios, got %s", string(mock.data["s3://test/test"]))
+	}
+	if history := mock.data[fmt.Sprintf("s3://test/test%s%020d-%s", historySuffix, got.Number, got.Hash)]; string(history) != "adios" {
+		t.Fatalf("Expected adios in the history, got %s", string(history))
+	}
+}
+
+func TestConcurrentOverwrite(t *testing.T) {
+
+	version, _ := time.Parse(time.RFC1123, time.Now().Format(time.RFC1123))
+	revision := Revision{}.next([]byte("hola"), version)
+
+	mock := testMock([]byte("hola"), revision, t)
+
+	s := &store{
+		key:    aws.String("test"),
+		bucket: aws.String("test"),
+		logger: slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		s3:     mock,
+	}
+
+	var wg sync.WaitGroup
+	revisions := make(chan Revision, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body := []byte(fmt.Sprintf("adios %d", i))
+
+			var got Revision
+			var err error
+			if i%2 == 0 {
+				got, err = s.SafePut(revision, version, int64(len(body)), bytes.NewReader(body))
+			} else {
+				got, err = s.Overwrite(int64(len(body)), bytes.NewReader(body))
+			}
+			if err == nil {
+				revisions <- got
+			} else if err != ErrVersionConflict {
+				t.Errorf("Unexpected error %s", err.Error())
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(revisions)
+
+	// Every write has its own revision in the history
+	numbers := make(map[int64]bool)
+	for got := range revisions {
+		if numbers[got.Number] {
+			t.Fatalf("Expected a new revision for every write, got %d twice", got.Number)
+		}
+		numbers[got.Number] = true
+
+		buff := &bytes.Buffer{}
+		stored, err := s.GetRevision(got.Number, buff)
+		if err != nil {
+			t.Fatalf("Unexpected error %s", err.Error())
+		}
+		if !stored.Equal(got) {
+			t.Fatalf("Expected revision %s in the history, got %s", got.ETag(), stored.ETag())
+		}
+	}
+	if len(numbers) < 5 {
+		t.Fatalf("Expected every overwrite stored, got %d writes", len(numbers))
+	}
+}
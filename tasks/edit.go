@@ -0,0 +1,207 @@
+package tasks
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	// ErrNotFound when the task doesn't exist
+	ErrNotFound = errors.New("task not found")
+
+	// ErrInvalidSection when the section doesn't exist
+	ErrInvalidSection = errors.New("invalid section")
+
+	// ErrInvalidMove when moving a task inside itself
+	ErrInvalidMove = errors.New("invalid move")
+)
+
+// Add creates a task in the section, or in the parent task if the ID is not
+// empty. The position is the index among the other tasks, the task is added
+// after them if it's out of range.
+func (d *Document) Add(title string, section int, parent string, position int) (*Task, error) {
+	t := NewTask(title)
+	if err := d.insert(t, section, parent, position); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Remove deletes the task and its subtasks.
+func (d *Document) Remove(id string) (*Task, error) {
+	t := d.Task(id)
+	if t == nil {
+		return nil, ErrNotFound
+	}
+
+	d.remove(t)
+	d.number()
+	return t, nil
+}
+
+// Move moves the task and its subtasks to the section, or to the parent task
+// if the ID is not empty, in the position provided.
+func (d *Document) Move(id string, section int, parent string, position int) (*Task, error) {
+	t := d.Task(id)
+	if t == nil {
+		return nil, ErrNotFound
+	}
+
+	if parent == id || strings.HasPrefix(parent, id+".") {
+		return nil, ErrInvalidMove
+	}
+
+	// Find the destination before the IDs change
+	content, indent, err := d.container(section, parent)
+	if err != nil {
+		return nil, err
+	}
+
+	d.remove(t)
+	t.reindent(indent)
+	*content = insert(*content, t, position)
+	d.number()
+	return t, nil
+}
+
+// insert adds the task to the section or parent task.
+func (d *Document) insert(t *Task, section int, parent string, position int) error {
+	content, indent, err := d.container(section, parent)
+	if err != nil {
+		return err
+	}
+
+	t.indent = indent
+	*content = insert(*content, t, position)
+	d.number()
+	return nil
+}
+
+// container finds the content where the tasks are added and the indentation
+// they use.
+func (d *Document) container(section int, parent string) (*[]Node, string, error) {
+	if parent != "" {
+		p := d.Task(parent)
+		if p == nil {
+			return nil, "", ErrNotFound
+		}
+
+		if subtasks := p.Subtasks(); len(subtasks) > 0 {
+			return &p.Content, subtasks[0].indent, nil
+		}
+		return &p.Content, p.indent + strings.Repeat(" ", len(p.marker)+1), nil
+	}
+
+	if section < 0 || section >= len(d.Sections) {
+		return nil, "", ErrInvalidSection
+	}
+
+	s := d.Sections[section]
+	if tasks := s.Tasks(); len(tasks) > 0 {
+		return &s.Content, tasks[0].indent, nil
+	}
+	return &s.Content, "", nil
+}
+
+// remove takes the task out of its container.
+func (d *Document) remove(t *Task) {
+	for _, s := range d.Sections {
+		if removeFrom(&s.Content, t) {
+			return
+		}
+	}
+}
+
+func removeFrom(content *[]Node, t *Task) bool {
+	for i, node := range *content {
+		if node == Node(t) {
+			*content = append((*content)[:i], (*content)[i+1:]...)
+			return true
+		}
+		if child, ok := node.(*Task); ok && removeFrom(&child.Content, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// insert adds the task before the task in the position. Tasks added after the
+// last one go right after it, or before the trailing blank lines when there
+// are no tasks.
+func insert(content []Node, t *Task, position int) []Node {
+	index := len(content)
+	count := 0
+	for i, node := range content {
+		if _, ok := node.(*Task); !ok {
+			continue
+		}
+		if count == position {
+			index = i
+			break
+		}
+		count++
+		index = i + 1
+	}
+
+	if count == 0 && index == len(content) && index > 0 {
+		if text, ok := content[index-1].(Text); ok {
+			lines, blank := splitBlank(text)
+			if blank != "" {
+				content[index-1] = lines
+				content = append(content, blank)
+				if lines == "" {
+					index--
+					content = append(content[:index], content[index+1:]...)
+				}
+			}
+		}
+	}
+
+	content = append(content, nil)
+	copy(content[index+1:], content[index:])
+	content[index] = t
+	return content
+}
+
+// splitBlank splits the trailing blank lines from the text.
+func splitBlank(text Text) (Text, Text) {
+	lines := strings.SplitAfter(string(text), "\n")
+	i := len(lines)
+	for i > 0 && strings.TrimSpace(lines[i-1]) == "" {
+		i--
+	}
+	return Text(strings.Join(lines[:i], "")), Text(strings.Join(lines[i:], ""))
+}
+
+// reindent changes the indentation of the task and everything nested in it.
+func (t *Task) reindent(indent string) {
+	from := t.indent
+	if from == indent {
+		return
+	}
+
+	var walk func(*Task)
+	walk = func(task *Task) {
+		task.indent = indent + strings.TrimPrefix(task.indent, from)
+		for i, node := range task.Content {
+			switch n := node.(type) {
+			case *Task:
+				walk(n)
+			case Text:
+				task.Content[i] = n.reindent(from, indent)
+			}
+		}
+	}
+	walk(t)
+}
+
+// reindent replaces the indentation of the lines.
+func (t Text) reindent(from, to string) Text {
+	lines := strings.SplitAfter(string(t), "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) != "" && strings.HasPrefix(line, from) {
+			lines[i] = to + strings.TrimPrefix(line, from)
+		}
+	}
+	return Text(strings.Join(lines, ""))
+}
@@ -0,0 +1,127 @@
+package tasks
+
+import (
+	"testing"
+)
+
+func TestEdit(t *testing.T) {
+
+	cases := []struct {
+		content       string
+		change        func(*Document) (*Task, error)
+		expected      string
+		expectedID    string
+		expectedError error
+	}{
+		// Add to a section
+		{
+			content:    "# Work\n- [ ] Write\n\n# Home\n",
+			change:     func(d *Document) (*Task, error) { return d.Add("Send", 1, "", -1) },
+			expected:   "# Work\n- [ ] Write\n- [ ] Send\n\n# Home\n",
+			expectedID: "1.2",
+		},
+		// Add first
+		{
+			content:    "# Work\n- [ ] Write\n",
+			change:     func(d *Document) (*Task, error) { return d.Add("Send", 1, "", 0) },
+			expected:   "# Work\n- [ ] Send\n- [ ] Write\n",
+			expectedID: "1.1",
+		},
+		// Add to a section without tasks
+		{
+			content:    "# Work\nSome text\n\n# Home\n",
+			change:     func(d *Document) (*Task, error) { return d.Add("Send", 1, "", -1) },
+			expected:   "# Work\nSome text\n- [ ] Send\n\n# Home\n",
+			expectedID: "1.1",
+		},
+		// Add to an empty file
+		{
+			content:    "",
+			change:     func(d *Document) (*Task, error) { return d.Add("Send", 0, "", -1) },
+			expected:   "- [ ] Send\n",
+			expectedID: "0.1",
+		},
+		// Add subtask
+		{
+			content:    "1. [ ] Write\n",
+			change:     func(d *Document) (*Task, error) { return d.Add("Collect", 0, "0.1", -1) },
+			expected:   "1. [ ] Write\n   - [ ] Collect\n",
+			expectedID: "0.1.1",
+		},
+		// Add to a missing parent
+		{
+			content:       "- [ ] Write\n",
+			change:        func(d *Document) (*Task, error) { return d.Add("Collect", 0, "0.2", -1) },
+			expected:      "- [ ] Write\n",
+			expectedError: ErrNotFound,
+		},
+		// Add to a missing section
+		{
+			content:       "- [ ] Write\n",
+			change:        func(d *Document) (*Task, error) { return d.Add("Collect", 1, "", -1) },
+			expected:      "- [ ] Write\n",
+			expectedError: ErrInvalidSection,
+		},
+		// Remove with subtasks
+		{
+			content:    "- [ ] Write\n  - [ ] Collect\n    notes\n- [ ] Send\n",
+			change:     func(d *Document) (*Task, error) { return d.Remove("0.1") },
+			expected:   "- [ ] Send\n",
+			expectedID: "0.1",
+		},
+		// Remove missing
+		{
+			content:       "- [ ] Write\n",
+			change:        func(d *Document) (*Task, error) { return d.Remove("0.2") },
+			expected:      "- [ ] Write\n",
+			expectedError: ErrNotFound,
+		},
+		// Move to another section
+		{
+			content:    "# Work\n- [ ] Write\n- [ ] Send\n# Home\n- [ ] Cook\n",
+			change:     func(d *Document) (*Task, error) { return d.Move("1.1", 2, "", 0) },
+			expected:   "# Work\n- [ ] Send\n# Home\n- [ ] Write\n- [ ] Cook\n",
+			expectedID: "2.1",
+		},
+		// Move into a task
+		{
+			content:    "- [ ] Write\n  notes\n  - [x] Collect\n- [ ] Send\n",
+			change:     func(d *Document) (*Task, error) { return d.Move("0.1", 0, "0.2", -1) },
+			expected:   "- [ ] Send\n  - [ ] Write\n    notes\n    - [x] Collect\n",
+			expectedID: "0.1.1",
+		},
+		// Move out of a task
+		{
+			content:    "- [ ] Write\n  - [x] Collect\n",
+			change:     func(d *Document) (*Task, error) { return d.Move("0.1.1", 0, "", -1) },
+			expected:   "- [ ] Write\n- [x] Collect\n",
+			expectedID: "0.2",
+		},
+		// Move inside itself
+		{
+			content:       "- [ ] Write\n  - [x] Collect\n",
+			change:        func(d *Document) (*Task, error) { return d.Move("0.1", 0, "0.1.1", -1) },
+			expected:      "- [ ] Write\n  - [x] Collect\n",
+			expectedError: ErrInvalidMove,
+		},
+	}
+
+	for _, c := range cases {
+		doc := Parse([]byte(c.content))
+
+		task, err := c.change(doc)
+		if err != nil {
+			if c.expectedError == nil {
+				t.Fatalf("Unexpected error %s", err.Error())
+			} else if c.expectedError != err {
+				t.Fatalf("Expected error %s, got %s", c.expectedError.Error(), err.Error())
+			}
+		} else if task.ID != c.expectedID {
+			t.Fatalf("Expected ID %s, got %s", c.expectedID, task.ID)
+		}
+
+		if got := doc.String(); got != c.expected {
+			t.Fatalf("Expected %q, got %q", c.expected, got)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+// Package tasks parses the TODO Markdown file into sections and tasks.
+//
+// Headings start sections, GFM checkboxes (- [ ] and - [x]) are tasks and
+// the lists nested in a task are its subtasks. Everything else is kept as
+// free text. Every line is kept as it was read, so writing a document that
+// didn't change produces the same bytes; only the sections and tasks that
+// changed are rendered again.
+package tasks
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	headingLine = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	taskLine    = regexp.MustCompile(`^([ \t]*)([-*+]|[0-9]{1,9}[.)])[ \t]+\[([ xX])\](?:[ \t]+(.*?))?[ \t]*$`)
+	fenceLine   = regexp.MustCompile("^[ \t]*(```|~~~)")
+)
+
+// Document is a parsed TODO file.
+type Document struct {
+	// Sections of the file. The first one has no heading and holds the
+	// content before the first heading.
+	Sections []*Section
+}
+
+// Section is the content under a heading.
+type Section struct {
+	Title   string
+	Level   int
+	Content []Node
+
+	parsed section
+}
+
+// section is a heading as it was read
+type section struct {
+	title string
+	level int
+	line  string
+}
+
+// Task is a checkbox item. The content includes its subtasks and any other
+// text nested in the item.
+type Task struct {
+	// ID is the position of the task: the section number followed by the
+	// task number in each level, counting from 1. Sections are counted
+	// from 0, which is the content before the first heading.
+	ID      string
+	Title   string
+	Done    bool
+	Content []Node
+
+	indent string
+	marker string
+	parsed task
+}
+
+// task is a checkbox line as it was read
+type task struct {
+	title  string
+	done   bool
+	indent string
+	line   string
+}
+
+// Text is free text, kept as it was read.
+type Text string
+
+// Node is the content of sections and tasks: a *Task or a Text.
+type Node interface {
+	write(*bytes.Buffer)
+}
+
+// NewTask creates a pending task.
+func NewTask(title string) *Task {
+	return &Task{
+		Title:  title,
+		marker: "-",
+	}
+}
+
+// Parse reads the document.
+func Parse(content []byte) *Document {
+	p := &parser{
+		doc: &Document{Sections: []*Section{{}}},
+	}
+
+	for _, line := range strings.SplitAfter(string(content), "\n") {
+		if line != "" {
+			p.parse(line)
+		}
+	}
+	p.flush(nil)
+
+	p.doc.number()
+	return p.doc
+}
+
+// Bytes writes the document.
+func (d *Document) Bytes() []byte {
+	buff := &bytes.Buffer{}
+	for _, s := range d.Sections {
+		s.write(buff)
+	}
+	return buff.Bytes()
+}
+
+// String writes the document.
+func (d *Document) String() string {
+	return string(d.Bytes())
+}
+
+// Task finds a task by ID.
+func (d *Document) Task(id string) *Task {
+	for _, s := range d.Sections {
+		if t := find(s.Content, id); t != nil {
+			return t
+		}
+	}
+	return nil
+}
+
+// Tasks returns the tasks of the section, without subtasks.
+func (s *Section) Tasks() []*Task {
+	return tasksIn(s.Content)
+}
+
+// Same returns true if both tasks were read from the same line and still
+// have the same title and state. The IDs are positions, so the same ID in
+// another revision can be another task.
+func (t *Task) Same(other *Task) bool {
+	return t.parsed.line == other.parsed.line && t.Title == other.Title && t.Done == other.Done
+}
+
+// Same returns true if both sections have the same heading, to check the
+// section in that position is still the same one in another revision.
+func (s *Section) Same(other *Section) bool {
+	return s.Title == other.Title && s.Level == other.Level
+}
+
+// Subtasks returns the tasks nested in the task.
+func (t *Task) Subtasks() []*Task {
+	return tasksIn(t.Content)
+}
+
+// number assigns the IDs of all tasks.
+func (d *Document) number() {
+	for i, s := range d.Sections {
+		numberTasks(s.Content, fmt.Sprintf("%d", i))
+	}
+}
+
+func numberTasks(content []Node, prefix string) {
+	for i, t := range tasksIn(content) {
+		t.ID = fmt.Sprintf("%s.%d", prefix, i+1)
+		numberTasks(t.Content, t.ID)
+	}
+}
+
+func find(content []Node, id string) *Task {
+	for _, t := range tasksIn(content) {
+		if t.ID == id {
+			return t
+		}
+		if strings.HasPrefix(id, t.ID+".") {
+			return find(t.Content, id)
+		}
+	}
+	return nil
+}
+
+func tasksIn(content []Node) []*Task {
+	var tasks []*Task
+	for _, node := range content {
+		if t, ok := node.(*Task); ok {
+			tasks = append(tasks, t)
+		}
+	}
+	return tasks
+}
+
+func (s *Section) write(buff *bytes.Buffer) {
+	switch {
+	case s.Level == 0:
+		// The content before the first heading
+	case s.Title == s.parsed.title && s.Level == s.parsed.level:
+		writeLine(buff, s.parsed.line)
+	default:
+		writeLine(buff, strings.Repeat("#", s.Level)+" "+s.Title+"\n")
+	}
+
+	for _, node := range s.Content {
+		node.write(buff)
+	}
+}
+
+func (t *Task) write(buff *bytes.Buffer) {
+	if t.parsed.line != "" && t.Title == t.parsed.title && t.Done == t.parsed.done && t.indent == t.parsed.indent {
+		writeLine(buff, t.parsed.line)
+	} else {
+		check := " "
+		if t.Done {
+			check = "x"
+		}
+		writeLine(buff, fmt.Sprintf("%s%s [%s] %s\n", t.indent, t.marker, check, t.Title))
+	}
+
+	for _, node := range t.Content {
+		node.write(buff)
+	}
+}
+
+func (t Text) write(buff *bytes.Buffer) {
+	writeLine(buff, string(t))
+}
+
+// writeLine adds the line after the previous one, which could miss the new
+// line character if it was the last one of the file.
+func writeLine(buff *bytes.Buffer, line string) {
+	if buff.Len() > 0 && buff.Bytes()[buff.Len()-1] != '\n' {
+		buff.WriteByte('\n')
+	}
+	buff.WriteString(line)
+}
+
+// parser builds the document line by line
+type parser struct {
+	doc *Document
+
+	// open tasks, from the outermost to the innermost
+	open []*Task
+
+	// blank lines waiting for the next line to know where they belong
+	blank []string
+
+	// inside a code fence, the fence marker
+	fence string
+}
+
+func (p *parser) parse(line string) {
+	content := strings.TrimRight(line, "\r\n")
+
+	if p.fence != "" {
+		if strings.HasPrefix(strings.TrimLeft(content, " \t"), p.fence) {
+			p.fence = ""
+		}
+		p.text(line)
+		return
+	}
+
+	if strings.TrimSpace(content) == "" {
+		p.blank = append(p.blank, line)
+		return
+	}
+
+	if match := fenceLine.FindStringSubmatch(content); match != nil {
+		p.close(width(indentOf(content)))
+		p.text(line)
+		p.fence = match[1]
+		return
+	}
+
+	if match := headingLine.FindStringSubmatch(content); match != nil {
+		p.flush(nil)
+		p.open = nil
+		p.doc.Sections = append(p.doc.Sections, &Section{
+			Title:  match[2],
+			Level:  len(match[1]),
+			parsed: section{title: match[2], level: len(match[1]), line: line},
+		})
+		return
+	}
+
+	if match := taskLine.FindStringSubmatch(content); match != nil {
+		t := &Task{
+			Title:  match[4],
+			Done:   match[3] != " ",
+			indent: match[1],
+			marker: match[2],
+		}
+		t.parsed = task{title: t.Title, done: t.Done, indent: t.indent, line: line}
+
+		p.close(width(t.indent))
+		parent := p.parent()
+		p.flush(parent)
+		*parent = append(*parent, t)
+		p.open = append(p.open, t)
+		return
+	}
+
+	p.close(width(indentOf(content)))
+	p.text(line)
+}
+
+// text adds the line to the innermost open container.
+func (p *parser) text(line string) {
+	parent := p.parent()
+	p.flush(parent)
+	appendText(parent, line)
+}
+
+// close closes the tasks that can't contain a line with the indentation.
+func (p *parser) close(indent int) {
+	for len(p.open) > 0 && width(p.open[len(p.open)-1].indent) >= indent {
+		p.open = p.open[:len(p.open)-1]
+	}
+}
+
+// parent returns the content of the innermost open container.
+func (p *parser) parent() *[]Node {
+	if len(p.open) > 0 {
+		return &p.open[len(p.open)-1].Content
+	}
+	return &p.doc.Sections[len(p.doc.Sections)-1].Content
+}
+
+// flush adds the pending blank lines to the content, or to the current
+// section when it's nil.
+func (p *parser) flush(content *[]Node) {
+	if len(p.blank) == 0 {
+		return
+	}
+	if content == nil {
+		content = &p.doc.Sections[len(p.doc.Sections)-1].Content
+	}
+	for _, line := range p.blank {
+		appendText(content, line)
+	}
+	p.blank = nil
+}
+
+// appendText adds the line to the content, joining consecutive text.
+func appendText(content *[]Node, line string) {
+	if n := len(*content); n > 0 {
+		if text, ok := (*content)[n-1].(Text); ok {
+			(*content)[n-1] = text + Text(line)
+			return
+		}
+	}
+	*content = append(*content, Text(line))
+}
+
+// indentOf returns the leading spaces and tabs of the line.
+func indentOf(line string) string {
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+// width returns the width of the indentation, tabs count as four spaces.
+func width(indent string) int {
+	return len(strings.Replace(indent, "\t", "    ", -1))
+}
@@ -0,0 +1,183 @@
+package tasks
+
+import (
+	"testing"
+)
+
+const testDocument = `Notes before the first heading
+
+# Work
+- [ ] Write the report
+  - [x] Collect the numbers
+  - [ ] Draw the charts
+    more details about the charts
+- [X] Send the invoice
+
+Some free text.
+
+## Code
+` + "```" + `
+- [ ] not a task
+# not a heading
+` + "```" + `
+* [ ] Deploy
+
+# Home #
+1. [ ] Buy milk
+2. [x] Fix the door`
+
+func TestRoundTrip(t *testing.T) {
+
+	cases := []string{
+		testDocument,
+		"",
+		"\n\n",
+		"just text",
+		"- [ ] no new line at the end",
+		"- [ ] task\r\n  - [x] subtask\r\n",
+		"# Heading\n\n\n- [ ] task\n\n\ntext\n",
+		"- [ ] task\n\t- [ ] tab indented\n- plain item\n  - [ ] under a plain item\n",
+		"```\nunclosed fence\n- [ ] text\n",
+		"#not a heading\n-[ ] not a task\n- [ ]\n",
+	}
+
+	for _, c := range cases {
+		if got := Parse([]byte(c)).String(); got != c {
+			t.Fatalf("Expected %q, got %q", c, got)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+
+	doc := Parse([]byte(testDocument))
+
+	if len(doc.Sections) != 4 {
+		t.Fatalf("Expected 4 sections, got %d", len(doc.Sections))
+	}
+
+	cases := []struct {
+		section  int
+		title    string
+		level    int
+		expected []string
+	}{
+		// Before the first heading
+		{
+			section: 0,
+		},
+		// Nested tasks
+		{
+			section:  1,
+			title:    "Work",
+			level:    1,
+			expected: []string{"Write the report", "Send the invoice"},
+		},
+		// Code fence
+		{
+			section:  2,
+			title:    "Code",
+			level:    2,
+			expected: []string{"Deploy"},
+		},
+		// Numbered list and closing hashes
+		{
+			section:  3,
+			title:    "Home",
+			level:    1,
+			expected: []string{"Buy milk", "Fix the door"},
+		},
+	}
+
+	for _, c := range cases {
+		s := doc.Sections[c.section]
+		if s.Title != c.title || s.Level != c.level {
+			t.Fatalf("Expected section %q level %d, got %q level %d", c.title, c.level, s.Title, s.Level)
+		}
+
+		tasks := s.Tasks()
+		if len(tasks) != len(c.expected) {
+			t.Fatalf("Expected %d tasks in %q, got %d", len(c.expected), c.title, len(tasks))
+		}
+		for i, task := range tasks {
+			if task.Title != c.expected[i] {
+				t.Fatalf("Expected task %q, got %q", c.expected[i], task.Title)
+			}
+		}
+	}
+
+	ids := []struct {
+		id    string
+		title string
+		done  bool
+	}{
+		{"1.1", "Write the report", false},
+		{"1.1.1", "Collect the numbers", true},
+		{"1.1.2", "Draw the charts", false},
+		{"1.2", "Send the invoice", true},
+		{"2.1", "Deploy", false},
+		{"3.2", "Fix the door", true},
+	}
+
+	for _, c := range ids {
+		task := doc.Task(c.id)
+		if task == nil {
+			t.Fatalf("Expected task %s", c.id)
+		}
+		if task.Title != c.title || task.Done != c.done {
+			t.Fatalf("Expected task %s to be %q done %t, got %q done %t", c.id, c.title, c.done, task.Title, task.Done)
+		}
+	}
+
+	if task := doc.Task("1.3"); task != nil {
+		t.Fatalf("Expected no task 1.3, got %q", task.Title)
+	}
+
+	if text, ok := doc.Task("1.1.2").Content[0].(Text); !ok || text != "    more details about the charts\n" {
+		t.Fatalf("Expected the details as text of the task, got %+v", doc.Task("1.1.2").Content)
+	}
+}
+
+func TestChanges(t *testing.T) {
+
+	cases := []struct {
+		content  string
+		change   func(*Document)
+		expected string
+	}{
+		// Complete
+		{
+			content:  "# Work\n*   [ ]  Write   \n- [ ] Send\n",
+			change:   func(d *Document) { d.Task("1.1").Done = true },
+			expected: "# Work\n* [x] Write\n- [ ] Send\n",
+		},
+		// Rename subtask
+		{
+			content:  "- [ ] Write\n  - [x] Collect\n",
+			change:   func(d *Document) { d.Task("0.1.1").Title = "Collect all" },
+			expected: "- [ ] Write\n  - [x] Collect all\n",
+		},
+		// Rename heading
+		{
+			content:  "## Work ##\n- [ ] Write\n",
+			change:   func(d *Document) { d.Sections[1].Title = "Job" },
+			expected: "## Job\n- [ ] Write\n",
+		},
+		// Add after the last line
+		{
+			content: "- [ ] Write",
+			change: func(d *Document) {
+				d.Sections[0].Content = append(d.Sections[0].Content, NewTask("Send"))
+			},
+			expected: "- [ ] Write\n- [ ] Send\n",
+		},
+	}
+
+	for _, c := range cases {
+		doc := Parse([]byte(c.content))
+		c.change(doc)
+		if got := doc.String(); got != c.expected {
+			t.Fatalf("Expected %q, got %q", c.expected, got)
+		}
+	}
+}